@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"context"
+	goErr "errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ApprovalAnnotation on a namespace holds the comma separated names of its clusters
+	// approved for registration. Approvals set on clusters themselves are ignored, as tenants
+	// may write them.
+	ApprovalAnnotation = "capi-to-argocd/approved"
+	// ApprovedByAnnotation optionally names the approver next to ApprovalAnnotation. It is
+	// also recorded on kubeconfig secrets, Shoots and ArgoSecrets of approved clusters.
+	ApprovedByAnnotation = "capi-to-argocd/approved-by"
+	// ApprovalExpiresAnnotation optionally holds the RFC3339 time after which an approval
+	// no longer holds. It is also recorded on ArgoSecrets of approved clusters.
+	ApprovalExpiresAnnotation = "capi-to-argocd/approval-expires"
+	// ApprovalStateAnnotation on a kubeconfig secret or Shoot records the approval state of
+	// its cluster. ArgoSecrets of approved clusters carry it as well, which keeps them registered.
+	ApprovalStateAnnotation = "capi-to-argocd/approval-state"
+
+	// ApprovedClusterLabel on an ArgoSecret of an approved cluster holds the name of the
+	// cluster, so its approval is found whatever kubeconfig secret it is sourced from.
+	ApprovedClusterLabel = "capi-to-argocd/approved-cluster"
+
+	// Approval states.
+	ApprovalStatePending  = "Pending"
+	ApprovalStateApproved = "Approved"
+	ApprovalStateExpired  = "Expired"
+)
+
+var (
+	// ApprovalRules select clusters that are only registered once approved.
+	ApprovalRules []ClusterSelector
+
+	// ErrApprovalPending is returned for clusters held back until approved.
+	ErrApprovalPending = goErr.New("cluster is waiting for approval")
+)
+
+// ClusterApproval describes the approval of a cluster registration.
+type ClusterApproval struct {
+	Approver  string
+	ExpiresAt *time.Time
+}
+
+// Expired reports whether the approval can no longer be used.
+func (a *ClusterApproval) Expired(now time.Time) bool {
+	return a.ExpiresAt != nil && now.After(*a.ExpiresAt)
+}
+
+// ValidateApprovalRules validates the selector of every approval rule.
+func ValidateApprovalRules(rules []ClusterSelector) error {
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("approval rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// ApprovalRequired reports whether a cluster is selected by any approval rule.
+func ApprovalRequired(namespace string, clusterLabels map[string]string) bool {
+	for _, rule := range ApprovalRules {
+		if rule.Matches(namespace, clusterLabels) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseApproval returns the approval of a cluster held by annotations, or nil when the
+// cluster is not named among the approved ones.
+func ParseApproval(annotations map[string]string, cluster string) (*ClusterApproval, error) {
+	approved := false
+	for _, name := range strings.Split(annotations[ApprovalAnnotation], ",") {
+		if strings.TrimSpace(name) == cluster {
+			approved = true
+			break
+		}
+	}
+	if !approved {
+		return nil, nil
+	}
+	approval := &ClusterApproval{Approver: annotations[ApprovedByAnnotation]}
+	if v, ok := annotations[ApprovalExpiresAnnotation]; ok {
+		expiresAt, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", ApprovalExpiresAnnotation, err)
+		}
+		approval.ExpiresAt = &expiresAt
+	}
+	return approval, nil
+}
+
+// ApproveCluster holds back clusters requiring approval until approved, recording the
+// approval state on the target, such as the kubeconfig secret or the Gardener Shoot,
+// along with events. Approvals are only read from the namespace of the cluster, never
+// from the cluster or the target, and only hold for the clusters they name. Once approved, a cluster stays registered until its
+// approval expires, the approval being recorded on its ArgoSecrets, which tenants cannot
+// write either.
+func (r *Capi2Argo) ApproveCluster(ctx context.Context, log logr.Logger, capiCluster *CapiCluster, source client.ObjectKey, target client.Object) (*ClusterApproval, error) {
+	if !ApprovalRequired(capiCluster.Namespace, capiCluster.Labels) {
+		return nil, nil
+	}
+	cluster := types.NamespacedName{Name: capiCluster.Name, Namespace: capiCluster.Namespace}
+	registered, err := r.RegisteredApproval(ctx, cluster, source)
+	if err != nil {
+		log.Error(err, "Failed to fetch approval of registered ArgoSecrets")
+		return nil, err
+	}
+	if registered != nil && !registered.Expired(time.Now()) {
+		return registered, nil
+	}
+
+	var namespace corev1.Namespace
+	if err := r.Get(ctx, types.NamespacedName{Name: capiCluster.Namespace}, &namespace); client.IgnoreNotFound(err) != nil {
+		log.Error(err, "Failed to fetch namespace of cluster")
+		return nil, err
+	}
+	approval, err := ParseApproval(namespace.Annotations, capiCluster.Name)
+	switch {
+	case err != nil:
+		log.Info("Cluster approval is invalid", "message", err.Error())
+		if updateErr := r.updateApprovalState(ctx, target, ApprovalStatePending, "", corev1.EventTypeWarning, "InvalidApproval", err.Error()); updateErr != nil {
+			log.Error(updateErr, "Failed to update approval state")
+			return nil, updateErr
+		}
+		return nil, ErrApprovalPending
+	case approval == nil:
+		log.Info("Cluster is waiting for approval")
+		msg := fmt.Sprintf("Registration is waiting for approval, add %s to %s on namespace %s", capiCluster.Name, ApprovalAnnotation, capiCluster.Namespace)
+		if err := r.updateApprovalState(ctx, target, ApprovalStatePending, "", corev1.EventTypeNormal, "ApprovalPending", msg); err != nil {
+			log.Error(err, "Failed to update approval state")
+			return nil, err
+		}
+		return nil, ErrApprovalPending
+	case approval.Expired(time.Now()):
+		log.Info("Cluster approval expired", "expiresAt", approval.ExpiresAt)
+		msg := fmt.Sprintf("Approval expired at %s", approval.ExpiresAt.Format(time.RFC3339))
+		if err := r.updateApprovalState(ctx, target, ApprovalStateExpired, "", corev1.EventTypeWarning, "ApprovalExpired", msg); err != nil {
+			log.Error(err, "Failed to update approval state")
+			return nil, err
+		}
+		return nil, ErrApprovalPending
+	}
+
+	log.Info("Cluster is approved", "approver", approval.Approver)
+	msg := "Registration approved"
+	if approval.Approver != "" {
+		msg = fmt.Sprintf("Registration approved by %s", approval.Approver)
+	}
+	if err := r.updateApprovalState(ctx, target, ApprovalStateApproved, approval.Approver, corev1.EventTypeNormal, "Approved", msg); err != nil {
+		log.Error(err, "Failed to update approval state")
+		return nil, err
+	}
+	return approval, nil
+}
+
+// RegisteredApproval returns the approval recorded on the ArgoSecrets of a cluster in
+// any hub, or nil when none was approved. ArgoSecrets approved before they were labeled
+// with their cluster are matched by their source kubeconfig secret instead.
+func (r *Capi2Argo) RegisteredApproval(ctx context.Context, cluster types.NamespacedName, source client.ObjectKey) (*ClusterApproval, error) {
+	listOption := client.MatchingLabels{
+		"capi-to-argocd/owned":             "true",
+		"capi-to-argocd/cluster-namespace": cluster.Namespace,
+	}
+	for _, hub := range r.hubNames() {
+		hubClient, err := r.HubClient(ctx, hub)
+		if err != nil {
+			return nil, err
+		}
+		secretList := &corev1.SecretList{}
+		if err := hubClient.List(ctx, secretList, listOption); err != nil {
+			return nil, err
+		}
+		for _, argoSecret := range secretList.Items {
+			name, ok := argoSecret.Labels[ApprovedClusterLabel]
+			if (ok && name != cluster.Name) || (!ok && argoSecret.Labels["capi-to-argocd/cluster-secret-name"] != source.Name) {
+				continue
+			}
+			if argoSecret.Annotations[ApprovalStateAnnotation] != ApprovalStateApproved {
+				continue
+			}
+			approval := &ClusterApproval{Approver: argoSecret.Annotations[ApprovedByAnnotation]}
+			if v, ok := argoSecret.Annotations[ApprovalExpiresAnnotation]; ok {
+				expiresAt, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					continue
+				}
+				approval.ExpiresAt = &expiresAt
+			}
+			return approval, nil
+		}
+	}
+	return nil, nil
+}
+
+// updateApprovalState records a new approval state on the target along with an event.
+// Unchanged states are left alone, so events are only emitted on transitions.
+func (r *Capi2Argo) updateApprovalState(ctx context.Context, target client.Object, state, approver, eventType, reason, msg string) error {
+	annotations := target.GetAnnotations()
+	if annotations[ApprovalStateAnnotation] == state {
+		return nil
+	}
+	r.recordEvent(target, eventType, reason, msg)
+	patch := client.MergeFrom(target.DeepCopyObject().(client.Object))
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[ApprovalStateAnnotation] = state
+	if approver != "" {
+		annotations[ApprovedByAnnotation] = approver
+	}
+	target.SetAnnotations(annotations)
+	return r.Patch(ctx, target, patch)
+}
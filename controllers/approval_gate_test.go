@@ -0,0 +1,324 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestParseApproval(t *testing.T) {
+	t.Parallel()
+	expiresAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		testName          string
+		testMock          map[string]string
+		expected          *ClusterApproval
+		testExpectedError bool
+	}{
+		{"test not approved", map[string]string{}, nil, false},
+		{"test approval withdrawn", map[string]string{ApprovalAnnotation: ""}, nil, false},
+		{"test other cluster approved", map[string]string{ApprovalAnnotation: "other"}, nil, false},
+		{"test namespace-wide value", map[string]string{ApprovalAnnotation: "true"}, nil, false},
+		{"test approved", map[string]string{ApprovalAnnotation: "test"}, &ClusterApproval{}, false},
+		{"test approved in list", map[string]string{ApprovalAnnotation: "other, test"}, &ClusterApproval{}, false},
+		{"test approved by", map[string]string{ApprovalAnnotation: "test", ApprovedByAnnotation: "alice", ApprovalExpiresAnnotation: "2030-01-01T00:00:00Z"},
+			&ClusterApproval{Approver: "alice", ExpiresAt: &expiresAt}, false},
+		{"test invalid expiry", map[string]string{ApprovalAnnotation: "test", ApprovalExpiresAnnotation: "tomorrow"}, nil, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			approval, err := ParseApproval(tt.testMock, "test")
+			if !tt.testExpectedError {
+				assert.Nil(t, err)
+			} else {
+				assert.NotNil(t, err)
+			}
+			assert.Equal(t, tt.expected, approval)
+		})
+	}
+}
+
+// mockApprovalNamespace returns a namespace holding given annotations.
+func mockApprovalNamespace(name string, annotations map[string]string) map[string]*corev1.Namespace {
+	return map[string]*corev1.Namespace{name: {ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}}
+}
+
+// mockApprovedArgoSecret returns an ArgoSecret recording the approval of a cluster.
+func mockApprovedArgoSecret(labels, annotations map[string]string) *corev1.Secret {
+	s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name: "cluster-test", Namespace: ArgoNamespace,
+		Labels: map[string]string{
+			"capi-to-argocd/owned":               "true",
+			"capi-to-argocd/cluster-secret-name": "test-kubeconfig",
+			"capi-to-argocd/cluster-namespace":   "production",
+		},
+		Annotations: map[string]string{ApprovalStateAnnotation: ApprovalStateApproved},
+	}}
+	for key, value := range labels {
+		s.Labels[key] = value
+	}
+	for key, value := range annotations {
+		s.Annotations[key] = value
+	}
+	return s
+}
+
+func TestApproveCluster(t *testing.T) {
+	old := ApprovalRules
+	defer func() { ApprovalRules = old }()
+	ApprovalRules = []ClusterSelector{
+		{Namespaces: []string{"production"}},
+		{LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "critical"}}},
+	}
+
+	expired := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	tests := []struct {
+		testName        string
+		namespace       string
+		clusterLabels   map[string]string
+		clusterAnno     map[string]string
+		namespaceAnno   map[string]string
+		secretAnno      map[string]string
+		expectedErr     error
+		expectedState   string
+		expectedReason  string
+		expectedApprove *ClusterApproval
+	}{
+		{"test approval not required", "staging", nil, nil, nil, nil, nil, "", "", nil},
+		{"test pending", "production", nil, nil, nil, nil, ErrApprovalPending, ApprovalStatePending, "ApprovalPending", nil},
+		{"test pending by label", "staging", map[string]string{"tier": "critical"}, nil, nil, nil, ErrApprovalPending, ApprovalStatePending, "ApprovalPending", nil},
+		{"test approved on namespace", "production", nil, nil, map[string]string{ApprovalAnnotation: "test", ApprovedByAnnotation: "alice"}, nil,
+			nil, ApprovalStateApproved, "Approved", &ClusterApproval{Approver: "alice"}},
+		{"test other cluster approved", "production", nil, nil, map[string]string{ApprovalAnnotation: "other"}, nil,
+			ErrApprovalPending, ApprovalStatePending, "ApprovalPending", nil},
+		{"test self-approval on cluster", "production", nil, map[string]string{ApprovalAnnotation: "test", ApprovedByAnnotation: "mallory"}, nil, nil,
+			ErrApprovalPending, ApprovalStatePending, "ApprovalPending", nil},
+		{"test self-approval on secret", "production", nil, nil, nil, map[string]string{ApprovalAnnotation: "test"},
+			ErrApprovalPending, ApprovalStatePending, "ApprovalPending", nil},
+		{"test expired approval", "production", nil, nil, map[string]string{ApprovalAnnotation: "test", ApprovalExpiresAnnotation: expired}, nil,
+			ErrApprovalPending, ApprovalStateExpired, "ApprovalExpired", nil},
+		{"test invalid approval", "production", nil, nil, map[string]string{ApprovalAnnotation: "test", ApprovalExpiresAnnotation: "tomorrow"}, nil,
+			ErrApprovalPending, ApprovalStatePending, "InvalidApproval", nil},
+		{"test forged approval state", "production", nil, nil, nil, map[string]string{ApprovalStateAnnotation: ApprovalStateApproved, ApprovedByAnnotation: "bob"},
+			ErrApprovalPending, ApprovalStatePending, "ApprovalPending", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			capiCluster := NewCapiCluster("test", tt.namespace)
+			capiCluster.Labels, capiCluster.Annotations = tt.clusterLabels, tt.clusterAnno
+			capiSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Name: "test-kubeconfig", Namespace: tt.namespace, ResourceVersion: "1", Annotations: tt.secretAnno,
+			}}
+			key := types.NamespacedName{Name: capiSecret.Name, Namespace: capiSecret.Namespace}
+			c := &mockClient{
+				secrets:    map[types.NamespacedName]*corev1.Secret{key: capiSecret.DeepCopy()},
+				namespaces: mockApprovalNamespace(tt.namespace, tt.namespaceAnno),
+			}
+			recorder := record.NewFakeRecorder(10)
+			r := &Capi2Argo{Client: c, Log: logr.Discard(), Recorder: recorder}
+
+			approval, err := r.ApproveCluster(context.Background(), logr.Discard(), capiCluster, key, capiSecret)
+			assert.Equal(t, tt.expectedErr, err)
+			assert.Equal(t, tt.expectedApprove, approval)
+			assert.Equal(t, tt.expectedState, c.secrets[key].Annotations[ApprovalStateAnnotation])
+			if tt.expectedReason == "" {
+				assert.Empty(t, recorder.Events)
+			} else {
+				assert.Contains(t, <-recorder.Events, tt.expectedReason)
+			}
+		})
+	}
+}
+
+func TestApproveClusterTransitions(t *testing.T) {
+	old := ApprovalRules
+	defer func() { ApprovalRules = old }()
+	ApprovalRules = []ClusterSelector{{Namespaces: []string{"production"}}}
+
+	capiCluster := NewCapiCluster("test", "production")
+	capiSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "test-kubeconfig", Namespace: "production", ResourceVersion: "1"}}
+	key := types.NamespacedName{Name: capiSecret.Name, Namespace: capiSecret.Namespace}
+	c := &mockClient{
+		secrets:    map[types.NamespacedName]*corev1.Secret{key: capiSecret.DeepCopy()},
+		namespaces: mockApprovalNamespace("production", nil),
+	}
+	recorder := record.NewFakeRecorder(10)
+	r := &Capi2Argo{Client: c, Log: logr.Discard(), Recorder: recorder}
+
+	// Pending is only reported once.
+	for i := 0; i < 2; i++ {
+		_, err := r.ApproveCluster(context.Background(), logr.Discard(), capiCluster, key, capiSecret)
+		assert.Equal(t, ErrApprovalPending, err)
+	}
+	assert.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "ApprovalPending")
+
+	// Approval is recorded with its approver.
+	c.namespaces = mockApprovalNamespace("production", map[string]string{ApprovalAnnotation: "test", ApprovedByAnnotation: "alice"})
+	_, err := r.ApproveCluster(context.Background(), logr.Discard(), capiCluster, key, capiSecret)
+	assert.Nil(t, err)
+	assert.Contains(t, <-recorder.Events, "Registration approved by alice")
+	assert.Equal(t, "alice", c.secrets[key].Annotations[ApprovedByAnnotation])
+
+	// Without the namespace annotation, the approval is only kept once an ArgoSecret records it.
+	c.namespaces = mockApprovalNamespace("production", nil)
+	_, err = r.ApproveCluster(context.Background(), logr.Discard(), capiCluster, key, capiSecret)
+	assert.Equal(t, ErrApprovalPending, err)
+	<-recorder.Events
+
+	argoSecret := mockApprovedArgoSecret(map[string]string{ApprovedClusterLabel: "test"}, map[string]string{ApprovedByAnnotation: "alice"})
+	c.secrets[client.ObjectKeyFromObject(argoSecret)] = argoSecret
+	approval, err := r.ApproveCluster(context.Background(), logr.Discard(), capiCluster, key, capiSecret)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", approval.Approver)
+	assert.Empty(t, recorder.Events)
+
+	// The approval follows the cluster when it is sourced from another kubeconfig secret.
+	userKey := types.NamespacedName{Name: "test-user-kubeconfig", Namespace: "production"}
+	approval, err = r.ApproveCluster(context.Background(), logr.Discard(), capiCluster, userKey, capiSecret)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", approval.Approver)
+
+	// Approvals of other clusters in the namespace do not count.
+	_, err = r.ApproveCluster(context.Background(), logr.Discard(), NewCapiCluster("other", "production"), key, capiSecret)
+	assert.Equal(t, ErrApprovalPending, err)
+}
+
+func TestApproveClusterPerCluster(t *testing.T) {
+	old := ApprovalRules
+	defer func() { ApprovalRules = old }()
+	ApprovalRules = []ClusterSelector{{Namespaces: []string{"production"}}}
+
+	secretA := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "a-kubeconfig", Namespace: "production", ResourceVersion: "1"}}
+	secretB := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "b-kubeconfig", Namespace: "production", ResourceVersion: "1"}}
+	keyA, keyB := client.ObjectKeyFromObject(secretA), client.ObjectKeyFromObject(secretB)
+	c := &mockClient{
+		secrets:    map[types.NamespacedName]*corev1.Secret{keyA: secretA.DeepCopy(), keyB: secretB.DeepCopy()},
+		namespaces: mockApprovalNamespace("production", map[string]string{ApprovalAnnotation: "a"}),
+	}
+	r := &Capi2Argo{Client: c, Log: logr.Discard(), Recorder: record.NewFakeRecorder(10)}
+
+	// Approving cluster a does not admit cluster b in the same namespace.
+	approval, err := r.ApproveCluster(context.Background(), logr.Discard(), NewCapiCluster("a", "production"), keyA, secretA)
+	assert.Nil(t, err)
+	assert.NotNil(t, approval)
+	_, err = r.ApproveCluster(context.Background(), logr.Discard(), NewCapiCluster("b", "production"), keyB, secretB)
+	assert.Equal(t, ErrApprovalPending, err)
+	assert.Equal(t, ApprovalStatePending, c.secrets[keyB].Annotations[ApprovalStateAnnotation])
+}
+
+func TestRegisteredApproval(t *testing.T) {
+	expired := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	cluster := types.NamespacedName{Name: "test", Namespace: "production"}
+	source := types.NamespacedName{Name: "test-kubeconfig", Namespace: "production"}
+	tests := []struct {
+		testName        string
+		argoSecret      *corev1.Secret
+		expectedExpired bool
+		expectedFound   bool
+	}{
+		{"test labeled with cluster", mockApprovedArgoSecret(map[string]string{ApprovedClusterLabel: "test"}, nil), false, true},
+		{"test labeled with other cluster", mockApprovedArgoSecret(map[string]string{ApprovedClusterLabel: "other"}, nil), false, false},
+		{"test approved before labeled", mockApprovedArgoSecret(nil, nil), false, true},
+		{"test expired", mockApprovedArgoSecret(map[string]string{ApprovedClusterLabel: "test"}, map[string]string{ApprovalExpiresAnnotation: expired}), true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			c := &mockClient{secrets: map[types.NamespacedName]*corev1.Secret{client.ObjectKeyFromObject(tt.argoSecret): tt.argoSecret}}
+			r := &Capi2Argo{Client: c, Log: logr.Discard()}
+			approval, err := r.RegisteredApproval(context.Background(), cluster, source)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expectedFound, approval != nil)
+			if approval != nil {
+				assert.Equal(t, tt.expectedExpired, approval.Expired(time.Now()))
+			}
+		})
+	}
+}
+
+func TestApproveClusterExpiredRegistration(t *testing.T) {
+	old := ApprovalRules
+	defer func() { ApprovalRules = old }()
+	ApprovalRules = []ClusterSelector{{Namespaces: []string{"production"}}}
+
+	expired := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	argoSecret := mockApprovedArgoSecret(map[string]string{ApprovedClusterLabel: "test"}, map[string]string{ApprovalExpiresAnnotation: expired})
+	capiSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "test-kubeconfig", Namespace: "production", ResourceVersion: "1"}}
+	key := client.ObjectKeyFromObject(capiSecret)
+	c := &mockClient{
+		secrets: map[types.NamespacedName]*corev1.Secret{
+			key:                                    capiSecret.DeepCopy(),
+			client.ObjectKeyFromObject(argoSecret): argoSecret,
+		},
+		namespaces: mockApprovalNamespace("production", map[string]string{ApprovalAnnotation: "test", ApprovalExpiresAnnotation: expired}),
+	}
+	r := &Capi2Argo{Client: c, Log: logr.Discard(), Recorder: record.NewFakeRecorder(10)}
+
+	// An expired registered approval is checked against the namespace again.
+	_, err := r.ApproveCluster(context.Background(), logr.Discard(), NewCapiCluster("test", "production"), key, capiSecret)
+	assert.Equal(t, ErrApprovalPending, err)
+	assert.Equal(t, ApprovalStateExpired, c.secrets[key].Annotations[ApprovalStateAnnotation])
+
+	// Renewing the approval on the namespace registers the cluster again.
+	renewed := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	c.namespaces = mockApprovalNamespace("production", map[string]string{ApprovalAnnotation: "test", ApprovalExpiresAnnotation: renewed.Format(time.RFC3339)})
+	approval, err := r.ApproveCluster(context.Background(), logr.Discard(), NewCapiCluster("test", "production"), key, capiSecret)
+	assert.Nil(t, err)
+	assert.True(t, renewed.Equal(*approval.ExpiresAt))
+}
+
+func TestApproveShoot(t *testing.T) {
+	old := ApprovalRules
+	defer func() { ApprovalRules = old }()
+	ApprovalRules = []ClusterSelector{{Namespaces: []string{"garden-production"}}}
+
+	shoot := &unstructured.Unstructured{}
+	shoot.SetGroupVersionKind(GardenerShootGVK)
+	shoot.SetName("test")
+	shoot.SetNamespace("garden-production")
+	capiCluster := NewCapiCluster("test", "garden-production")
+	source := types.NamespacedName{Name: ShootSourceName("test"), Namespace: "garden-production"}
+	c := &mockClient{secrets: map[types.NamespacedName]*corev1.Secret{}, namespaces: mockApprovalNamespace("garden-production", nil)}
+	recorder := record.NewFakeRecorder(10)
+	r := &Capi2Argo{Client: c, Log: logr.Discard(), Recorder: recorder}
+
+	// The pending state is recorded on the Shoot, so it is only reported once.
+	for i := 0; i < 2; i++ {
+		_, err := r.ApproveCluster(context.Background(), logr.Discard(), capiCluster, source, shoot)
+		assert.Equal(t, ErrApprovalPending, err)
+	}
+	assert.Len(t, recorder.Events, 1)
+	assert.Len(t, c.patched, 1)
+	assert.Equal(t, ApprovalStatePending, c.patched[0].GetAnnotations()[ApprovalStateAnnotation])
+
+	// Project members approving their own Shoot are ignored.
+	capiCluster.Annotations = map[string]string{ApprovalAnnotation: "test"}
+	_, err := r.ApproveCluster(context.Background(), logr.Discard(), capiCluster, source, shoot)
+	assert.Equal(t, ErrApprovalPending, err)
+
+	c.namespaces = mockApprovalNamespace("garden-production", map[string]string{ApprovalAnnotation: "test"})
+	approval, err := r.ApproveCluster(context.Background(), logr.Discard(), capiCluster, source, shoot)
+	assert.Nil(t, err)
+	assert.NotNil(t, approval)
+	assert.Equal(t, ApprovalStateApproved, shoot.GetAnnotations()[ApprovalStateAnnotation])
+}
+
+func TestValidateApprovalRules(t *testing.T) {
+	t.Parallel()
+	assert.Nil(t, ValidateApprovalRules(nil))
+	assert.Nil(t, ValidateApprovalRules([]ClusterSelector{{Namespaces: []string{"production"}}}))
+	assert.NotNil(t, ValidateApprovalRules([]ClusterSelector{{LabelSelector: &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "tier", Operator: "Unknown"}},
+	}}}))
+}
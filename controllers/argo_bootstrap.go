@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"context"
+	goErr "errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// BootstrappedAnnotation on an ArgoSecret records that its bootstrap templates were
+	// created, so they are only created on first registration.
+	BootstrappedAnnotation = "capi-to-argocd/bootstrapped"
+	// BootstrapNamespacesAnnotation on an ArgoSecret lists the namespaces its bootstrap
+	// objects were created into, besides its own, so they are collected along with it.
+	BootstrapNamespacesAnnotation = "capi-to-argocd/bootstrap-namespaces"
+	// BootstrapOwnedLabel marks Applications and ApplicationSets created from bootstrap templates.
+	BootstrapOwnedLabel = "capi-to-argocd/bootstrap-owned"
+
+	// BootstrapGCDelete deletes bootstrap objects along with their ArgoSecret.
+	BootstrapGCDelete = "delete"
+	// BootstrapGCOrphan leaves bootstrap objects in place, unmanaged, along with their ArgoSecret.
+	BootstrapGCOrphan = "orphan"
+)
+
+var (
+	// ErrBootstrapConflict is returned when a bootstrap object exists for another cluster.
+	ErrBootstrapConflict = goErr.New("bootstrap object belongs to another cluster")
+
+	// ArgoBootstrap holds the templates rendered for newly registered clusters, when set.
+	ArgoBootstrap *BootstrapConfig
+)
+
+// BootstrapConfig describes the Applications and ApplicationSets created per cluster.
+type BootstrapConfig struct {
+	// Templates are Application or ApplicationSet manifests. String values may hold
+	// {{cluster}}, {{server}}, {{namespace}} and {{labels.<key>}} placeholders, except
+	// the spec.template and spec.templatePatch of ApplicationSets, which are left to
+	// the ApplicationSet controller. Names must hold {{cluster}}, to be unique per cluster.
+	Templates []map[string]interface{} `json:"templates"`
+	// GCPolicy is delete or orphan, defaulting to delete.
+	GCPolicy string `json:"gcPolicy,omitempty"`
+}
+
+// GetGCPolicy returns the GC policy of bootstrap objects.
+func (b *BootstrapConfig) GetGCPolicy() string {
+	if b == nil || b.GCPolicy == "" {
+		return BootstrapGCDelete
+	}
+	return b.GCPolicy
+}
+
+// bootstrapKinds are the kinds bootstrap templates may render.
+var bootstrapKinds = []schema.GroupVersionKind{
+	{Group: "argoproj.io", Version: "v1alpha1", Kind: "Application"},
+	{Group: "argoproj.io", Version: "v1alpha1", Kind: "ApplicationSet"},
+}
+
+// ValidateBootstrap validates the bootstrap templates and GC policy.
+func ValidateBootstrap(b *BootstrapConfig) error {
+	if b == nil {
+		return nil
+	}
+	switch b.GCPolicy {
+	case "", BootstrapGCDelete, BootstrapGCOrphan:
+	default:
+		return fmt.Errorf("unknown gcPolicy %q", b.GCPolicy)
+	}
+	for i, template := range b.Templates {
+		u := &unstructured.Unstructured{Object: template}
+		if !containsGVK(bootstrapKinds, u.GroupVersionKind()) {
+			return fmt.Errorf("template %d: must be an argoproj.io/v1alpha1 Application or ApplicationSet", i)
+		}
+		if u.GetName() == "" {
+			return fmt.Errorf("template %d: missing metadata.name", i)
+		}
+		if !strings.Contains(u.GetName(), "{{cluster}}") {
+			return fmt.Errorf("template %d: metadata.name must hold the {{cluster}} placeholder", i)
+		}
+	}
+	return nil
+}
+
+// containsGVK reports whether a list holds given kind.
+func containsGVK(list []schema.GroupVersionKind, gvk schema.GroupVersionKind) bool {
+	for _, v := range list {
+		if v == gvk {
+			return true
+		}
+	}
+	return false
+}
+
+// RenderBootstrap renders a bootstrap template for an ArgoCluster, into the Argo
+// namespace of the ArgoCluster unless the template sets one.
+func RenderBootstrap(template map[string]interface{}, a *ArgoCluster) *unstructured.Unstructured {
+	pairs := []string{
+		"{{cluster}}", a.ClusterName,
+		"{{server}}", a.ClusterServer,
+		"{{namespace}}", a.ClusterLabels["capi-to-argocd/cluster-namespace"],
+	}
+	for key, value := range a.ClusterLabels {
+		pairs = append(pairs, "{{labels."+key+"}}", value)
+	}
+	obj := &unstructured.Unstructured{Object: ExpandPlaceholders(template, strings.NewReplacer(pairs...)).(map[string]interface{})}
+	if obj.GetKind() == "ApplicationSet" {
+		for _, field := range []string{"template", "templatePatch"} {
+			if value, ok, _ := unstructured.NestedFieldCopy(template, "spec", field); ok {
+				_ = unstructured.SetNestedField(obj.Object, value, "spec", field)
+			}
+		}
+	}
+
+	if obj.GetNamespace() == "" {
+		obj.SetNamespace(a.NamespacedName.Namespace)
+	}
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[BootstrapOwnedLabel] = "true"
+	source := ArgoClusterSource(a)
+	labels["capi-to-argocd/cluster-secret-name"] = source.Name
+	labels["capi-to-argocd/cluster-namespace"] = source.Namespace
+	obj.SetLabels(labels)
+	return obj
+}
+
+// BootstrapArgoCluster creates the bootstrap objects of a newly registered ArgoCluster
+// and marks its ArgoSecret, as just reconciled, as bootstrapped. Existing objects are
+// left untouched.
+func (r *Capi2Argo) BootstrapArgoCluster(ctx context.Context, c client.Client, log logr.Logger, a *ArgoCluster, argoSecret *corev1.Secret) error {
+	if argoSecret.Annotations[BootstrappedAnnotation] == "true" {
+		return nil
+	}
+
+	var namespaces []string
+	for _, template := range ArgoBootstrap.Templates {
+		obj := RenderBootstrap(template, a)
+		if obj.GetNamespace() != argoSecret.Namespace && !containsString(namespaces, obj.GetNamespace()) {
+			namespaces = append(namespaces, obj.GetNamespace())
+		}
+		err := c.Create(ctx, obj)
+		if errors.IsAlreadyExists(err) {
+			if err := checkBootstrapOwner(ctx, c, obj); err != nil {
+				log.Error(err, "Failed to bootstrap", "kind", obj.GetKind(), "bootstrap", obj.GetName())
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			log.Error(err, "Failed to create bootstrap", "kind", obj.GetKind(), "bootstrap", obj.GetName())
+			return err
+		}
+		log.Info("Created new bootstrap", "kind", obj.GetKind(), "bootstrap", obj.GetName())
+	}
+
+	if argoSecret.Annotations == nil {
+		argoSecret.Annotations = map[string]string{}
+	}
+	argoSecret.Annotations[BootstrappedAnnotation] = "true"
+	if len(namespaces) > 0 {
+		argoSecret.Annotations[BootstrapNamespacesAnnotation] = strings.Join(namespaces, ",")
+	}
+	if err := c.Update(ctx, argoSecret); err != nil {
+		log.Error(err, "Failed to mark ArgoSecret as bootstrapped")
+		return err
+	}
+	return nil
+}
+
+// checkBootstrapOwner checks that an existing bootstrap object was generated from the
+// same kubeconfig secret as the desired one.
+func checkBootstrapOwner(ctx context.Context, c client.Client, desired *unstructured.Unstructured) error {
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(desired.GroupVersionKind())
+	if err := c.Get(ctx, client.ObjectKeyFromObject(desired), existing); err != nil {
+		return err
+	}
+	for _, key := range []string{"capi-to-argocd/cluster-secret-name", "capi-to-argocd/cluster-namespace"} {
+		if existing.GetLabels()[key] != desired.GetLabels()[key] {
+			return ErrBootstrapConflict
+		}
+	}
+	return nil
+}
+
+// BootstrapNamespaces returns the namespaces the bootstrap objects of an ArgoSecret may live in.
+func BootstrapNamespaces(argoSecret *corev1.Secret) []string {
+	namespaces := []string{argoSecret.Namespace}
+	for _, namespace := range strings.Split(argoSecret.Annotations[BootstrapNamespacesAnnotation], ",") {
+		if namespace != "" && !containsString(namespaces, namespace) {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+// GarbageCollectBootstraps deletes or orphans, according to GC policy, the bootstrap objects
+// generated from given CapiSecret in the given namespaces.
+func GarbageCollectBootstraps(ctx context.Context, c client.Client, log logr.Logger, namespaces []string, capiSecret client.ObjectKey) error {
+	labels := client.MatchingLabels{
+		BootstrapOwnedLabel:                  "true",
+		"capi-to-argocd/cluster-secret-name": capiSecret.Name,
+		"capi-to-argocd/cluster-namespace":   capiSecret.Namespace,
+	}
+	policy := ArgoBootstrap.GetGCPolicy()
+
+	var errs []error
+	for _, gvk := range bootstrapKinds {
+		var items []unstructured.Unstructured
+		for _, namespace := range namespaces {
+			list := &unstructured.UnstructuredList{}
+			list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+			err := c.List(ctx, list, client.InNamespace(namespace), labels)
+			if meta.IsNoMatchError(err) {
+				break
+			}
+			if err != nil {
+				log.Error(err, "Failed to list bootstraps", "kind", gvk.Kind, "namespace", namespace)
+				errs = append(errs, err)
+				continue
+			}
+			items = append(items, list.Items...)
+		}
+
+		for i := range items {
+			obj := &items[i]
+			if policy == BootstrapGCOrphan {
+				labels := obj.GetLabels()
+				delete(labels, BootstrapOwnedLabel)
+				obj.SetLabels(labels)
+				if err := c.Update(ctx, obj); client.IgnoreNotFound(err) != nil {
+					log.Error(err, "Failed to orphan bootstrap", "kind", gvk.Kind, "bootstrap", obj.GetName())
+					errs = append(errs, err)
+					continue
+				}
+				log.Info("Orphaned bootstrap", "kind", gvk.Kind, "bootstrap", obj.GetName())
+				continue
+			}
+			if err := c.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+				log.Error(err, "Failed to delete bootstrap", "kind", gvk.Kind, "bootstrap", obj.GetName())
+				errs = append(errs, err)
+				continue
+			}
+			log.Info("Deleted successfully of bootstrap", "kind", gvk.Kind, "bootstrap", obj.GetName())
+		}
+	}
+	return goErr.Join(errs...)
+}
@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// MockBootstrapTemplate returns an Application template deploying add-ons to a cluster.
+func MockBootstrapTemplate() map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Application",
+		"metadata": map[string]interface{}{
+			"name": "addons-{{cluster}}",
+		},
+		"spec": map[string]interface{}{
+			"destination": map[string]interface{}{
+				"server":    "{{server}}",
+				"namespace": "kube-system",
+			},
+			"source": map[string]interface{}{
+				"path": "addons/{{labels.capi-to-argocd/cluster-namespace}}",
+			},
+		},
+	}
+}
+
+func TestValidateBootstrap(t *testing.T) {
+	t.Parallel()
+	invalidKind := MockBootstrapTemplate()
+	invalidKind["kind"] = "Deployment"
+	unnamed := MockBootstrapTemplate()
+	unnamed["metadata"] = map[string]interface{}{}
+	shared := MockBootstrapTemplate()
+	shared["metadata"] = map[string]interface{}{"name": "addons"}
+
+	tests := []struct {
+		testName  string
+		bootstrap *BootstrapConfig
+		expectErr bool
+	}{
+		{"test unset", nil, false},
+		{"test valid", &BootstrapConfig{Templates: []map[string]interface{}{MockBootstrapTemplate()}, GCPolicy: BootstrapGCOrphan}, false},
+		{"test unknown policy", &BootstrapConfig{GCPolicy: "keep"}, true},
+		{"test invalid kind", &BootstrapConfig{Templates: []map[string]interface{}{invalidKind}}, true},
+		{"test missing name", &BootstrapConfig{Templates: []map[string]interface{}{unnamed}}, true},
+		{"test name shared by clusters", &BootstrapConfig{Templates: []map[string]interface{}{shared}}, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateBootstrap(tt.bootstrap)
+			if tt.expectErr {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestRenderBootstrap(t *testing.T) {
+	t.Parallel()
+	a := MockArgoCluster(true)
+	a.NamespacedName.Namespace = "argocd"
+	template := MockBootstrapTemplate()
+
+	obj := RenderBootstrap(template, a)
+	assert.Equal(t, "addons-test", obj.GetName())
+	assert.Equal(t, "argocd", obj.GetNamespace())
+	assert.Equal(t, map[string]string{
+		BootstrapOwnedLabel:                  "true",
+		"capi-to-argocd/cluster-secret-name": "test-kubeconfig",
+		"capi-to-argocd/cluster-namespace":   "test",
+	}, obj.GetLabels())
+
+	server, _, _ := unstructured.NestedString(obj.Object, "spec", "destination", "server")
+	assert.Equal(t, "server", server)
+	path, _, _ := unstructured.NestedString(obj.Object, "spec", "source", "path")
+	assert.Equal(t, "addons/test", path)
+
+	// The template itself is left untouched.
+	assert.Equal(t, "addons-{{cluster}}", template["metadata"].(map[string]interface{})["name"])
+}
+
+func TestRenderBootstrapApplicationSet(t *testing.T) {
+	t.Parallel()
+	a := MockArgoCluster(true)
+	template := map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "ApplicationSet",
+		"metadata":   map[string]interface{}{"name": "addons-{{cluster}}"},
+		"spec": map[string]interface{}{
+			"generators": []interface{}{map[string]interface{}{"list": map[string]interface{}{
+				"elements": []interface{}{map[string]interface{}{"cluster": "{{cluster}}"}},
+			}}},
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{"destination": map[string]interface{}{"server": "{{server}}"}},
+			},
+		},
+	}
+
+	obj := RenderBootstrap(template, a)
+	assert.Equal(t, "addons-"+a.ClusterName, obj.GetName())
+	generators, _, _ := unstructured.NestedSlice(obj.Object, "spec", "generators")
+	assert.Equal(t, a.ClusterName, generators[0].(map[string]interface{})["list"].(map[string]interface{})["elements"].([]interface{})[0].(map[string]interface{})["cluster"])
+	// Placeholders of the ApplicationSet template are left to its generators.
+	server, _, _ := unstructured.NestedString(obj.Object, "spec", "template", "spec", "destination", "server")
+	assert.Equal(t, "{{server}}", server)
+}
+
+func TestGarbageCollectBootstraps(t *testing.T) {
+	old := ArgoBootstrap
+	defer func() { ArgoBootstrap = old }()
+	ArgoBootstrap = &BootstrapConfig{}
+
+	a := MockArgoCluster(true)
+	a.NamespacedName.Namespace = "argocd"
+	var objects []*unstructured.Unstructured
+	for _, namespace := range []string{"argocd", "team-a", "team-b"} {
+		template := MockBootstrapTemplate()
+		template["metadata"].(map[string]interface{})["namespace"] = namespace
+		objects = append(objects, RenderBootstrap(template, a))
+	}
+	c := &mockClient{objects: objects}
+	argoSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name: a.NamespacedName.Name, Namespace: "argocd", Annotations: map[string]string{BootstrapNamespacesAnnotation: "team-a"},
+	}}
+	assert.Equal(t, []string{"argocd", "team-a"}, BootstrapNamespaces(argoSecret))
+
+	assert.Nil(t, GarbageCollectBootstraps(context.Background(), c, logr.Discard(), BootstrapNamespaces(argoSecret), ArgoClusterSource(a)))
+	assert.ElementsMatch(t, []client.ObjectKey{
+		{Name: objects[0].GetName(), Namespace: "argocd"},
+		{Name: objects[1].GetName(), Namespace: "team-a"},
+	}, c.deleted)
+}
+
+func TestBootstrapGCPolicy(t *testing.T) {
+	t.Parallel()
+	var unset *BootstrapConfig
+	assert.Equal(t, BootstrapGCDelete, unset.GetGCPolicy())
+	assert.Equal(t, BootstrapGCDelete, (&BootstrapConfig{}).GetGCPolicy())
+	assert.Equal(t, BootstrapGCOrphan, (&BootstrapConfig{GCPolicy: BootstrapGCOrphan}).GetGCPolicy())
+}
+
+func TestBootstrapArgoCluster(t *testing.T) {
+	old := ArgoBootstrap
+	defer func() { ArgoBootstrap = old }()
+	ArgoBootstrap = &BootstrapConfig{Templates: []map[string]interface{}{MockBootstrapTemplate()}}
+
+	a := MockArgoCluster(true)
+	// The ArgoSecret was just created, so it may be missing from the cache still.
+	argoSecret, err := a.ConvertToSecret()
+	assert.Nil(t, err)
+	c := &mockClient{}
+	r := &Capi2Argo{Log: logr.Discard()}
+
+	assert.Nil(t, r.BootstrapArgoCluster(context.Background(), c, logr.Discard(), a, argoSecret))
+	assert.Len(t, c.objects, 1)
+	key := client.ObjectKeyFromObject(argoSecret)
+	bootstrapped := c.secrets[key]
+	assert.Equal(t, "true", bootstrapped.Annotations[BootstrappedAnnotation])
+
+	// Bootstrapped ArgoSecrets are left alone.
+	delete(c.secrets, key)
+	assert.Nil(t, r.BootstrapArgoCluster(context.Background(), c, logr.Discard(), a, bootstrapped))
+	assert.Empty(t, c.secrets)
+}
+
+func TestBootstrapArgoClusterConflict(t *testing.T) {
+	old := ArgoBootstrap
+	defer func() { ArgoBootstrap = old }()
+	ArgoBootstrap = &BootstrapConfig{Templates: []map[string]interface{}{MockBootstrapTemplate()}}
+
+	a := MockArgoCluster(true)
+	argoSecret, err := a.ConvertToSecret()
+	assert.Nil(t, err)
+	r := &Capi2Argo{Log: logr.Discard()}
+
+	tests := []struct {
+		testName    string
+		ownerSecret string
+		expectedErr error
+	}{
+		{"test existing bootstrap of same cluster", a.ClusterLabels["capi-to-argocd/cluster-secret-name"], nil},
+		{"test existing bootstrap of other cluster", "other-kubeconfig", ErrBootstrapConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			existing := RenderBootstrap(MockBootstrapTemplate(), a)
+			labels := existing.GetLabels()
+			labels["capi-to-argocd/cluster-secret-name"] = tt.ownerSecret
+			existing.SetLabels(labels)
+			c := &mockClient{objects: []*unstructured.Unstructured{existing}}
+
+			err := r.BootstrapArgoCluster(context.Background(), c, logr.Discard(), a, argoSecret.DeepCopy())
+			assert.ErrorIs(t, err, tt.expectedErr)
+			assert.Equal(t, tt.expectedErr == nil, len(c.secrets) == 1)
+		})
+	}
+}
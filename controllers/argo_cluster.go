@@ -3,9 +3,11 @@
 package controllers
 
 import (
+	"bytes"
 	b64 "encoding/base64"
 	"encoding/json"
 	"errors"
+	"strconv"
 	"strings"
 
 	corev1 "k8s.io/api/core/v1"
@@ -33,28 +35,54 @@ type ArgoCluster struct {
 	ClusterServer  string
 	ClusterLabels  map[string]string
 	ClusterConfig  ArgoConfig
+	ClusterProject string
+	ClusterScope   ClusterScope
+	// ClusterShard is the Argo controller shard of the cluster, nil when unsharded.
+	ClusterShard       *int
+	ClusterShardWeight int
+	// ClusterExtraCaData holds base64 encoded CAs trusted on top of the cluster CA.
+	ClusterExtraCaData string
+	// ClusterAnnotations are set on the ArgoSecret, eg. mapped from the cluster topology.
+	ClusterAnnotations map[string]string
+	// KeepClusterFacts keeps the facts of an existing ArgoSecret, when none were discovered.
+	KeepClusterFacts bool
 }
 
 // ArgoConfig represents Argo Cluster.JSON.config
 type ArgoConfig struct {
-	TLSClientConfig ArgoTLS `json:"tlsClientConfig"`
+	TLSClientConfig    ArgoTLS           `json:"tlsClientConfig"`
+	ProxyURL           string            `json:"proxyUrl,omitempty"`
+	BearerToken        string            `json:"bearerToken,omitempty"`
+	ExecProviderConfig *ArgoExecProvider `json:"execProviderConfig,omitempty"`
+}
+
+// ArgoExecProvider represents Argo Cluster.JSON.config.execProviderConfig. The command
+// must be available to the Argo application controller.
+type ArgoExecProvider struct {
+	Command     string            `json:"command"`
+	Args        []string          `json:"args,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	APIVersion  string            `json:"apiVersion,omitempty"`
+	InstallHint string            `json:"installHint,omitempty"`
 }
 
 // ArgoTLS represents Argo Cluster.JSON.config.tlsClientConfig
 type ArgoTLS struct {
-	CaData   string `json:"caData"`
-	CertData string `json:"certData"`
-	KeyData  string `json:"keyData"`
+	CaData     string `json:"caData,omitempty"`
+	CertData   string `json:"certData,omitempty"`
+	KeyData    string `json:"keyData,omitempty"`
+	ServerName string `json:"serverName,omitempty"`
+	Insecure   bool   `json:"insecure,omitempty"`
 }
 
-// NewArgoCluster return a new ArgoCluster
-func NewArgoCluster(c *CapiCluster, s *corev1.Secret) *ArgoCluster {
-	return &ArgoCluster{
-		NamespacedName: BuildNamespacedName(s.ObjectMeta.Name, s.ObjectMeta.Namespace),
-		ClusterName:    BuildClusterName(c.KubeConfig.Clusters[0].Name, s.ObjectMeta.Namespace),
+// NewArgoCluster return a new ArgoCluster sourced from the given kubeconfig secret
+func NewArgoCluster(c *CapiCluster, s types.NamespacedName) *ArgoCluster {
+	a := &ArgoCluster{
+		NamespacedName: BuildNamespacedName(c.Name, s.Namespace),
+		ClusterName:    BuildClusterName(c.KubeConfig.Clusters[0].Name, s.Namespace),
 		ClusterServer:  c.KubeConfig.Clusters[0].Cluster.Server,
 		ClusterLabels: map[string]string{
-			"capi-to-argocd/cluster-secret-name": c.Name + "-kubeconfig",
+			"capi-to-argocd/cluster-secret-name": s.Name,
 			"capi-to-argocd/cluster-namespace":   c.Namespace,
 		},
 		ClusterConfig: ArgoConfig{
@@ -63,8 +91,48 @@ func NewArgoCluster(c *CapiCluster, s *corev1.Secret) *ArgoCluster {
 				CertData: c.KubeConfig.Users[0].User.CertData,
 				KeyData:  c.KubeConfig.Users[0].User.KeyData,
 			},
+			BearerToken:        c.KubeConfig.Users[0].User.Token,
+			ExecProviderConfig: NewArgoExecProvider(c.KubeConfig.Users[0].User.Exec),
 		},
+		ClusterProject: ResolveProject(c.Namespace, c.Labels, c.Annotations),
+		ClusterScope:   ResolveScope(c.Namespace, c.Labels, c.Annotations),
+	}
+
+	// Point Argo to a reachable endpoint, trusting its CA as well. Invalid CA data is
+	// left as is and caught when converting to an ArgoSecret.
+	server, bundle := RewriteServer(c.Namespace, c.Name, c.Labels, a.ClusterServer)
+	a.ClusterServer = server
+	if caData, err := MergeCaData(a.ClusterConfig.TLSClientConfig.CaData, bundle); err == nil {
+		a.ClusterConfig.TLSClientConfig.CaData = caData
 	}
+	ResolveConnection(&a.ClusterConfig, c.KubeConfig.Clusters[0].Cluster, c.Annotations)
+	if ArgoShards > 0 {
+		a.ClusterShard = ResolveShard(c.Annotations)
+		a.ClusterShardWeight = ShardWeight(c.Labels)
+		a.ClusterLabels[shardWeightLabel] = strconv.Itoa(a.ClusterShardWeight)
+		a.ClusterLabels[shardCountLabel] = strconv.Itoa(ArgoShards)
+	}
+	return a
+}
+
+// NewArgoExecProvider converts a kubeconfig exec config into an Argo one.
+func NewArgoExecProvider(e *ExecConfig) *ArgoExecProvider {
+	if e == nil {
+		return nil
+	}
+	p := &ArgoExecProvider{
+		Command:     e.Command,
+		Args:        e.Args,
+		APIVersion:  e.APIVersion,
+		InstallHint: e.InstallHint,
+	}
+	if len(e.Env) > 0 {
+		p.Env = make(map[string]string, len(e.Env))
+		for _, env := range e.Env {
+			p.Env[env.Name] = env.Value
+		}
+	}
+	return p
 }
 
 // BuildNamespacedName returns k8s native object identifier.
@@ -86,10 +154,11 @@ func BuildClusterName(s string, namespace string) string {
 
 // ConvertToSecret converts an ArgoCluster into k8s native secret object.
 func (a *ArgoCluster) ConvertToSecret() (*corev1.Secret, error) {
-	if err := ValidateClusterTLSConfig(&a.ClusterConfig.TLSClientConfig); err != nil {
+	config, err := a.ResolvedConfig()
+	if err != nil {
 		return nil, err
 	}
-	c, err := json.Marshal(a.ClusterConfig)
+	c, err := json.Marshal(config)
 	if err != nil {
 		return nil, err
 	}
@@ -118,18 +187,153 @@ func (a *ArgoCluster) ConvertToSecret() (*corev1.Secret, error) {
 			"config": c,
 		},
 	}
+	if len(a.ClusterAnnotations) > 0 {
+		argoSecret.Annotations = make(map[string]string, len(a.ClusterAnnotations))
+		for key, value := range a.ClusterAnnotations {
+			argoSecret.Annotations[key] = value
+		}
+	}
+	if a.ClusterProject != "" {
+		argoSecret.Data["project"] = []byte(a.ClusterProject)
+	}
+	if a.ClusterShard != nil {
+		argoSecret.Data["shard"] = []byte(strconv.Itoa(*a.ClusterShard))
+	}
+	if len(a.ClusterScope.Namespaces) > 0 {
+		argoSecret.Data["namespaces"] = []byte(strings.Join(a.ClusterScope.Namespaces, ","))
+		if a.ClusterScope.ClusterResources != nil {
+			argoSecret.Data["clusterResources"] = []byte(strconv.FormatBool(*a.ClusterScope.ClusterResources))
+		}
+	}
 	return argoSecret, nil
 }
 
+// ResolvedConfig returns the validated cluster config, trusting extra CAs unless insecure.
+func (a *ArgoCluster) ResolvedConfig() (ArgoConfig, error) {
+	config := a.ClusterConfig
+	if !config.TLSClientConfig.Insecure {
+		caData, err := MergeCaData(config.TLSClientConfig.CaData, a.ClusterExtraCaData)
+		if err != nil {
+			return ArgoConfig{}, err
+		}
+		config.TLSClientConfig.CaData = caData
+	}
+	if err := ValidateClusterConfig(&config); err != nil {
+		return ArgoConfig{}, err
+	}
+	return config, nil
+}
+
+// argoOptionalKeys holds ArgoSecret data keys that are only set when configured
+// and must be dropped once they are not desired anymore.
+var argoOptionalKeys = []string{"project", "namespaces", "clusterResources", "shard"}
+
+// SyncArgoSecretData copies desired ArgoSecret data into the existing one.
+// It reports whether the existing ArgoSecret changed.
+func SyncArgoSecretData(existing, desired *corev1.Secret) bool {
+	if existing.Data == nil {
+		existing.Data = map[string][]byte{}
+	}
+	changed := false
+	for key, value := range desired.Data {
+		if !bytes.Equal(existing.Data[key], value) {
+			existing.Data[key] = value
+			changed = true
+		}
+	}
+	for _, key := range argoOptionalKeys {
+		if _, ok := desired.Data[key]; ok {
+			continue
+		}
+		if _, ok := existing.Data[key]; ok {
+			delete(existing.Data, key)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// SyncArgoSecretLabels copies desired ArgoSecret labels into the existing one and drops
+// controller labels that are no longer desired. It reports whether the existing ArgoSecret changed.
+func SyncArgoSecretLabels(existing, desired *corev1.Secret) bool {
+	if existing.Labels == nil {
+		existing.Labels = map[string]string{}
+	}
+	changed := false
+	for key, value := range desired.Labels {
+		if existing.Labels[key] != value {
+			existing.Labels[key] = value
+			changed = true
+		}
+	}
+	for key := range existing.Labels {
+		if _, ok := desired.Labels[key]; ok || !IsManagedArgoLabel(key) {
+			continue
+		}
+		delete(existing.Labels, key)
+		changed = true
+	}
+	return changed
+}
+
+// IsManagedArgoLabel reports whether an ArgoSecret label is set by the operator, being
+// removed once no longer desired, rather than by users.
+func IsManagedArgoLabel(key string) bool {
+	return strings.HasPrefix(key, "capi-to-argocd/") || containsString(ManagedArgoLabels(), key) || NamespaceLabels.Matches(key)
+}
+
+// SyncArgoSecretAnnotations merges the desired annotations into an existing ArgoSecret,
+// removing managed ones that are no longer desired. It reports whether anything changed.
+func SyncArgoSecretAnnotations(existing, desired *corev1.Secret) bool {
+	changed := false
+	for key, value := range desired.Annotations {
+		if existing.Annotations == nil {
+			existing.Annotations = map[string]string{}
+		}
+		if existing.Annotations[key] != value {
+			existing.Annotations[key] = value
+			changed = true
+		}
+	}
+	for _, key := range ManagedArgoAnnotations() {
+		if _, ok := desired.Annotations[key]; ok {
+			continue
+		}
+		if _, ok := existing.Annotations[key]; ok {
+			delete(existing.Annotations, key)
+			changed = true
+		}
+	}
+	return changed
+}
+
 // ValidateClusterTLSConfig validates that we got proper based64 k/v fields.
+// The CA is only optional for insecure clusters.
 func ValidateClusterTLSConfig(a *ArgoTLS) error {
-	for _, v := range []string{a.CaData, a.CertData, a.KeyData} {
+	return validateTLSFields(a, true)
+}
+
+// validateTLSFields validates the ArgoTLS fields, only requiring the client
+// certificate when asked to.
+func validateTLSFields(a *ArgoTLS, requireClientCert bool) error {
+	fields := []struct {
+		value    string
+		required bool
+	}{
+		{a.CaData, !a.Insecure},
+		{a.CertData, requireClientCert},
+		{a.KeyData, requireClientCert},
+	}
+	for _, f := range fields {
 		// Check if field.value is empty
-		if v == "" {
-			return errors.New("missing key on ArgoTLS config")
+		if f.value == "" {
+			if f.required {
+				return errors.New("missing key on ArgoTLS config")
+			}
+			continue
 		}
 		// Check if field.value is valid b64 encoded string
-		if _, err := b64.StdEncoding.DecodeString(v); err != nil {
+		if _, err := b64.StdEncoding.DecodeString(f.value); err != nil {
 			return err
 		}
 	}
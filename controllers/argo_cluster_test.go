@@ -60,6 +60,85 @@ func TestConvertToSecret(t *testing.T) {
 	}
 }
 
+func TestConvertToSecretProject(t *testing.T) {
+	t.Parallel()
+	a := MockArgoCluster(true)
+	s, err := a.ConvertToSecret()
+	assert.Nil(t, err)
+	assert.NotContains(t, s.Data, "project")
+
+	a.ClusterProject = "tenant-a"
+	s, err = a.ConvertToSecret()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("tenant-a"), s.Data["project"])
+}
+
+func TestConvertToSecretScope(t *testing.T) {
+	t.Parallel()
+	a := MockArgoCluster(true)
+	a.ClusterScope = ClusterScope{ClusterResources: boolPtr(true)}
+	s, err := a.ConvertToSecret()
+	assert.Nil(t, err)
+	assert.NotContains(t, s.Data, "namespaces")
+	assert.NotContains(t, s.Data, "clusterResources")
+
+	a.ClusterScope.Namespaces = []string{"apps", "monitoring"}
+	s, err = a.ConvertToSecret()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("apps,monitoring"), s.Data["namespaces"])
+	assert.Equal(t, []byte("true"), s.Data["clusterResources"])
+}
+
+func TestConvertToSecretShard(t *testing.T) {
+	t.Parallel()
+	a := MockArgoCluster(true)
+	s, err := a.ConvertToSecret()
+	assert.Nil(t, err)
+	assert.NotContains(t, s.Data, "shard")
+
+	shard := 2
+	a.ClusterShard = &shard
+	s, err = a.ConvertToSecret()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), s.Data["shard"])
+}
+
+func TestSyncArgoSecretData(t *testing.T) {
+	t.Parallel()
+	desired := MockArgoSecret()
+	existing := desired.DeepCopy()
+	assert.False(t, SyncArgoSecretData(existing, desired))
+
+	desired.Data["server"] = []byte("new-server")
+	assert.True(t, SyncArgoSecretData(existing, desired))
+	assert.Equal(t, []byte("new-server"), existing.Data["server"])
+
+	desired.Data["project"] = []byte("tenant-a")
+	assert.True(t, SyncArgoSecretData(existing, desired))
+	assert.Equal(t, []byte("tenant-a"), existing.Data["project"])
+
+	delete(desired.Data, "project")
+	assert.True(t, SyncArgoSecretData(existing, desired))
+	assert.NotContains(t, existing.Data, "project")
+}
+
+func TestSyncArgoSecretLabels(t *testing.T) {
+	t.Parallel()
+	desired := MockArgoSecret()
+	existing := desired.DeepCopy()
+	existing.Labels["team"] = "platform"
+	assert.False(t, SyncArgoSecretLabels(existing, desired))
+
+	desired.Labels[shardWeightLabel] = "3"
+	assert.True(t, SyncArgoSecretLabels(existing, desired))
+	assert.Equal(t, "3", existing.Labels[shardWeightLabel])
+
+	delete(desired.Labels, shardWeightLabel)
+	assert.True(t, SyncArgoSecretLabels(existing, desired))
+	assert.NotContains(t, existing.Labels, shardWeightLabel)
+	assert.Equal(t, "platform", existing.Labels["team"])
+}
+
 func TestValidateClusterTLSConfig(t *testing.T) {
 	// Create a dummy valid b64 string
 	enc := b64.StdEncoding.EncodeToString([]byte("test"))
@@ -73,6 +152,7 @@ func TestValidateClusterTLSConfig(t *testing.T) {
 		{"test type with valid fields", &ArgoTLS{CaData: enc, CertData: enc, KeyData: enc}, false},
 		{"test type with non-valid field", &ArgoTLS{CaData: "non-valid", CertData: enc, KeyData: enc}, true},
 		{"test type with missing fields", &ArgoTLS{CaData: enc}, true},
+		{"test insecure type without ca", &ArgoTLS{CertData: enc, KeyData: enc, Insecure: true}, false},
 		{"test empty type", &ArgoTLS{}, true},
 	}
 	for _, tt := range tests {
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+const (
+	// ProxyURLAnnotation on a CAPI Cluster sets the proxy Argo uses to reach the cluster.
+	ProxyURLAnnotation = "capi-to-argocd/proxy-url"
+
+	// TLSServerNameAnnotation on a CAPI Cluster sets the name used to verify the
+	// certificate of the cluster API server.
+	TLSServerNameAnnotation = "capi-to-argocd/tls-server-name"
+
+	// InsecureAnnotation on a CAPI Cluster skips verifying the certificate of the
+	// cluster API server. Only honored when AllowInsecureClusters is set, being ignored
+	// otherwise so the CA is kept.
+	InsecureAnnotation = "capi-to-argocd/insecure"
+)
+
+var (
+	// AllowInsecureClusters allows registering clusters whose API server certificate is not verified.
+	AllowInsecureClusters bool
+)
+
+// ResolveConnection applies kubeconfig connection settings and Cluster annotation
+// overrides to the ArgoConfig. Invalid insecure annotations are ignored, as are all of
+// them unless AllowInsecureClusters is set.
+func ResolveConnection(cfg *ArgoConfig, info ClusterInfo, clusterAnnotations map[string]string) {
+	cfg.ProxyURL = info.ProxyURL
+	cfg.TLSClientConfig.ServerName = info.TLSServerName
+	cfg.TLSClientConfig.Insecure = info.InsecureSkipTLSVerify
+
+	if v, ok := clusterAnnotations[ProxyURLAnnotation]; ok {
+		cfg.ProxyURL = v
+	}
+	if v, ok := clusterAnnotations[TLSServerNameAnnotation]; ok {
+		cfg.TLSClientConfig.ServerName = v
+	}
+	if v, ok := clusterAnnotations[InsecureAnnotation]; ok && AllowInsecureClusters {
+		if insecure, err := strconv.ParseBool(v); err == nil {
+			cfg.TLSClientConfig.Insecure = insecure
+		}
+	}
+
+	// Argo refuses a CA together with insecure, as the CA would never be used.
+	if cfg.TLSClientConfig.Insecure {
+		cfg.TLSClientConfig.CaData = ""
+	}
+}
+
+// InsecureIgnored reports whether Cluster annotations ask for skipping TLS verification
+// while insecure clusters are not allowed.
+func InsecureIgnored(clusterAnnotations map[string]string) bool {
+	insecure, _ := strconv.ParseBool(clusterAnnotations[InsecureAnnotation])
+	return insecure && !AllowInsecureClusters
+}
+
+// ValidateClusterConfig validates the connection settings and TLS config of an ArgoConfig.
+func ValidateClusterConfig(cfg *ArgoConfig) error {
+	if cfg.ProxyURL != "" {
+		u, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return err
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+		}
+		if u.Host == "" {
+			return errors.New("missing proxy host")
+		}
+	}
+	if cfg.TLSClientConfig.Insecure && !AllowInsecureClusters {
+		return errors.New("insecure clusters are not allowed")
+	}
+	if cfg.ExecProviderConfig != nil && cfg.ExecProviderConfig.Command == "" {
+		return errors.New("missing exec provider command")
+	}
+	if cfg.BearerToken != "" || cfg.ExecProviderConfig != nil {
+		// Client certificates are optional next to token based auth.
+		return validateTLSFields(&cfg.TLSClientConfig, false)
+	}
+	return ValidateClusterTLSConfig(&cfg.TLSClientConfig)
+}
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	b64 "encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestResolveConnection(t *testing.T) {
+	old := AllowInsecureClusters
+	defer func() { AllowInsecureClusters = old }()
+	AllowInsecureClusters = true
+	raw := []byte(`
+certificate-authority-data: Y2E=
+server: https://10.0.0.1:6443
+proxy-url: http://proxy.local:3128
+tls-server-name: api.workload.local
+`)
+	var info ClusterInfo
+	assert.Nil(t, yaml.Unmarshal(raw, &info))
+
+	tests := []struct {
+		testName           string
+		testAnnotations    map[string]string
+		testExpectedConfig ArgoConfig
+	}{
+		{"test kubeconfig settings", nil,
+			ArgoConfig{ProxyURL: "http://proxy.local:3128", TLSClientConfig: ArgoTLS{CaData: "Y2E=", ServerName: "api.workload.local"}}},
+		{"test annotation overrides", map[string]string{ProxyURLAnnotation: "", TLSServerNameAnnotation: "api.site.local"},
+			ArgoConfig{TLSClientConfig: ArgoTLS{CaData: "Y2E=", ServerName: "api.site.local"}}},
+		{"test insecure annotation drops ca", map[string]string{InsecureAnnotation: "true"},
+			ArgoConfig{ProxyURL: "http://proxy.local:3128", TLSClientConfig: ArgoTLS{ServerName: "api.workload.local", Insecure: true}}},
+		{"test invalid insecure annotation", map[string]string{InsecureAnnotation: "maybe"},
+			ArgoConfig{ProxyURL: "http://proxy.local:3128", TLSClientConfig: ArgoTLS{CaData: "Y2E=", ServerName: "api.workload.local"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			cfg := ArgoConfig{TLSClientConfig: ArgoTLS{CaData: info.CaData}}
+			ResolveConnection(&cfg, info, tt.testAnnotations)
+			assert.Equal(t, tt.testExpectedConfig, cfg)
+		})
+	}
+
+	// Unless insecure clusters are allowed, the annotation is ignored and the CA kept.
+	AllowInsecureClusters = false
+	annotations := map[string]string{InsecureAnnotation: "true"}
+	cfg := ArgoConfig{TLSClientConfig: ArgoTLS{CaData: info.CaData}}
+	ResolveConnection(&cfg, info, annotations)
+	assert.Equal(t, ArgoConfig{ProxyURL: "http://proxy.local:3128", TLSClientConfig: ArgoTLS{CaData: "Y2E=", ServerName: "api.workload.local"}}, cfg)
+	assert.True(t, InsecureIgnored(annotations))
+	assert.Nil(t, ValidateClusterConfig(&ArgoConfig{BearerToken: "token", TLSClientConfig: cfg.TLSClientConfig}))
+}
+
+func TestValidateClusterConfig(t *testing.T) {
+	oldAllow := AllowInsecureClusters
+	defer func() { AllowInsecureClusters = oldAllow }()
+	AllowInsecureClusters = false
+
+	enc := b64.StdEncoding.EncodeToString([]byte("tester"))
+	valid := ArgoTLS{CaData: enc, CertData: enc, KeyData: enc}
+	insecure := ArgoTLS{CertData: enc, KeyData: enc, Insecure: true}
+
+	assert.Nil(t, ValidateClusterConfig(&ArgoConfig{TLSClientConfig: valid}))
+	assert.Nil(t, ValidateClusterConfig(&ArgoConfig{TLSClientConfig: valid, ProxyURL: "socks5://proxy.local:1080"}))
+	assert.NotNil(t, ValidateClusterConfig(&ArgoConfig{TLSClientConfig: valid, ProxyURL: "ftp://proxy.local"}))
+	assert.NotNil(t, ValidateClusterConfig(&ArgoConfig{TLSClientConfig: valid, ProxyURL: "http://"}))
+
+	ca := ArgoTLS{CaData: enc}
+	assert.NotNil(t, ValidateClusterConfig(&ArgoConfig{TLSClientConfig: ca}))
+	assert.Nil(t, ValidateClusterConfig(&ArgoConfig{TLSClientConfig: ca, BearerToken: "token"}))
+	assert.Nil(t, ValidateClusterConfig(&ArgoConfig{TLSClientConfig: ca, ExecProviderConfig: &ArgoExecProvider{Command: "aws"}}))
+	assert.NotNil(t, ValidateClusterConfig(&ArgoConfig{TLSClientConfig: ca, ExecProviderConfig: &ArgoExecProvider{}}))
+	assert.NotNil(t, ValidateClusterConfig(&ArgoConfig{TLSClientConfig: ArgoTLS{}, BearerToken: "token"}))
+
+	assert.NotNil(t, ValidateClusterConfig(&ArgoConfig{TLSClientConfig: insecure}))
+	AllowInsecureClusters = true
+	assert.Nil(t, ValidateClusterConfig(&ArgoConfig{TLSClientConfig: insecure}))
+}
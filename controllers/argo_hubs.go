@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// LocalHub is the name of the cluster the controller runs in.
+const LocalHub = ""
+
+var (
+	// ArgoHubs holds remote clusters that host Argo instances.
+	ArgoHubs []ArgoHub
+
+	// ArgoDefaultHub is the hub used by clusters or rules that do not name one.
+	// When empty, ArgoSecrets are written into the local cluster.
+	ArgoDefaultHub string
+)
+
+// ArgoHub represents a remote cluster hosting Argo, reachable through a kubeconfig
+// that is either mounted as a file or stored in a local secret.
+type ArgoHub struct {
+	Name             string        `json:"name"`
+	KubeConfigPath   string        `json:"kubeconfigPath,omitempty"`
+	KubeConfigSecret *SecretKeyRef `json:"kubeconfigSecret,omitempty"`
+}
+
+// SecretKeyRef points to a single key of a secret.
+type SecretKeyRef struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Key       string `json:"key,omitempty"`
+}
+
+// ArgoTarget represents an Argo namespace on a given hub.
+type ArgoTarget struct {
+	Hub       string
+	Namespace string
+}
+
+// String returns a human readable representation of the target.
+func (t ArgoTarget) String() string {
+	if t.Hub == LocalHub {
+		return t.Namespace
+	}
+	return t.Hub + "/" + t.Namespace
+}
+
+// ValidateArgoHubs validates hub definitions and the default hub reference.
+func ValidateArgoHubs(hubs []ArgoHub, defaultHub string) error {
+	names := map[string]struct{}{}
+	for i, hub := range hubs {
+		if hub.Name == LocalHub {
+			return fmt.Errorf("hub %d: missing name", i)
+		}
+		if _, ok := names[hub.Name]; ok {
+			return fmt.Errorf("hub %s: duplicate name", hub.Name)
+		}
+		names[hub.Name] = struct{}{}
+		if (hub.KubeConfigPath == "") == (hub.KubeConfigSecret == nil) {
+			return fmt.Errorf("hub %s: exactly one of kubeconfigPath or kubeconfigSecret must be set", hub.Name)
+		}
+	}
+	if _, ok := names[defaultHub]; defaultHub != LocalHub && !ok {
+		return fmt.Errorf("default hub %s is not defined", defaultHub)
+	}
+	return nil
+}
+
+// ArgoHubClients hands out clients for writing ArgoSecrets into the local cluster or
+// into remote hubs. Remote clients are built lazily and rebuilt when their kubeconfig changes.
+type ArgoHubClients struct {
+	local  client.Client
+	scheme *runtime.Scheme
+	hubs   map[string]ArgoHub
+
+	mu      sync.Mutex
+	clients map[string]hubClient
+}
+
+// hubClient caches a client along with the kubeconfig it was built from.
+type hubClient struct {
+	client     client.Client
+	kubeConfig []byte
+}
+
+// NewArgoHubClients returns a registry for the given hubs. The local client is also
+// used to read kubeconfig secrets of remote hubs.
+func NewArgoHubClients(local client.Client, scheme *runtime.Scheme, hubs []ArgoHub) *ArgoHubClients {
+	h := &ArgoHubClients{
+		local:   local,
+		scheme:  scheme,
+		hubs:    map[string]ArgoHub{},
+		clients: map[string]hubClient{},
+	}
+	for _, hub := range hubs {
+		h.hubs[hub.Name] = hub
+	}
+	return h
+}
+
+// Set registers a prebuilt client for a hub, bypassing kubeconfig loading.
+func (h *ArgoHubClients) Set(name string, c client.Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.hubs[name]; !ok {
+		h.hubs[name] = ArgoHub{Name: name}
+	}
+	h.clients[name] = hubClient{client: c}
+}
+
+// Names returns the local hub followed by all remote hubs, sorted.
+func (h *ArgoHubClients) Names() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	names := make([]string, 0, len(h.hubs))
+	for name := range h.hubs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{LocalHub}, names...)
+}
+
+// Get returns the client for the given hub. Kubeconfigs are loaded and clients built
+// without holding the lock, so a slow hub does not block the others.
+func (h *ArgoHubClients) Get(ctx context.Context, name string) (client.Client, error) {
+	if name == LocalHub {
+		return h.local, nil
+	}
+
+	h.mu.Lock()
+	hub, ok := h.hubs[name]
+	cached, cachedOk := h.clients[name]
+	h.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown hub %s", name)
+	}
+	if cachedOk && hub.KubeConfigPath == "" && hub.KubeConfigSecret == nil {
+		return cached.client, nil
+	}
+
+	kubeConfig, err := h.loadKubeConfig(ctx, hub)
+	if err != nil {
+		return nil, err
+	}
+	if cachedOk && bytes.Equal(cached.kubeConfig, kubeConfig) {
+		return cached.client, nil
+	}
+
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("hub %s: %w", name, err)
+	}
+	c, err := client.New(restConfig, client.Options{Scheme: h.scheme})
+	if err != nil {
+		return nil, fmt.Errorf("hub %s: %w", name, err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// Keep the client of a concurrent call that built one from the same kubeconfig.
+	if current, ok := h.clients[name]; ok && bytes.Equal(current.kubeConfig, kubeConfig) {
+		return current.client, nil
+	}
+	h.clients[name] = hubClient{client: c, kubeConfig: kubeConfig}
+	return c, nil
+}
+
+// loadKubeConfig reads the raw kubeconfig of a hub from its file or secret.
+func (h *ArgoHubClients) loadKubeConfig(ctx context.Context, hub ArgoHub) ([]byte, error) {
+	if hub.KubeConfigPath != "" {
+		return os.ReadFile(hub.KubeConfigPath)
+	}
+
+	ref := hub.KubeConfigSecret
+	key := ref.Key
+	if key == "" {
+		key = "value"
+	}
+	var s corev1.Secret
+	if err := h.local.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}, &s); err != nil {
+		return nil, fmt.Errorf("hub %s: %w", hub.Name, err)
+	}
+	kubeConfig, ok := s.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("hub %s: missing key %s on kubeconfig secret", hub.Name, key)
+	}
+	return kubeConfig, nil
+}
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+func TestValidateArgoHubs(t *testing.T) {
+	t.Parallel()
+	secretRef := &SecretKeyRef{Name: "hub-a", Namespace: "default"}
+	tests := []struct {
+		testName          string
+		testMock          []ArgoHub
+		testDefaultHub    string
+		testExpectedError bool
+	}{
+		{"test no hubs", nil, LocalHub, false},
+		{"test hub with path", []ArgoHub{{Name: "hub-a", KubeConfigPath: "/etc/hub-a"}}, "hub-a", false},
+		{"test hub with secret", []ArgoHub{{Name: "hub-a", KubeConfigSecret: secretRef}}, LocalHub, false},
+		{"test hub without name", []ArgoHub{{KubeConfigPath: "/etc/hub-a"}}, LocalHub, true},
+		{"test hub without kubeconfig", []ArgoHub{{Name: "hub-a"}}, LocalHub, true},
+		{"test hub with both kubeconfigs", []ArgoHub{{Name: "hub-a", KubeConfigPath: "/etc/hub-a", KubeConfigSecret: secretRef}}, LocalHub, true},
+		{"test duplicate hubs", []ArgoHub{{Name: "hub-a", KubeConfigPath: "/a"}, {Name: "hub-a", KubeConfigPath: "/b"}}, LocalHub, true},
+		{"test unknown default hub", nil, "hub-a", true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateArgoHubs(tt.testMock, tt.testDefaultHub)
+			if tt.testExpectedError {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestArgoHubClients(t *testing.T) {
+	t.Parallel()
+	h := NewArgoHubClients(nil, scheme.Scheme, []ArgoHub{
+		{Name: "hub-path", KubeConfigPath: "../tests/hub-kubeconfig.yaml"},
+		{Name: "hub-missing", KubeConfigPath: "../tests/missing-kubeconfig.yaml"},
+	})
+	assert.Equal(t, []string{LocalHub, "hub-missing", "hub-path"}, h.Names())
+
+	c, err := h.Get(context.Background(), LocalHub)
+	assert.Nil(t, err)
+	assert.Nil(t, c)
+
+	c, err = h.Get(context.Background(), "hub-path")
+	assert.Nil(t, err)
+	assert.NotNil(t, c)
+	cached, err := h.Get(context.Background(), "hub-path")
+	assert.Nil(t, err)
+	assert.Equal(t, c, cached)
+
+	_, err = h.Get(context.Background(), "hub-missing")
+	assert.NotNil(t, err)
+	_, err = h.Get(context.Background(), "hub-unknown")
+	assert.NotNil(t, err)
+
+	h.Set("hub-static", c)
+	static, err := h.Get(context.Background(), "hub-static")
+	assert.Nil(t, err)
+	assert.Equal(t, c, static)
+}
+
+// TestReconcileRemoteHub runs the reconciler against two envtest instances, one acting
+// as the CAPI management cluster and one as the remote Argo hub.
+func TestReconcileRemoteHub(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set")
+	}
+
+	ctx := context.Background()
+	localClient, stopLocal := startTestEnv(t)
+	defer stopLocal()
+	hubClient, stopHub := startTestEnv(t)
+	defer stopHub()
+
+	oldRules, oldGC := RoutingRules, EnableGarbageCollection
+	defer func() { RoutingRules, EnableGarbageCollection = oldRules, oldGC }()
+	RoutingRules = []RoutingRule{{Hub: "hub-a", ArgoNamespaces: []string{ArgoNamespace}}}
+	EnableGarbageCollection = true
+
+	for _, c := range []client.Client{localClient, hubClient} {
+		for _, ns := range []string{TestNamespace, ArgoNamespace} {
+			assert.Nil(t, c.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}}))
+		}
+	}
+	capiSecret := MockCapiSecret(true, true, true, "remote-kubeconfig", TestNamespace)
+	assert.Nil(t, localClient.Create(ctx, capiSecret))
+
+	hubs := NewArgoHubClients(localClient, scheme.Scheme, nil)
+	hubs.Set("hub-a", hubClient)
+	r := &Capi2Argo{Client: localClient, Log: TestLog, Scheme: scheme.Scheme, Hubs: hubs}
+
+	req := MockReconcileReq("remote-kubeconfig", TestNamespace)
+	_, err := r.Reconcile(ctx, req)
+	assert.Nil(t, err)
+
+	argoKey := types.NamespacedName{Name: "cluster-remote", Namespace: ArgoNamespace}
+	var argoSecret corev1.Secret
+	assert.Nil(t, hubClient.Get(ctx, argoKey, &argoSecret))
+	assert.NotNil(t, localClient.Get(ctx, argoKey, &argoSecret))
+
+	// Deleting the CapiSecret must garbage collect the ArgoSecret on the hub.
+	assert.Nil(t, localClient.Delete(ctx, capiSecret))
+	_, err = r.Reconcile(ctx, req)
+	assert.Nil(t, err)
+	assert.NotNil(t, hubClient.Get(ctx, argoKey, &argoSecret))
+}
+
+// startTestEnv starts an envtest instance and returns a client for it.
+func startTestEnv(t *testing.T) (client.Client, func()) {
+	env := &envtest.Environment{}
+	cfg, err := env.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := client.New(cfg, client.Options{Scheme: scheme.Scheme})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, func() {
+		_ = env.Stop()
+	}
+}
@@ -0,0 +1,302 @@
+package controllers
+
+import (
+	"context"
+	goErr "errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ProjectAnnotation on a CAPI Cluster sets the Argo project of the cluster, taking
+	// precedence over ProjectRules. It is only honored for projects allowed by a matching rule.
+	ProjectAnnotation = "capi-to-argocd/project"
+	// ProjectDestinationsAnnotation on an AppProject lists the destination servers added
+	// by the operator, which are the only ones it removes.
+	ProjectDestinationsAnnotation = "capi-to-argocd/destinations"
+)
+
+var (
+	// ProjectRules map CAPI clusters to Argo AppProjects.
+	ProjectRules []ProjectRule
+
+	// EnableProjectManagement enables creating AppProjects and keeping their
+	// destinations in line with the clusters mapped to them.
+	EnableProjectManagement bool
+
+	// ProjectTemplate sets the spec of AppProjects created by the operator. By default
+	// any source repository is permitted and no cluster scoped resource is.
+	ProjectTemplate = AppProjectTemplate{SourceRepos: []string{"*"}}
+)
+
+var argoAppProjectGVK = schema.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "AppProject"}
+
+// ProjectRule maps selected clusters to an Argo project. The project is either
+// fixed or read from the value of a Cluster label. AllowedProjects lists the projects
+// selected clusters may pick through the project annotation.
+type ProjectRule struct {
+	ClusterSelector
+	Project         string   `json:"project,omitempty"`
+	ProjectLabel    string   `json:"projectLabel,omitempty"`
+	AllowedProjects []string `json:"allowedProjects,omitempty"`
+}
+
+// AppProjectTemplate holds the spec fields of AppProjects created by the operator,
+// besides the destinations it manages. Existing AppProjects are never changed by it.
+type AppProjectTemplate struct {
+	SourceRepos              []string           `json:"sourceRepos"`
+	ClusterResourceWhitelist []ProjectGroupKind `json:"clusterResourceWhitelist,omitempty"`
+}
+
+// ProjectGroupKind selects resources by API group and kind, both accepting "*".
+type ProjectGroupKind struct {
+	Group string `json:"group"`
+	Kind  string `json:"kind"`
+}
+
+// ValidateProjectTemplate validates that the template permits a source repository and
+// that whitelisted resources name their kind.
+func ValidateProjectTemplate(t AppProjectTemplate) error {
+	if len(t.SourceRepos) == 0 {
+		return goErr.New("sourceRepos must not be empty")
+	}
+	for i, gk := range t.ClusterResourceWhitelist {
+		if gk.Kind == "" {
+			return fmt.Errorf("clusterResourceWhitelist %d: kind must be set", i)
+		}
+	}
+	return nil
+}
+
+// applyProjectTemplate sets the spec fields of the template on a new AppProject.
+func applyProjectTemplate(project *unstructured.Unstructured, t AppProjectTemplate) error {
+	repos := make([]interface{}, 0, len(t.SourceRepos))
+	for _, repo := range t.SourceRepos {
+		repos = append(repos, repo)
+	}
+	if err := unstructured.SetNestedSlice(project.Object, repos, "spec", "sourceRepos"); err != nil {
+		return err
+	}
+	if len(t.ClusterResourceWhitelist) == 0 {
+		return nil
+	}
+	whitelist := make([]interface{}, 0, len(t.ClusterResourceWhitelist))
+	for _, gk := range t.ClusterResourceWhitelist {
+		whitelist = append(whitelist, map[string]interface{}{"group": gk.Group, "kind": gk.Kind})
+	}
+	return unstructured.SetNestedSlice(project.Object, whitelist, "spec", "clusterResourceWhitelist")
+}
+
+// AllowsProject reports whether the rule lets clusters pick given project.
+func (r ProjectRule) AllowsProject(project string) bool {
+	for _, allowed := range r.AllowedProjects {
+		if allowed == project {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateProjectRules validates that every rule resolves to a project or allows
+// picking one.
+func ValidateProjectRules(rules []ProjectRule) error {
+	for i, rule := range rules {
+		if rule.Project != "" && rule.ProjectLabel != "" {
+			return fmt.Errorf("project rule %d: only one of project or projectLabel can be set", i)
+		}
+		if rule.Project == "" && rule.ProjectLabel == "" && len(rule.AllowedProjects) == 0 {
+			return fmt.Errorf("project rule %d: one of project, projectLabel or allowedProjects must be set", i)
+		}
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("project rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// ResolveProject returns the Argo project of a cluster. The project annotation wins when
+// a matching rule allows it, otherwise the first matching rule that resolves to a
+// non-empty project is used.
+func ResolveProject(namespace string, clusterLabels, clusterAnnotations map[string]string) string {
+	if project := clusterAnnotations[ProjectAnnotation]; project != "" {
+		for _, rule := range ProjectRules {
+			if rule.AllowsProject(project) && rule.Matches(namespace, clusterLabels) {
+				return project
+			}
+		}
+	}
+	for _, rule := range ProjectRules {
+		if !rule.Matches(namespace, clusterLabels) {
+			continue
+		}
+		project := rule.Project
+		if rule.ProjectLabel != "" {
+			project = clusterLabels[rule.ProjectLabel]
+		}
+		if project != "" {
+			return project
+		}
+	}
+	return ""
+}
+
+// managedDestinations returns the destination servers added to an AppProject by the operator.
+func managedDestinations(project *unstructured.Unstructured) []string {
+	var servers []string
+	for _, server := range strings.Split(project.GetAnnotations()[ProjectDestinationsAnnotation], ",") {
+		if server != "" {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
+// setManagedDestinations records the destination servers added to an AppProject by the operator.
+func setManagedDestinations(project *unstructured.Unstructured, servers []string) {
+	annotations := project.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	if len(servers) == 0 {
+		delete(annotations, ProjectDestinationsAnnotation)
+	} else {
+		annotations[ProjectDestinationsAnnotation] = strings.Join(servers, ",")
+	}
+	project.SetAnnotations(annotations)
+}
+
+// AddProjectDestination adds the cluster server as a destination of the AppProject,
+// unless it is already there, recording it as added by the operator. It reports
+// whether the project changed.
+func AddProjectDestination(project *unstructured.Unstructured, server string) (bool, error) {
+	destinations, _, err := unstructured.NestedSlice(project.Object, "spec", "destinations")
+	if err != nil {
+		return false, err
+	}
+	for _, d := range destinations {
+		if dest, ok := d.(map[string]interface{}); ok && dest["server"] == server {
+			return false, nil
+		}
+	}
+	destinations = append(destinations, map[string]interface{}{
+		"server":    server,
+		"namespace": "*",
+	})
+	setManagedDestinations(project, append(managedDestinations(project), server))
+	return true, unstructured.SetNestedSlice(project.Object, destinations, "spec", "destinations")
+}
+
+// RemoveProjectDestination removes the destination added by the operator for the cluster
+// server from the AppProject. Destinations of AppProjects created by the operator are
+// all its own, others are left alone. It reports whether the project changed.
+func RemoveProjectDestination(project *unstructured.Unstructured, server string) (bool, error) {
+	managed := managedDestinations(project)
+	owned := project.GetLabels()["capi-to-argocd/owned"] == "true"
+	if !owned && !containsString(managed, server) {
+		return false, nil
+	}
+	remaining := make([]string, 0, len(managed))
+	for _, s := range managed {
+		if s != server {
+			remaining = append(remaining, s)
+		}
+	}
+	setManagedDestinations(project, remaining)
+
+	destinations, _, err := unstructured.NestedSlice(project.Object, "spec", "destinations")
+	if err != nil {
+		return false, err
+	}
+	kept := make([]interface{}, 0, len(destinations))
+	for _, d := range destinations {
+		if dest, ok := d.(map[string]interface{}); ok && dest["server"] == server && (owned || dest["namespace"] == "*") {
+			continue
+		}
+		kept = append(kept, d)
+	}
+	if len(kept) == len(destinations) {
+		return len(remaining) != len(managed), nil
+	}
+	return true, unstructured.SetNestedSlice(project.Object, kept, "spec", "destinations")
+}
+
+// EnsureAppProject creates the AppProject of an ArgoCluster if missing and makes
+// sure the cluster is part of its destinations.
+func EnsureAppProject(ctx context.Context, c client.Client, log logr.Logger, a *ArgoCluster) error {
+	if !EnableProjectManagement || a.ClusterProject == "" {
+		return nil
+	}
+	log = log.WithValues("project", a.ClusterProject)
+
+	project := &unstructured.Unstructured{}
+	project.SetGroupVersionKind(argoAppProjectGVK)
+	key := types.NamespacedName{Name: a.ClusterProject, Namespace: a.NamespacedName.Namespace}
+	err := c.Get(ctx, key, project)
+	if apierrors.IsNotFound(err) {
+		project.SetName(key.Name)
+		project.SetNamespace(key.Namespace)
+		project.SetLabels(map[string]string{"capi-to-argocd/owned": "true"})
+		if _, err := AddProjectDestination(project, a.ClusterServer); err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedField(project.Object, "Managed by capi2argo-cluster-operator", "spec", "description"); err != nil {
+			return err
+		}
+		if err := applyProjectTemplate(project, ProjectTemplate); err != nil {
+			return err
+		}
+		if err := c.Create(ctx, project); err != nil {
+			log.Error(err, "Failed to create AppProject")
+			return err
+		}
+		log.Info("Created new AppProject")
+		return nil
+	}
+	if err != nil {
+		log.Error(err, "Failed to fetch AppProject")
+		return err
+	}
+
+	changed, err := AddProjectDestination(project, a.ClusterServer)
+	if err != nil || !changed {
+		return err
+	}
+	if err := c.Update(ctx, project); err != nil {
+		log.Error(err, "Failed to add cluster to AppProject destinations")
+		return err
+	}
+	log.Info("Added cluster to AppProject destinations")
+	return nil
+}
+
+// ReleaseAppProject removes the cluster server from the destinations of the AppProject
+// it was mapped to, when added by the operator. Missing projects are ignored.
+func ReleaseAppProject(ctx context.Context, c client.Client, log logr.Logger, namespace, projectName, server string) error {
+	if !EnableProjectManagement || projectName == "" {
+		return nil
+	}
+
+	project := &unstructured.Unstructured{}
+	project.SetGroupVersionKind(argoAppProjectGVK)
+	err := c.Get(ctx, types.NamespacedName{Name: projectName, Namespace: namespace}, project)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	changed, err := RemoveProjectDestination(project, server)
+	if err != nil || !changed {
+		return err
+	}
+	if err := c.Update(ctx, project); err != nil {
+		log.Error(err, "Failed to remove cluster from AppProject destinations", "project", projectName)
+		return err
+	}
+	log.Info("Removed cluster from AppProject destinations", "project", projectName)
+	return nil
+}
@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestResolveProject(t *testing.T) {
+	oldRules := ProjectRules
+	defer func() { ProjectRules = oldRules }()
+
+	ProjectRules = []ProjectRule{
+		{ClusterSelector: ClusterSelector{Namespaces: []string{"tenant-a"}}, Project: "tenant-a"},
+		{ClusterSelector: ClusterSelector{LabelSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "team", Operator: metav1.LabelSelectorOpExists},
+		}}}, ProjectLabel: "team"},
+		{ClusterSelector: ClusterSelector{Namespaces: []string{"tenant-b"}}, AllowedProjects: []string{"shared"}},
+	}
+
+	assert.Equal(t, "", ResolveProject("test", nil, nil))
+	assert.Equal(t, "tenant-a", ResolveProject("tenant-a", nil, nil))
+	assert.Equal(t, "tenant-a", ResolveProject("tenant-a", map[string]string{"team": "blue"}, nil))
+	assert.Equal(t, "blue", ResolveProject("test", map[string]string{"team": "blue"}, nil))
+	// The annotation only picks projects allowed by a matching rule.
+	assert.Equal(t, "tenant-a", ResolveProject("tenant-a", nil, map[string]string{ProjectAnnotation: "override"}))
+	assert.Equal(t, "tenant-a", ResolveProject("tenant-a", nil, map[string]string{ProjectAnnotation: "shared"}))
+	assert.Equal(t, "", ResolveProject("test", nil, map[string]string{ProjectAnnotation: "shared"}))
+	assert.Equal(t, "shared", ResolveProject("tenant-b", nil, map[string]string{ProjectAnnotation: "shared"}))
+	assert.Equal(t, "", ResolveProject("tenant-b", nil, map[string]string{ProjectAnnotation: "tenant-a"}))
+}
+
+func TestValidateProjectRules(t *testing.T) {
+	t.Parallel()
+	assert.Nil(t, ValidateProjectRules([]ProjectRule{{Project: "test"}, {ProjectLabel: "team"}}))
+	assert.NotNil(t, ValidateProjectRules([]ProjectRule{{}}))
+	assert.Nil(t, ValidateProjectRules([]ProjectRule{{AllowedProjects: []string{"test"}}}))
+	assert.NotNil(t, ValidateProjectRules([]ProjectRule{{Project: "test", ProjectLabel: "team"}}))
+}
+
+func TestProjectDestinations(t *testing.T) {
+	t.Parallel()
+	project := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"destinations": []interface{}{
+				map[string]interface{}{"server": "https://other", "namespace": "*"},
+			},
+		},
+	}}
+
+	changed, err := AddProjectDestination(project, "https://test")
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	changed, err = AddProjectDestination(project, "https://test")
+	assert.Nil(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, "https://test", project.GetAnnotations()[ProjectDestinationsAnnotation])
+
+	destinations, _, _ := unstructured.NestedSlice(project.Object, "spec", "destinations")
+	assert.Len(t, destinations, 2)
+
+	changed, err = RemoveProjectDestination(project, "https://test")
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	changed, err = RemoveProjectDestination(project, "https://test")
+	assert.Nil(t, err)
+	assert.False(t, changed)
+	assert.NotContains(t, project.GetAnnotations(), ProjectDestinationsAnnotation)
+
+	// Destinations not added by the operator are left alone.
+	changed, err = RemoveProjectDestination(project, "https://other")
+	assert.Nil(t, err)
+	assert.False(t, changed)
+
+	destinations, _, _ = unstructured.NestedSlice(project.Object, "spec", "destinations")
+	assert.Len(t, destinations, 1)
+
+	changed, err = AddProjectDestination(&unstructured.Unstructured{Object: map[string]interface{}{}}, "https://test")
+	assert.Nil(t, err)
+	assert.True(t, changed)
+}
+
+func TestRemoveProjectDestinationOwnedProject(t *testing.T) {
+	t.Parallel()
+	project := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"destinations": []interface{}{
+				map[string]interface{}{"server": "https://test", "namespace": "*"},
+				map[string]interface{}{"server": "https://other", "namespace": "*"},
+			},
+		},
+	}}
+	project.SetLabels(map[string]string{"capi-to-argocd/owned": "true"})
+
+	// AppProjects created by the operator before destinations were recorded are its own.
+	changed, err := RemoveProjectDestination(project, "https://test")
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	destinations, _, _ := unstructured.NestedSlice(project.Object, "spec", "destinations")
+	assert.Equal(t, []interface{}{map[string]interface{}{"server": "https://other", "namespace": "*"}}, destinations)
+}
+
+func TestEnsureAppProject(t *testing.T) {
+	oldEnabled, oldTemplate := EnableProjectManagement, ProjectTemplate
+	defer func() { EnableProjectManagement, ProjectTemplate = oldEnabled, oldTemplate }()
+	EnableProjectManagement = true
+
+	tests := []struct {
+		testName          string
+		template          AppProjectTemplate
+		expectedRepos     []interface{}
+		expectedWhitelist []interface{}
+	}{
+		{"test default template", AppProjectTemplate{SourceRepos: []string{"*"}}, []interface{}{"*"}, nil},
+		{"test custom template", AppProjectTemplate{
+			SourceRepos:              []string{"https://git.example.com/*"},
+			ClusterResourceWhitelist: []ProjectGroupKind{{Group: "", Kind: "Namespace"}},
+		}, []interface{}{"https://git.example.com/*"}, []interface{}{map[string]interface{}{"group": "", "kind": "Namespace"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ProjectTemplate = tt.template
+			a := MockArgoCluster(true)
+			a.ClusterProject = "payments"
+			c := &mockClient{}
+			assert.Nil(t, EnsureAppProject(context.Background(), c, logr.Discard(), a))
+			assert.Len(t, c.objects, 1)
+
+			spec := c.objects[0].Object["spec"].(map[string]interface{})
+			assert.Equal(t, tt.expectedRepos, spec["sourceRepos"])
+			assert.Equal(t, []interface{}{map[string]interface{}{"server": a.ClusterServer, "namespace": "*"}}, spec["destinations"])
+			whitelist, _, _ := unstructured.NestedSlice(c.objects[0].Object, "spec", "clusterResourceWhitelist")
+			assert.Equal(t, tt.expectedWhitelist, whitelist)
+		})
+	}
+}
+
+func TestValidateProjectTemplate(t *testing.T) {
+	t.Parallel()
+	assert.Nil(t, ValidateProjectTemplate(AppProjectTemplate{SourceRepos: []string{"*"}}))
+	assert.NotNil(t, ValidateProjectTemplate(AppProjectTemplate{}))
+	assert.NotNil(t, ValidateProjectTemplate(AppProjectTemplate{SourceRepos: []string{"*"}, ClusterResourceWhitelist: []ProjectGroupKind{{Group: "rbac.authorization.k8s.io"}}}))
+}
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// NamespacesAnnotation on a CAPI Cluster restricts Argo to a comma separated
+	// list of namespaces on the workload cluster.
+	NamespacesAnnotation = "capi-to-argocd/namespaces"
+
+	// ClusterResourcesAnnotation on a CAPI Cluster allows Argo to manage cluster
+	// scoped resources while restricted to namespaces.
+	ClusterResourcesAnnotation = "capi-to-argocd/cluster-resources"
+)
+
+var (
+	// ScopeRules restrict what Argo may manage on selected clusters.
+	ScopeRules []ScopeRule
+)
+
+// ScopeRule restricts Argo to the given namespaces on selected clusters.
+// Unless Enforce is set, Cluster annotations may narrow the rule further.
+type ScopeRule struct {
+	ClusterSelector
+	Namespaces       []string `json:"namespaces"`
+	ClusterResources bool     `json:"clusterResources,omitempty"`
+	Enforce          bool     `json:"enforce,omitempty"`
+}
+
+// ClusterScope represents the namespaces and clusterResources fields of an ArgoSecret.
+// An empty scope grants Argo cluster-wide access.
+type ClusterScope struct {
+	Namespaces       []string
+	ClusterResources *bool
+}
+
+// ValidateScopeRules validates that every rule restricts to at least one namespace.
+func ValidateScopeRules(rules []ScopeRule) error {
+	for i, rule := range rules {
+		if len(rule.Namespaces) == 0 {
+			return fmt.Errorf("scope rule %d: missing namespaces", i)
+		}
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("scope rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// ResolveScope returns the scope of a cluster from the first matching rule, narrowed
+// by Cluster annotations unless that rule is enforced. Annotations never widen the scope
+// of a rule: namespaces outside of it are dropped and cluster scoped resources are only
+// allowed when the rule allows them. Invalid clusterResources annotations are ignored.
+func ResolveScope(namespace string, clusterLabels, clusterAnnotations map[string]string) ClusterScope {
+	var rule *ScopeRule
+	for i := range ScopeRules {
+		if ScopeRules[i].Matches(namespace, clusterLabels) {
+			rule = &ScopeRules[i]
+			break
+		}
+	}
+
+	var scope ClusterScope
+	if rule != nil {
+		clusterResources := rule.ClusterResources
+		scope = ClusterScope{
+			Namespaces:       append([]string{}, rule.Namespaces...),
+			ClusterResources: &clusterResources,
+		}
+		if rule.Enforce {
+			return scope
+		}
+	}
+
+	if v, ok := clusterAnnotations[NamespacesAnnotation]; ok {
+		namespaces := splitList(v)
+		if rule != nil {
+			namespaces = intersectList(namespaces, rule.Namespaces)
+		}
+		if len(namespaces) > 0 {
+			scope.Namespaces = namespaces
+		}
+	}
+	if v, ok := clusterAnnotations[ClusterResourcesAnnotation]; ok {
+		if clusterResources, err := strconv.ParseBool(v); err == nil {
+			if rule != nil {
+				clusterResources = clusterResources && rule.ClusterResources
+			}
+			scope.ClusterResources = &clusterResources
+		}
+	}
+	return scope
+}
+
+// intersectList returns the items of list that are also in allowed, keeping their order.
+func intersectList(list, allowed []string) []string {
+	var items []string
+	for _, item := range list {
+		for _, a := range allowed {
+			if item == a {
+				items = append(items, item)
+				break
+			}
+		}
+	}
+	return items
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveScope(t *testing.T) {
+	oldRules := ScopeRules
+	defer func() { ScopeRules = oldRules }()
+
+	ScopeRules = []ScopeRule{
+		{ClusterSelector: ClusterSelector{Namespaces: []string{"tenant-a"}}, Namespaces: []string{"apps"}},
+		{ClusterSelector: ClusterSelector{Namespaces: []string{"tenant-b"}}, Namespaces: []string{"apps"}, Enforce: true},
+		{ClusterSelector: ClusterSelector{Namespaces: []string{"tenant-c"}}, Namespaces: []string{"apps", "monitoring"}, ClusterResources: true},
+	}
+	override := map[string]string{
+		NamespacesAnnotation:       "apps, monitoring,",
+		ClusterResourcesAnnotation: "true",
+	}
+
+	tests := []struct {
+		testName          string
+		testNamespace     string
+		testAnnotations   map[string]string
+		testExpectedScope ClusterScope
+	}{
+		{"test cluster without rule", "test", nil, ClusterScope{}},
+		{"test cluster with annotations only", "test", override,
+			ClusterScope{Namespaces: []string{"apps", "monitoring"}, ClusterResources: boolPtr(true)}},
+		{"test cluster with rule", "tenant-a", nil,
+			ClusterScope{Namespaces: []string{"apps"}, ClusterResources: boolPtr(false)}},
+		{"test cluster with rule and annotations", "tenant-a", override,
+			ClusterScope{Namespaces: []string{"apps"}, ClusterResources: boolPtr(false)}},
+		{"test cluster with rule and narrowing annotations", "tenant-c",
+			map[string]string{NamespacesAnnotation: "monitoring", ClusterResourcesAnnotation: "false"},
+			ClusterScope{Namespaces: []string{"monitoring"}, ClusterResources: boolPtr(false)}},
+		{"test cluster with rule and empty namespaces annotation", "tenant-a", map[string]string{NamespacesAnnotation: ""},
+			ClusterScope{Namespaces: []string{"apps"}, ClusterResources: boolPtr(false)}},
+		{"test cluster with rule and widening namespaces annotation", "tenant-a", map[string]string{NamespacesAnnotation: "kube-system"},
+			ClusterScope{Namespaces: []string{"apps"}, ClusterResources: boolPtr(false)}},
+		{"test cluster with enforced rule and annotations", "tenant-b", override,
+			ClusterScope{Namespaces: []string{"apps"}, ClusterResources: boolPtr(false)}},
+		{"test cluster with invalid annotation", "tenant-a", map[string]string{ClusterResourcesAnnotation: "yes"},
+			ClusterScope{Namespaces: []string{"apps"}, ClusterResources: boolPtr(false)}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			assert.Equal(t, tt.testExpectedScope, ResolveScope(tt.testNamespace, nil, tt.testAnnotations))
+		})
+	}
+}
+
+func TestValidateScopeRules(t *testing.T) {
+	t.Parallel()
+	assert.Nil(t, ValidateScopeRules([]ScopeRule{{Namespaces: []string{"apps"}}}))
+	assert.NotNil(t, ValidateScopeRules([]ScopeRule{{ClusterResources: true}}))
+}
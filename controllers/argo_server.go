@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	b64 "encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ServerRewriteRules rewrite the API server URL Argo uses to reach selected clusters.
+	ServerRewriteRules []ServerRewriteRule
+)
+
+// ServerRewriteRule rewrites the server URL of selected clusters matching Match into
+// Replace. Replace may reference regex groups ($1, ${name}) as well as the
+// {{namespace}} and {{cluster}} placeholders. When set, CaData is a base64 encoded
+// CA bundle added to the cluster CA, for endpoints served by another CA.
+type ServerRewriteRule struct {
+	ClusterSelector
+	Match   string `json:"match"`
+	Replace string `json:"replace"`
+	CaData  string `json:"caData,omitempty"`
+}
+
+// ValidateServerRewriteRules validates that every rule compiles and carries a valid CA bundle.
+func ValidateServerRewriteRules(rules []ServerRewriteRule) error {
+	for i, rule := range rules {
+		if rule.Match == "" || rule.Replace == "" {
+			return fmt.Errorf("server rewrite rule %d: match and replace must be set", i)
+		}
+		if _, err := regexp.Compile(rule.Match); err != nil {
+			return fmt.Errorf("server rewrite rule %d: %w", i, err)
+		}
+		if _, err := b64.StdEncoding.DecodeString(rule.CaData); err != nil {
+			return fmt.Errorf("server rewrite rule %d: invalid caData: %w", i, err)
+		}
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("server rewrite rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// RewriteServer applies the first matching rule to the server URL of a cluster.
+// It returns the resulting server and the CA bundle of the applied rule, if any.
+func RewriteServer(namespace, clusterName string, clusterLabels map[string]string, server string) (string, string) {
+	for _, rule := range ServerRewriteRules {
+		if !rule.Matches(namespace, clusterLabels) {
+			continue
+		}
+		re, err := regexp.Compile(rule.Match)
+		if err != nil || !re.MatchString(server) {
+			continue
+		}
+		replace := strings.NewReplacer("{{namespace}}", namespace, "{{cluster}}", clusterName).Replace(rule.Replace)
+		return re.ReplaceAllString(server, replace), rule.CaData
+	}
+	return server, ""
+}
+
+// MergeCaData appends a base64 encoded CA bundle to base64 encoded CA data.
+func MergeCaData(caData, bundle string) (string, error) {
+	if bundle == "" {
+		return caData, nil
+	}
+	if caData == "" {
+		return bundle, nil
+	}
+	ca, err := b64.StdEncoding.DecodeString(caData)
+	if err != nil {
+		return "", err
+	}
+	extra, err := b64.StdEncoding.DecodeString(bundle)
+	if err != nil {
+		return "", err
+	}
+	if len(ca) > 0 && !strings.HasSuffix(string(ca), "\n") {
+		ca = append(ca, '\n')
+	}
+	if strings.Contains(string(ca), string(extra)) {
+		return caData, nil
+	}
+	return b64.StdEncoding.EncodeToString(append(ca, extra...)), nil
+}
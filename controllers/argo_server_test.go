@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	b64 "encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRewriteServer(t *testing.T) {
+	oldRules := ServerRewriteRules
+	defer func() { ServerRewriteRules = oldRules }()
+
+	ServerRewriteRules = []ServerRewriteRule{
+		{ClusterSelector: ClusterSelector{Namespaces: []string{"tenant-a"}},
+			Match: `^https://([^.]+)\.public\.example\.com`, Replace: "https://$1.private.example.com", CaData: "Y2E="},
+		{ClusterSelector: ClusterSelector{LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tunnel": "true"}}},
+			Match: `.*`, Replace: "https://{{cluster}}.{{namespace}}.tunnel.svc:6443"},
+	}
+
+	tests := []struct {
+		testName       string
+		testNamespace  string
+		testLabels     map[string]string
+		testServer     string
+		testExpected   string
+		testExpectedCA string
+	}{
+		{"test cluster without rule", "test", nil, "https://api.public.example.com:6443", "https://api.public.example.com:6443", ""},
+		{"test cluster with regex rule", "tenant-a", nil, "https://api.public.example.com:6443", "https://api.private.example.com:6443", "Y2E="},
+		{"test cluster with non-matching regex", "tenant-a", nil, "https://10.0.0.1:6443", "https://10.0.0.1:6443", ""},
+		{"test cluster with template rule", "tenant-b", map[string]string{"tunnel": "true"}, "https://10.0.0.1:6443", "https://test.tenant-b.tunnel.svc:6443", ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			server, ca := RewriteServer(tt.testNamespace, "test", tt.testLabels, tt.testServer)
+			assert.Equal(t, tt.testExpected, server)
+			assert.Equal(t, tt.testExpectedCA, ca)
+		})
+	}
+}
+
+func TestMergeCaData(t *testing.T) {
+	t.Parallel()
+	enc := func(s string) string { return b64.StdEncoding.EncodeToString([]byte(s)) }
+
+	merged, err := MergeCaData(enc("ca-a"), enc("ca-b\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, enc("ca-a\nca-b\n"), merged)
+
+	merged, err = MergeCaData(merged, enc("ca-b\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, enc("ca-a\nca-b\n"), merged)
+
+	merged, err = MergeCaData("", enc("ca-b"))
+	assert.Nil(t, err)
+	assert.Equal(t, enc("ca-b"), merged)
+
+	_, err = MergeCaData("non-valid", enc("ca-b"))
+	assert.NotNil(t, err)
+}
+
+func TestValidateServerRewriteRules(t *testing.T) {
+	t.Parallel()
+	assert.Nil(t, ValidateServerRewriteRules([]ServerRewriteRule{{Match: ".*", Replace: "https://api"}}))
+	assert.NotNil(t, ValidateServerRewriteRules([]ServerRewriteRule{{Match: ".*"}}))
+	assert.NotNil(t, ValidateServerRewriteRules([]ServerRewriteRule{{Match: "(", Replace: "https://api"}}))
+	assert.NotNil(t, ValidateServerRewriteRules([]ServerRewriteRule{{Match: ".*", Replace: "https://api", CaData: "non-valid"}}))
+}
@@ -0,0 +1,261 @@
+package controllers
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// CABundleAnnotation on a CAPI Cluster, or the object owning a cluster of another
+	// source adapter, references extra trusted CAs for the cluster, as
+	// <configmap|secret>/<name>[/<key>] in the namespace of the cluster. It is only honoured
+	// when EnableCABundleAnnotation is set.
+	CABundleAnnotation = "capi-to-argocd/ca-bundle"
+
+	// CABundleKindConfigMap references a ConfigMap holding a CA bundle.
+	CABundleKindConfigMap = "ConfigMap"
+	// CABundleKindSecret references a Secret holding a CA bundle.
+	CABundleKindSecret = "Secret"
+
+	defaultCABundleKey = "ca.crt"
+)
+
+var (
+	// GlobalCABundle references extra trusted CAs added to every cluster.
+	GlobalCABundle *CABundleRef
+
+	// EnableCABundleAnnotation enables CA bundles referenced by the CABundleAnnotation.
+	EnableCABundleAnnotation bool
+)
+
+// CABundleRef references a key of a ConfigMap or Secret holding PEM encoded CAs.
+type CABundleRef struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Key       string `json:"key,omitempty"`
+}
+
+// GetKey returns the referenced key, defaulting to ca.crt.
+func (ref *CABundleRef) GetKey() string {
+	if ref.Key == "" {
+		return defaultCABundleKey
+	}
+	return ref.Key
+}
+
+// Refers reports whether the reference points to given object.
+func (ref *CABundleRef) Refers(kind string, key types.NamespacedName) bool {
+	return ref.Kind == kind && ref.Name == key.Name && ref.Namespace == key.Namespace
+}
+
+// ValidateCABundleRef validates that the reference points to a ConfigMap or Secret.
+func ValidateCABundleRef(ref *CABundleRef) error {
+	if ref == nil {
+		return nil
+	}
+	if ref.Kind != CABundleKindConfigMap && ref.Kind != CABundleKindSecret {
+		return fmt.Errorf("unknown kind %q", ref.Kind)
+	}
+	if ref.Name == "" || ref.Namespace == "" {
+		return errors.New("missing name or namespace")
+	}
+	return nil
+}
+
+// ParseCABundleAnnotation parses a CA bundle annotation of a Cluster living in given namespace.
+func ParseCABundleAnnotation(v, namespace string) (*CABundleRef, error) {
+	parts := strings.Split(v, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[1] == "" {
+		return nil, fmt.Errorf("invalid CA bundle reference %q", v)
+	}
+	ref := &CABundleRef{Name: parts[1], Namespace: namespace}
+	switch strings.ToLower(parts[0]) {
+	case "configmap":
+		ref.Kind = CABundleKindConfigMap
+	case "secret":
+		ref.Kind = CABundleKindSecret
+	default:
+		return nil, fmt.Errorf("invalid CA bundle kind %q", parts[0])
+	}
+	if len(parts) == 3 {
+		ref.Key = parts[2]
+	}
+	return ref, nil
+}
+
+// FetchCABundle reads the PEM encoded CAs of a reference and checks they hold certificates.
+func FetchCABundle(ctx context.Context, c client.Reader, ref *CABundleRef) ([]byte, error) {
+	key := types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}
+	var data []byte
+	switch ref.Kind {
+	case CABundleKindConfigMap:
+		var cm corev1.ConfigMap
+		if err := c.Get(ctx, key, &cm); err != nil {
+			return nil, err
+		}
+		if v, ok := cm.Data[ref.GetKey()]; ok {
+			data = []byte(v)
+		} else {
+			data = cm.BinaryData[ref.GetKey()]
+		}
+	case CABundleKindSecret:
+		var s corev1.Secret
+		if err := c.Get(ctx, key, &s); err != nil {
+			return nil, err
+		}
+		data = s.Data[ref.GetKey()]
+	default:
+		return nil, fmt.Errorf("unknown kind %q", ref.Kind)
+	}
+
+	if block, _ := pem.Decode(data); block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s %s key %s holds no PEM certificate", ref.Kind, key, ref.GetKey())
+	}
+	return data, nil
+}
+
+// CABundleRefs returns the CA bundles trusted for a cluster. Invalid annotations are ignored.
+func CABundleRefs(c *CapiCluster) []*CABundleRef {
+	var refs []*CABundleRef
+	if GlobalCABundle != nil {
+		refs = append(refs, GlobalCABundle)
+	}
+	if ref := annotationCABundleRef(c); ref != nil {
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// annotationCABundleRef returns the CA bundle referenced by the CABundleAnnotation of a
+// cluster, or nil when disabled, unset or invalid.
+func annotationCABundleRef(c *CapiCluster) *CABundleRef {
+	v, ok := c.Annotations[CABundleAnnotation]
+	if !EnableCABundleAnnotation || !ok {
+		return nil
+	}
+	ref, err := ParseCABundleAnnotation(v, c.Namespace)
+	if err != nil {
+		return nil
+	}
+	return ref
+}
+
+// caBundleTracker tracks the CA bundles referenced by the annotation of registered
+// clusters, so changes of a bundle are mapped to its users without listing clusters.
+type caBundleTracker struct {
+	mu   sync.Mutex
+	refs map[types.NamespacedName]*CABundleRef
+}
+
+// Track records the CA bundle referenced by the annotation of a cluster for the
+// reconcile request of given key.
+func (t *caBundleTracker) Track(key types.NamespacedName, c *CapiCluster) {
+	ref := annotationCABundleRef(c)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ref == nil {
+		delete(t.refs, key)
+		return
+	}
+	if t.refs == nil {
+		t.refs = map[types.NamespacedName]*CABundleRef{}
+	}
+	t.refs[key] = ref
+}
+
+// Forget drops the CA bundle tracked for given key.
+func (t *caBundleTracker) Forget(key types.NamespacedName) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.refs, key)
+}
+
+// Users returns the requests whose cluster references a CA bundle by annotation.
+func (t *caBundleTracker) Users(kind string, bundle types.NamespacedName) []reconcile.Request {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var requests []reconcile.Request
+	for key, ref := range t.refs {
+		if ref.Refers(kind, bundle) {
+			requests = append(requests, reconcile.Request{NamespacedName: key})
+		}
+	}
+	return requests
+}
+
+// Predicate filters ConfigMaps or Secrets down to the global CA bundle and the tracked ones.
+func (t *caBundleTracker) Predicate(kind string) predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		key := client.ObjectKeyFromObject(obj)
+		if GlobalCABundle != nil && GlobalCABundle.Refers(kind, key) {
+			return true
+		}
+		return len(t.Users(kind, key)) > 0
+	})
+}
+
+// CABundleWatched reports whether ConfigMaps or Secrets of given kind may hold CA bundles.
+func CABundleWatched(kind string) bool {
+	return EnableCABundleAnnotation || (GlobalCABundle != nil && GlobalCABundle.Kind == kind)
+}
+
+// ResolveCABundles returns the base64 encoded extra CAs trusted for a cluster.
+func (r *Capi2Argo) ResolveCABundles(ctx context.Context, c *CapiCluster) (string, error) {
+	var bundle []byte
+	for _, ref := range CABundleRefs(c) {
+		data, err := FetchCABundle(ctx, r, ref)
+		if err != nil {
+			return "", err
+		}
+		if len(bundle) > 0 && !strings.HasSuffix(string(bundle), "\n") {
+			bundle = append(bundle, '\n')
+		}
+		bundle = append(bundle, data...)
+	}
+	if len(bundle) == 0 {
+		return "", nil
+	}
+	return b64.StdEncoding.EncodeToString(bundle), nil
+}
+
+// CABundleRequests maps a changed ConfigMap or Secret to the kubeconfig secrets of
+// the clusters trusting it. Every kubeconfig secret trusts the global bundle, while
+// bundles referenced by annotation are tracked once their clusters are registered.
+func (r *Capi2Argo) CABundleRequests(kind string) func(context.Context, client.Object) []reconcile.Request {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		key := client.ObjectKeyFromObject(obj)
+		if GlobalCABundle == nil || !GlobalCABundle.Refers(kind, key) {
+			return r.caBundles.Users(kind, key)
+		}
+
+		secretList := &corev1.SecretList{}
+		if err := r.List(ctx, secretList); err != nil {
+			r.Log.Error(err, "Failed to list kubeconfig secrets for CA bundle", "caBundle", key, "kind", kind)
+			return nil
+		}
+		var requests []reconcile.Request
+		for i := range secretList.Items {
+			s := &secretList.Items[i]
+			if IsSinkOwned(s.Labels) {
+				continue
+			}
+			if _, err := SourceAdapterForSecret(s, SourceAdaptersForName(s.Name)); err != nil {
+				continue
+			}
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(s)})
+		}
+		return requests
+	}
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestParseCABundleAnnotation(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		testName          string
+		testValue         string
+		testExpectedError bool
+		testExpectedRef   *CABundleRef
+	}{
+		{"test configmap reference", "configmap/corp-ca", false,
+			&CABundleRef{Kind: CABundleKindConfigMap, Name: "corp-ca", Namespace: "test"}},
+		{"test secret reference with key", "Secret/corp-ca/bundle.pem", false,
+			&CABundleRef{Kind: CABundleKindSecret, Name: "corp-ca", Namespace: "test", Key: "bundle.pem"}},
+		{"test unknown kind", "pod/corp-ca", true, nil},
+		{"test missing name", "configmap/", true, nil},
+		{"test malformed reference", "corp-ca", true, nil},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			ref, err := ParseCABundleAnnotation(tt.testValue, "test")
+			if tt.testExpectedError {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.testExpectedRef, ref)
+		})
+	}
+}
+
+func TestFetchCABundle(t *testing.T) {
+	t.Parallel()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("tester")})
+	key := client.ObjectKey{Name: "corp-ca", Namespace: "test"}
+	r := &mockClient{
+		configMaps: map[client.ObjectKey]*corev1.ConfigMap{
+			key: {ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}, Data: map[string]string{"ca.crt": string(ca), "other": "tester"}},
+		},
+		secrets: map[client.ObjectKey]*corev1.Secret{
+			key: {ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}, Data: map[string][]byte{"bundle.pem": ca}},
+		},
+	}
+
+	tests := []struct {
+		testName          string
+		testRef           *CABundleRef
+		testExpectedError bool
+	}{
+		{"test configmap with default key", &CABundleRef{Kind: CABundleKindConfigMap, Name: "corp-ca", Namespace: "test"}, false},
+		{"test secret with key", &CABundleRef{Kind: CABundleKindSecret, Name: "corp-ca", Namespace: "test", Key: "bundle.pem"}, false},
+		{"test key without certificate", &CABundleRef{Kind: CABundleKindConfigMap, Name: "corp-ca", Namespace: "test", Key: "other"}, true},
+		{"test missing object", &CABundleRef{Kind: CABundleKindSecret, Name: "missing", Namespace: "test"}, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			data, err := FetchCABundle(context.Background(), r, tt.testRef)
+			if tt.testExpectedError {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, ca, data)
+		})
+	}
+}
+
+func TestConvertToSecretExtraCaData(t *testing.T) {
+	t.Parallel()
+	a := MockArgoCluster(true)
+	a.ClusterExtraCaData = b64.StdEncoding.EncodeToString([]byte("extra"))
+	s, err := a.ConvertToSecret()
+	assert.Nil(t, err)
+	assert.Contains(t, string(s.Data["config"]), b64.StdEncoding.EncodeToString([]byte("tester\nextra")))
+	// The ArgoCluster itself keeps the plain cluster CA.
+	assert.Equal(t, b64.StdEncoding.EncodeToString([]byte("tester")), a.ClusterConfig.TLSClientConfig.CaData)
+}
+
+func TestValidateCABundleRef(t *testing.T) {
+	t.Parallel()
+	assert.Nil(t, ValidateCABundleRef(nil))
+	assert.Nil(t, ValidateCABundleRef(&CABundleRef{Kind: CABundleKindSecret, Name: "corp-ca", Namespace: "test"}))
+	assert.NotNil(t, ValidateCABundleRef(&CABundleRef{Kind: "Pod", Name: "corp-ca", Namespace: "test"}))
+	assert.NotNil(t, ValidateCABundleRef(&CABundleRef{Kind: CABundleKindSecret, Name: "corp-ca"}))
+}
+
+func TestCABundleTracking(t *testing.T) {
+	oldEnabled, oldGlobal := EnableCABundleAnnotation, GlobalCABundle
+	defer func() { EnableCABundleAnnotation, GlobalCABundle = oldEnabled, oldGlobal }()
+	EnableCABundleAnnotation, GlobalCABundle = true, nil
+
+	c := NewCapiCluster("test", "test")
+	c.Annotations = map[string]string{CABundleAnnotation: "configmap/corp-ca"}
+	secret := client.ObjectKey{Name: "test-kubeconfig", Namespace: "test"}
+	bundle := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "corp-ca", Namespace: "test"}}
+	other := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "test"}}
+
+	r := &Capi2Argo{}
+	r.caBundles.Track(secret, c)
+	p := r.caBundles.Predicate(CABundleKindConfigMap)
+	assert.True(t, p.Generic(event.GenericEvent{Object: bundle}))
+	assert.False(t, p.Generic(event.GenericEvent{Object: other}))
+	assert.False(t, r.caBundles.Predicate(CABundleKindSecret).Generic(event.GenericEvent{Object: bundle}))
+	assert.Equal(t, []reconcile.Request{{NamespacedName: secret}}, r.CABundleRequests(CABundleKindConfigMap)(context.Background(), bundle))
+
+	// Dropping the annotation, or the secret, stops tracking the bundle.
+	r.caBundles.Track(secret, NewCapiCluster("test", "test"))
+	assert.False(t, p.Generic(event.GenericEvent{Object: bundle}))
+	r.caBundles.Track(secret, c)
+	r.caBundles.Forget(secret)
+	assert.Empty(t, r.CABundleRequests(CABundleKindConfigMap)(context.Background(), bundle))
+
+	// The global bundle is always let through.
+	GlobalCABundle = &CABundleRef{Kind: CABundleKindConfigMap, Name: "other", Namespace: "test"}
+	assert.True(t, p.Generic(event.GenericEvent{Object: other}))
+}
+
+func TestCABundleRefsAnnotationDisabled(t *testing.T) {
+	oldEnabled, oldGlobal := EnableCABundleAnnotation, GlobalCABundle
+	defer func() { EnableCABundleAnnotation, GlobalCABundle = oldEnabled, oldGlobal }()
+	EnableCABundleAnnotation, GlobalCABundle = false, nil
+
+	c := NewCapiCluster("test", "test")
+	c.Annotations = map[string]string{CABundleAnnotation: "configmap/corp-ca"}
+	assert.Empty(t, CABundleRefs(c))
+	EnableCABundleAnnotation = true
+	assert.Equal(t, []*CABundleRef{{Kind: CABundleKindConfigMap, Name: "corp-ca", Namespace: "test"}}, CABundleRefs(c))
+}
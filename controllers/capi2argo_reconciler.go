@@ -1,19 +1,28 @@
 package controllers
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
 	goErr "errors"
+	"fmt"
 	"os"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"strings"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
 var (
@@ -23,6 +32,9 @@ var (
 	// EnableNamespacedNames represents a mode where the cluster name is always
 	// prepended by the cluster namespace in all generated secrets
 	EnableNamespacedNames bool
+
+	// configErrors holds errors found while parsing configuration on init.
+	configErrors []error
 )
 
 func init() {
@@ -35,6 +47,158 @@ func init() {
 
 	EnableGarbageCollection, _ = strconv.ParseBool(os.Getenv("ENABLE_GARBAGE_COLLECTION"))
 	EnableNamespacedNames, _ = strconv.ParseBool(os.Getenv("ENABLE_NAMESPACED_NAMES"))
+
+	NameMigrationMode = os.Getenv("NAME_MIGRATION_MODE")
+	EnableMigrationAppRewrite, _ = strconv.ParseBool(os.Getenv("NAME_MIGRATION_REWRITE_APPS"))
+	parseJSONEnv("NAME_MIGRATION_APP_NAMESPACES", &MigrationAppNamespaces)
+
+	parseJSONEnv("ARGOCD_ROUTING_RULES", &RoutingRules)
+	parseJSONEnv("ARGOCD_HUBS", &ArgoHubs)
+	ArgoDefaultHub = os.Getenv("ARGOCD_DEFAULT_HUB")
+
+	parseJSONEnv("ARGOCD_PROJECT_RULES", &ProjectRules)
+	EnableProjectManagement, _ = strconv.ParseBool(os.Getenv("ENABLE_PROJECT_MANAGEMENT"))
+	parseJSONEnv("ARGOCD_PROJECT_TEMPLATE", &ProjectTemplate)
+
+	parseJSONEnv("ARGOCD_SCOPE_RULES", &ScopeRules)
+
+	if v := os.Getenv("ARGOCD_SHARDS"); v != "" {
+		var err error
+		if ArgoShards, err = strconv.Atoi(v); err != nil {
+			configErrors = append(configErrors, fmt.Errorf("ARGOCD_SHARDS: %w", err))
+		}
+	}
+	ArgoShardStrategy = os.Getenv("ARGOCD_SHARD_STRATEGY")
+	if ArgoShardStrategy == "" {
+		ArgoShardStrategy = ShardStrategyLeastLoaded
+	}
+	ArgoShardWeightLabel = os.Getenv("ARGOCD_SHARD_WEIGHT_LABEL")
+
+	AllowInsecureClusters, _ = strconv.ParseBool(os.Getenv("ALLOW_INSECURE_CLUSTERS"))
+
+	parseJSONEnv("ARGOCD_SERVER_REWRITE_RULES", &ServerRewriteRules)
+	parseJSONEnv("ARGOCD_CA_BUNDLE", &GlobalCABundle)
+	EnableCABundleAnnotation, _ = strconv.ParseBool(os.Getenv("ENABLE_CA_BUNDLE_ANNOTATION"))
+
+	EnableHiveSource, _ = strconv.ParseBool(os.Getenv("ENABLE_HIVE_SOURCE"))
+	parseJSONEnv("SOURCE_ADAPTERS", &GenericSourceAdapters)
+	if err := SetupSourceAdapters(GenericSourceAdapters); err != nil {
+		configErrors = append(configErrors, fmt.Errorf("SOURCE_ADAPTERS: %w", err))
+	}
+
+	EnableGardenerSource, _ = strconv.ParseBool(os.Getenv("ENABLE_GARDENER_SOURCE"))
+	if v := os.Getenv("GARDENER_KUBECONFIG_EXPIRATION"); v != "" {
+		var err error
+		if GardenerKubeConfigExpiration, err = time.ParseDuration(v); err != nil {
+			configErrors = append(configErrors, fmt.Errorf("GARDENER_KUBECONFIG_EXPIRATION: %w", err))
+		}
+	}
+
+	parseJSONEnv("ARGOCD_BOOTSTRAP", &ArgoBootstrap)
+
+	parseJSONEnv("ARGOCD_TOPOLOGY_RULES", &TopologyRules)
+	parseJSONEnv("ARGOCD_NAMESPACE_LABELS", &NamespaceLabels)
+	parseJSONEnv("CLUSTER_FACTS", &ClusterFactsDiscovery)
+	parseJSONEnv("HEALTH_CHECK", &HealthCheck)
+	parseJSONEnv("CREDENTIAL_ROTATION", &CredentialRotation)
+	parseJSONEnv("MAINTENANCE_MODE", &Maintenance)
+	parseJSONEnv("APPROVAL_RULES", &ApprovalRules)
+	parseJSONEnv("TRANSFORM_WEBHOOK", &TransformWebhook)
+
+	parseJSONEnv("APPSET_PLUGIN_GENERATOR", &PluginGenerator)
+
+	parseJSONEnv("FLUX_SINK", &FluxSink)
+	parseJSONEnv("SINKS", &SinkConfigs)
+	if err := SetupSinks(SinkConfigs); err != nil {
+		configErrors = append(configErrors, fmt.Errorf("SINKS: %w", err))
+	}
+
+	KubeConfigVariant = os.Getenv("KUBECONFIG_VARIANT")
+	if KubeConfigVariant == "" {
+		KubeConfigVariant = KubeConfigVariantAdmin
+	}
+}
+
+// parseJSONEnv unmarshals a JSON encoded env variable into v, if set.
+func parseJSONEnv(key string, v interface{}) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return
+	}
+	if err := json.Unmarshal([]byte(raw), v); err != nil {
+		configErrors = append(configErrors, fmt.Errorf("%s: %w", key, err))
+	}
+}
+
+// ValidateConfig returns any error found on the environment provided configuration.
+func ValidateConfig() error {
+	errs := append([]error{}, configErrors...)
+	switch NameMigrationMode {
+	case NameMigrationDisabled, NameMigrationDryRun, NameMigrationApply:
+	default:
+		errs = append(errs, fmt.Errorf("NAME_MIGRATION_MODE: unknown mode %q", NameMigrationMode))
+	}
+	if err := ValidateArgoHubs(ArgoHubs, ArgoDefaultHub); err != nil {
+		errs = append(errs, fmt.Errorf("ARGOCD_HUBS: %w", err))
+	}
+	if err := ValidateRoutingRules(RoutingRules, ArgoHubs); err != nil {
+		errs = append(errs, fmt.Errorf("ARGOCD_ROUTING_RULES: %w", err))
+	}
+	if err := ValidateProjectRules(ProjectRules); err != nil {
+		errs = append(errs, fmt.Errorf("ARGOCD_PROJECT_RULES: %w", err))
+	}
+	if err := ValidateProjectTemplate(ProjectTemplate); err != nil {
+		errs = append(errs, fmt.Errorf("ARGOCD_PROJECT_TEMPLATE: %w", err))
+	}
+	if err := ValidateScopeRules(ScopeRules); err != nil {
+		errs = append(errs, fmt.Errorf("ARGOCD_SCOPE_RULES: %w", err))
+	}
+	if err := ValidateServerRewriteRules(ServerRewriteRules); err != nil {
+		errs = append(errs, fmt.Errorf("ARGOCD_SERVER_REWRITE_RULES: %w", err))
+	}
+	if err := ValidateCABundleRef(GlobalCABundle); err != nil {
+		errs = append(errs, fmt.Errorf("ARGOCD_CA_BUNDLE: %w", err))
+	}
+	if err := ValidateKubeConfigVariant(KubeConfigVariant); err != nil {
+		errs = append(errs, fmt.Errorf("KUBECONFIG_VARIANT: %w", err))
+	}
+	if err := ValidateGardenerKubeConfigExpiration(GardenerKubeConfigExpiration); err != nil {
+		errs = append(errs, fmt.Errorf("GARDENER_KUBECONFIG_EXPIRATION: %w", err))
+	}
+	if err := ValidateBootstrap(ArgoBootstrap); err != nil {
+		errs = append(errs, fmt.Errorf("ARGOCD_BOOTSTRAP: %w", err))
+	}
+	if err := ValidateTopologyRules(TopologyRules); err != nil {
+		errs = append(errs, fmt.Errorf("ARGOCD_TOPOLOGY_RULES: %w", err))
+	}
+	if err := ValidateNamespaceLabels(NamespaceLabels); err != nil {
+		errs = append(errs, fmt.Errorf("ARGOCD_NAMESPACE_LABELS: %w", err))
+	}
+	if err := ValidateClusterFacts(ClusterFactsDiscovery); err != nil {
+		errs = append(errs, fmt.Errorf("CLUSTER_FACTS: %w", err))
+	}
+	if err := ValidateHealthCheck(HealthCheck); err != nil {
+		errs = append(errs, fmt.Errorf("HEALTH_CHECK: %w", err))
+	}
+	if err := ValidateCredentialRotation(CredentialRotation); err != nil {
+		errs = append(errs, fmt.Errorf("CREDENTIAL_ROTATION: %w", err))
+	}
+	if err := ValidateMaintenance(Maintenance); err != nil {
+		errs = append(errs, fmt.Errorf("MAINTENANCE_MODE: %w", err))
+	}
+	if err := ValidateApprovalRules(ApprovalRules); err != nil {
+		errs = append(errs, fmt.Errorf("APPROVAL_RULES: %w", err))
+	}
+	if err := ValidateTransformWebhook(TransformWebhook); err != nil {
+		errs = append(errs, fmt.Errorf("TRANSFORM_WEBHOOK: %w", err))
+	}
+	if err := ValidatePluginGenerator(PluginGenerator); err != nil {
+		errs = append(errs, fmt.Errorf("APPSET_PLUGIN_GENERATOR: %w", err))
+	}
+	if err := ValidateSharding(); err != nil {
+		errs = append(errs, fmt.Errorf("ARGOCD_SHARDS: %w", err))
+	}
+	return goErr.Join(errs...)
 }
 
 // Capi2Argo reconciles a Secret object
@@ -42,10 +206,34 @@ type Capi2Argo struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+	// Hubs provides clients for remote Argo hubs. When nil, only the local cluster is used.
+	Hubs *ArgoHubClients
+	// Recorder emits events on kubeconfig secrets, when set.
+	Recorder record.EventRecorder
+
+	factsMu sync.Mutex
+	// facts caches the facts discovered from workload clusters, by cluster.
+	facts map[types.NamespacedName]*clusterFactsEntry
+
+	// caBundles tracks the CA bundles referenced by annotation, by kubeconfig secret.
+	caBundles caBundleTracker
 }
 
 // +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=secrets/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=argoproj.io,resources=applications;applicationsets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=argoproj.io,resources=appprojects,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters;machinedeployments,verbs=get;list;watch
+// +kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=*,verbs=get;list;watch
+// +kubebuilder:rbac:groups=hive.openshift.io,resources=clusterdeployments,verbs=get;list;watch
+// +kubebuilder:rbac:groups=kustomize.toolkit.fluxcd.io,resources=kustomizations,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=helm.toolkit.fluxcd.io,resources=helmreleases,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=lib.projectsveltos.io,resources=sveltosclusters,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=fleet.cattle.io,resources=clusters,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=cluster.karmada.io,resources=clusters,verbs=get;list;watch;create;update;delete
 
 // Reconcile holds all the logic for syncing CAPI to Argo Clusters.
 func (r *Capi2Argo) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -53,8 +241,9 @@ func (r *Capi2Argo) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resul
 
 	// TODO: Check if secret is on allowed Namespaces.
 
-	// Validate Secret.Metadata.Name complies with CAPI pattern: <clusterName>-kubeconfig
-	if !ValidateCapiNaming(req.NamespacedName) {
+	// Validate Secret.Metadata.Name complies with a source adapter pattern, eg. <clusterName>-kubeconfig
+	adapters := SourceAdaptersForName(req.NamespacedName.Name)
+	if len(adapters) == 0 {
 		return ctrl.Result{}, nil
 	}
 
@@ -66,67 +255,311 @@ func (r *Capi2Argo) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resul
 		if client.IgnoreNotFound(err) != nil {
 			return ctrl.Result{}, err
 		}
+		r.caBundles.Forget(req.NamespacedName)
 
-		// If secret is deleted and GC is enabled, mark ArgoSecret for deletion.
-		if EnableGarbageCollection {
-			labelSelector := map[string]string{
-				"capi-to-argocd/cluster-secret-name": req.NamespacedName.Name,
-				"capi-to-argocd/cluster-namespace":   req.NamespacedName.Namespace,
-			}
-			listOption := client.MatchingLabels(labelSelector)
-			secretList := &corev1.SecretList{}
-			err = r.List(context.Background(), secretList, listOption)
-			if err != nil {
-				log.Error(err, "Failed to list Cluster Secrets")
+		// Let the remaining kubeconfig secrets of the cluster take over, if any. Remaining
+		// secrets held back still let the objects of the deleted one be collected.
+		var result ctrl.Result
+		for _, adapter := range adapters {
+			cluster, _ := adapter.ParseSecretName(req.NamespacedName.Name)
+			r.ForgetClusterFacts(types.NamespacedName{Name: cluster, Namespace: req.NamespacedName.Namespace})
+			err := r.reconcileRemainingSecrets(ctx, log, adapter, req.NamespacedName)
+			if res, ok := heldBackResult(err); ok {
+				result = res
+			} else if err != nil {
 				return ctrl.Result{}, err
 			}
-			if err := r.Delete(ctx, &secretList.Items[0]); err != nil {
-				log.Error(err, "Failed to delete ArgoSecret")
+		}
+
+		// If secret is deleted and GC is enabled, mark ArgoSecrets and other sink objects for deletion.
+		// Objects taken over by another secret no longer carry the deleted secret labels.
+		if EnableGarbageCollection {
+			if err := r.GarbageCollectSinks(ctx, log, req.NamespacedName); err != nil {
 				return ctrl.Result{}, err
 			}
-			log.Info("Deleted successfully of ArgoSecret")
-			return ctrl.Result{}, nil
 		}
+		return result, nil
+	}
 
-		return ctrl.Result{}, client.IgnoreNotFound(err)
+	// Ignore secrets written by sinks, such as Sveltos or Fleet kubeconfig secrets.
+	if IsSinkOwned(capiSecret.Labels) {
+		return ctrl.Result{}, nil
 	}
 	log.Info("Fetched CapiSecret")
 
-	// Validate CapiSecret.type is matching CAPI convention.
-	// if capiSecret.Type != "cluster.x-k8s.io/secret" {
-	err = ValidateCapiSecret(&capiSecret)
+	// Validate CapiSecret is matching the source adapter convention (eg. CAPI type and key).
+	adapter, err := SourceAdapterForSecret(&capiSecret, adapters)
 	if err != nil {
-		log.Info("Ignoring secret as it's missing proper CAPI type", "type", capiSecret.Type)
+		log.Info("Ignoring secret as it's not matching any source adapter", "type", capiSecret.Type)
 		return ctrl.Result{}, err
 	}
 
+	err = r.reconcileCapiSecret(ctx, log, adapter, &capiSecret)
+	if res, ok := heldBackResult(err); ok {
+		return res, nil
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: RequeueInterval()}, nil
+}
+
+// heldBackResult returns the result of reconciling a cluster held back, and whether
+// the error reports one.
+func heldBackResult(err error) (ctrl.Result, bool) {
+	if goErr.Is(err, ErrClusterUnhealthy) {
+		return ctrl.Result{RequeueAfter: HealthCheck.GetRetryAfter()}, true
+	}
+	// Rejected clusters are submitted again later, as the webhook may change its mind.
+	if goErr.Is(err, ErrClusterRejected) {
+		return ctrl.Result{RequeueAfter: TransformWebhook.GetRetryAfter()}, true
+	}
+	// Approvals trigger another reconcile.
+	if goErr.Is(err, ErrApprovalPending) {
+		return ctrl.Result{}, true
+	}
+	return ctrl.Result{}, false
+}
+
+// RequeueInterval returns how often registered clusters are reconciled again, to refresh
+// the facts of the workload cluster or check rotated credentials. Zero disables requeues.
+func RequeueInterval() time.Duration {
+	var interval time.Duration
+	for _, d := range []time.Duration{ClusterFactsDiscovery.GetInterval(), CredentialRotation.GetCheckInterval()} {
+		if d > 0 && (interval == 0 || d < interval) {
+			interval = d
+		}
+	}
+	return interval
+}
+
+// reconcileRemainingSecrets reconciles the first remaining kubeconfig secret of the
+// cluster a deleted secret belonged to.
+func (r *Capi2Argo) reconcileRemainingSecrets(ctx context.Context, log logr.Logger, adapter SourceAdapter, deleted types.NamespacedName) error {
+	cluster, _ := adapter.ParseSecretName(deleted.Name)
+	metadata, err := adapter.FetchMetadata(ctx, r, deleted.Namespace, cluster)
+	if goErr.Is(err, ErrClusterNotReady) {
+		return nil
+	}
+	if err != nil {
+		log.Error(err, "Failed to fetch cluster metadata")
+		return err
+	}
+	for _, name := range adapter.ClusterSecrets(cluster, metadata.Annotations) {
+		if name == deleted.Name {
+			continue
+		}
+		var remaining corev1.Secret
+		err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: deleted.Namespace}, &remaining)
+		if client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		if err != nil || adapter.Validate(&remaining) != nil || adapter.ClusterName(&remaining) != cluster {
+			continue
+		}
+		log.Info("CapiSecret is deleted, sourcing remaining kubeconfig secret", "source", name)
+		return r.reconcileCapiSecret(ctx, log, adapter, &remaining)
+	}
+	return nil
+}
+
+// reconcileCapiSecret registers the cluster of a valid kubeconfig secret into Argo. The
+// preferred kubeconfig secret of the cluster is sourced when it exists, otherwise
+// the given one is used.
+func (r *Capi2Argo) reconcileCapiSecret(ctx context.Context, log logr.Logger, adapter SourceAdapter, capiSecret *corev1.Secret) error {
 	// Construct CapiCluster from CapiSecret.
-	nn := strings.TrimSuffix(req.NamespacedName.Name, "-kubeconfig")
-	ns := req.NamespacedName.Namespace
+	nn := adapter.ClusterName(capiSecret)
+	ns := capiSecret.Namespace
 	capiCluster := NewCapiCluster(nn, ns)
-	err = capiCluster.Unmarshal(&capiSecret)
+
+	// Enrich CapiCluster with metadata of the object owning the cluster.
+	metadata, err := adapter.FetchMetadata(ctx, r, ns, nn)
+	if goErr.Is(err, ErrClusterNotReady) {
+		log.Info("Cluster is not ready to be registered yet, skipping..")
+		return nil
+	}
 	if err != nil {
+		log.Error(err, "Failed to fetch cluster metadata")
+		return err
+	}
+	capiCluster.Labels, capiCluster.Annotations = metadata.Labels, metadata.Annotations
+
+	// Switch to the preferred kubeconfig secret, eg. the selected CAPI kubeconfig variant.
+	for _, name := range adapter.ClusterSecrets(nn, capiCluster.Annotations) {
+		if name == capiSecret.Name {
+			break
+		}
+		var preferred corev1.Secret
+		err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: ns}, &preferred)
+		if client.IgnoreNotFound(err) != nil {
+			log.Error(err, "Failed to fetch preferred kubeconfig secret", "source", name)
+			return err
+		}
+		if err == nil && adapter.Validate(&preferred) == nil && adapter.ClusterName(&preferred) == nn {
+			log.Info("Sourcing preferred kubeconfig secret", "source", name)
+			capiSecret = &preferred
+			break
+		}
+	}
+
+	if err := capiCluster.UnmarshalKubeConfig(adapter.KubeConfig(capiSecret)); err != nil {
 		log.Error(err, "Failed to unmarshal CapiCluster")
-		return ctrl.Result{}, err
+		return err
+	}
+
+	return r.registerCluster(ctx, log, capiCluster, client.ObjectKeyFromObject(capiSecret), capiSecret, adapter.Name(), metadata)
+}
+
+// registerCluster registers a CapiCluster sourced from given kubeconfig secret, or the
+// key standing for it, into every sink. Approval states and events are recorded on the
+// target, being the kubeconfig secret or the object the cluster was discovered from.
+func (r *Capi2Argo) registerCluster(ctx context.Context, log logr.Logger, capiCluster *CapiCluster, sourceKey client.ObjectKey, target client.Object, source string, metadata ClusterMetadata) error {
+	// Hold back clusters requiring approval until a human approves them.
+	approval, err := r.ApproveCluster(ctx, log, capiCluster, sourceKey, target)
+	if err != nil {
+		return err
 	}
 
 	// Construct ArgoCluster from CapiCluster and CapiSecret.Metadata.
-	argoCluster := NewArgoCluster(capiCluster, &capiSecret)
+	argoCluster := NewArgoCluster(capiCluster, sourceKey)
+	if InsecureIgnored(capiCluster.Annotations) {
+		log.Info("Ignoring insecure annotation, insecure clusters are not allowed")
+		r.recordEvent(target, corev1.EventTypeWarning, "InsecureNotAllowed", fmt.Sprintf("Ignoring %s, insecure clusters are not allowed", InsecureAnnotation))
+	}
+	argoCluster.ClusterLabels[SourceLabel] = source
+	for key, value := range metadata.ArgoLabels {
+		argoCluster.ClusterLabels[key] = value
+	}
+	argoCluster.ClusterAnnotations = metadata.ArgoAnnotations
+	if approval != nil {
+		if argoCluster.ClusterAnnotations == nil {
+			argoCluster.ClusterAnnotations = map[string]string{}
+		}
+		argoCluster.ClusterAnnotations[ApprovalStateAnnotation] = ApprovalStateApproved
+		if approval.Approver != "" {
+			argoCluster.ClusterAnnotations[ApprovedByAnnotation] = approval.Approver
+		}
+		if approval.ExpiresAt != nil {
+			argoCluster.ClusterAnnotations[ApprovalExpiresAnnotation] = approval.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+		argoCluster.ClusterLabels[ApprovedClusterLabel] = capiCluster.Name
+	}
 
-	// Convert ArgoCluster into ArgoSecret to work natively on k8s objects.
-	log = r.Log.WithValues("cluster", argoCluster.NamespacedName)
-	argoSecret, err := argoCluster.ConvertToSecret()
+	// Inherit labels of the cluster namespace, eg. tenant or environment, without overriding others.
+	namespaceLabels, err := r.FetchNamespaceLabels(ctx, log, capiCluster.Namespace)
 	if err != nil {
-		log.Error(err, "Failed to convert ArgoCluster to ArgoSecret")
-		return ctrl.Result{}, err
+		return err
+	}
+	for key, value := range namespaceLabels {
+		if _, ok := argoCluster.ClusterLabels[key]; !ok {
+			argoCluster.ClusterLabels[key] = value
+		}
+	}
+
+	// Trust extra CA bundles referenced globally or by the CAPI Cluster, tracking the latter
+	// first so bundles created afterwards are still picked up.
+	if _, ok := target.(*corev1.Secret); ok {
+		r.caBundles.Track(sourceKey, capiCluster)
+	}
+	argoCluster.ClusterExtraCaData, err = r.ResolveCABundles(ctx, capiCluster)
+	if err != nil {
+		log.Error(err, "Failed to fetch CA bundles")
+		return err
+	}
+
+	// Let the transformation webhook apply custom rules, or reject the cluster, before
+	// the cluster is probed and its facts are queried through the server it ends up with.
+	if TransformWebhook != nil {
+		if err := r.TransformArgoCluster(ctx, log, capiCluster, target, source, argoCluster); err != nil {
+			return err
+		}
 	}
 
+	// Probe the cluster with its derived credentials before registering or updating it.
+	if HealthCheck != nil {
+		condition := ProbeArgoCluster(ctx, argoCluster, HealthCheck)
+		if argoCluster.ClusterAnnotations == nil {
+			argoCluster.ClusterAnnotations = map[string]string{}
+		}
+		for key, value := range condition.Annotations() {
+			argoCluster.ClusterAnnotations[key] = value
+		}
+		if condition.Failed() {
+			log.Info("Cluster failed health check", "reason", condition.Reason, "message", condition.Message)
+			r.recordEvent(target, corev1.EventTypeWarning, condition.Reason, condition.Message)
+			if HealthCheck.GetPolicy() == HealthPolicyHoldBack {
+				return ErrClusterUnhealthy
+			}
+		}
+	}
+
+	// Publish facts queried from the workload cluster, without overriding labels of the source.
+	if ClusterFactsDiscovery != nil {
+		facts := r.FetchClusterFacts(ctx, log, types.NamespacedName{Name: capiCluster.Name, Namespace: capiCluster.Namespace}, argoCluster)
+		// Facts of existing ArgoSecrets are kept until they can be discovered, eg. after a restart.
+		argoCluster.KeepClusterFacts = facts == nil
+		if facts != nil {
+			for key, value := range facts.Labels() {
+				if _, ok := argoCluster.ClusterLabels[key]; !ok {
+					argoCluster.ClusterLabels[key] = value
+				}
+			}
+			if argoCluster.ClusterAnnotations == nil {
+				argoCluster.ClusterAnnotations = map[string]string{}
+			}
+			for key, value := range facts.Annotations() {
+				argoCluster.ClusterAnnotations[key] = value
+			}
+		}
+	}
+
+	// Register ArgoCluster into every enabled sink, Argo targets being the default one.
+	var errs []error
+	for _, sink := range Sinks {
+		if err := sink.Reconcile(ctx, r, log.WithValues("sink", sink.Name()), capiCluster, argoCluster); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return goErr.Join(errs...)
+}
+
+// recordEvent emits an event on an object, if a recorder is set.
+func (r *Capi2Argo) recordEvent(obj runtime.Object, eventType, reason, message string) {
+	if r.Recorder != nil {
+		r.Recorder.Event(obj, eventType, reason, message)
+	}
+}
+
+// HubClient returns the client used to write ArgoSecrets into the given hub.
+func (r *Capi2Argo) HubClient(ctx context.Context, hub string) (client.Client, error) {
+	if r.Hubs == nil {
+		if hub != LocalHub {
+			return nil, fmt.Errorf("unknown hub %s", hub)
+		}
+		return r.Client, nil
+	}
+	return r.Hubs.Get(ctx, hub)
+}
+
+// hubNames returns all hubs ArgoSecrets may live in.
+func (r *Capi2Argo) hubNames() []string {
+	if r.Hubs == nil {
+		return []string{LocalHub}
+	}
+	return r.Hubs.Names()
+}
+
+// ReconcileArgoSecret creates or updates the ArgoSecret of a single ArgoCluster using
+// the client of the hub it belongs to, returning it unless not managed by the Controller.
+func (r *Capi2Argo) ReconcileArgoSecret(ctx context.Context, c client.Client, argoCluster *ArgoCluster) (*corev1.Secret, error) {
+	log := r.Log.WithValues("cluster", argoCluster.NamespacedName)
+
 	// Represent a possible existing ArgoSecret.
 	var existingSecret corev1.Secret
 	var exists bool
 
 	// Check if ArgoSecret exists.
-	err = r.Get(ctx, argoCluster.NamespacedName, &existingSecret)
+	err := c.Get(ctx, argoCluster.NamespacedName, &existingSecret)
 	if errors.IsNotFound(err) {
 		exists = false
 		log.Info("ArgoSecret does not exists, creating..")
@@ -135,7 +568,24 @@ func (r *Capi2Argo) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resul
 		log.Info("ArgoSecret exists, checking state..")
 	} else {
 		log.Error(err, "Failed to fetch ArgoSecret to check if exists")
-		return ctrl.Result{}, err
+		return nil, err
+	}
+
+	// Assign the Argo controller shard, keeping the one of an existing ArgoSecret when possible.
+	var current *corev1.Secret
+	if exists {
+		current = &existingSecret
+	}
+	if err := AssignArgoShard(ctx, c, argoCluster, current); err != nil {
+		log.Error(err, "Failed to assign Argo controller shard")
+		return nil, err
+	}
+
+	// Convert ArgoCluster into ArgoSecret to work natively on k8s objects.
+	argoSecret, err := argoCluster.ConvertToSecret()
+	if err != nil {
+		log.Error(err, "Failed to convert ArgoCluster to ArgoSecret")
+		return nil, err
 	}
 
 	// Reconcile ArgoSecret:
@@ -146,12 +596,12 @@ func (r *Capi2Argo) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resul
 	//     2) If it is controller-managed, check if updates needed and apply them.
 	switch exists {
 	case false:
-		if err := r.Create(ctx, argoSecret); err != nil {
+		if err := c.Create(ctx, argoSecret); err != nil {
 			log.Error(err, "Failed to create ArgoSecret")
-			return ctrl.Result{}, err
+			return nil, err
 		}
 		log.Info("Created new ArgoSecret")
-		return ctrl.Result{}, nil
+		existingSecret = *argoSecret
 
 	case true:
 
@@ -159,46 +609,185 @@ func (r *Capi2Argo) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resul
 		err := ValidateObjectOwner(existingSecret)
 		if err != nil {
 			log.Info("Not managed by Controller, skipping..")
-			return ctrl.Result{}, nil
+			return nil, nil
 		}
 
 		log.Info("Checking if ArgoSecret is out-of-sync with")
-		changed := false
-		if !bytes.Equal(existingSecret.Data["name"], []byte(argoCluster.ClusterName)) {
-			existingSecret.Data["name"] = []byte(argoCluster.ClusterName)
-			changed = true
-		}
-
-		if !bytes.Equal(existingSecret.Data["server"], []byte(argoCluster.ClusterServer)) {
-			existingSecret.Data["server"] = []byte(argoCluster.ClusterServer)
-			changed = true
+		previousProject, previousServer := string(existingSecret.Data["project"]), string(existingSecret.Data["server"])
+		// Rotate credentials only once verified against the workload cluster.
+		if CredentialRotation != nil {
+			if err := r.StageCredentialRotation(ctx, c, log, &existingSecret, argoSecret, argoCluster); err != nil {
+				return nil, err
+			}
 		}
-
-		if !bytes.Equal(existingSecret.Data["config"], []byte(argoSecret.Data["config"])) {
-			existingSecret.Data["config"] = []byte(argoSecret.Data["config"])
-			changed = true
+		if argoCluster.KeepClusterFacts {
+			KeepClusterFacts(&existingSecret, argoSecret)
 		}
-
-		if changed {
+		dataChanged := SyncArgoSecretData(&existingSecret, argoSecret)
+		labelsChanged := SyncArgoSecretLabels(&existingSecret, argoSecret)
+		annotationsChanged := SyncArgoSecretAnnotations(&existingSecret, argoSecret)
+		if dataChanged || labelsChanged || annotationsChanged {
 			log.Info("Updating out-of-sync ArgoSecret")
-			if err := r.Update(ctx, &existingSecret); err != nil {
+			if err := c.Update(ctx, &existingSecret); err != nil {
 				log.Error(err, "Failed to update ArgoSecret")
-				return ctrl.Result{}, err
+				return nil, err
 			}
 			log.Info("Updated successfully of ArgoSecret")
-			return ctrl.Result{}, nil
+		} else {
+			log.Info("ArgoSecret is in-sync with CapiCluster, skipping..")
 		}
 
-		log.Info("ArgoSecret is in-sync with CapiCluster, skipping..")
-		return ctrl.Result{}, nil
+		// Drop the cluster from the AppProject it was moved away from.
+		if previousProject != argoCluster.ClusterProject || previousServer != argoCluster.ClusterServer {
+			if err := ReleaseAppProject(ctx, c, log, argoCluster.NamespacedName.Namespace, previousProject, previousServer); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Make sure the AppProject the cluster is mapped to exists and targets it.
+	if err := EnsureAppProject(ctx, c, log, argoCluster); err != nil {
+		return nil, err
 	}
 
-	return ctrl.Result{}, nil
+	// ArgoSecret is now in place, so secrets left behind by a previous naming scheme can be migrated.
+	if err := r.MigrateLegacySecrets(ctx, c, log, argoCluster, &existingSecret); err != nil {
+		return nil, err
+	}
+	return &existingSecret, nil
+}
+
+// GarbageCollect deletes ArgoSecrets generated from given CapiSecret that live outside
+// of the keep Argo targets, on every known hub. A nil keep list deletes all of them.
+func (r *Capi2Argo) GarbageCollect(ctx context.Context, log logr.Logger, capiSecret client.ObjectKey, keep []ArgoTarget) error {
+	labelSelector := map[string]string{
+		"capi-to-argocd/owned":               "true",
+		"capi-to-argocd/cluster-secret-name": capiSecret.Name,
+		"capi-to-argocd/cluster-namespace":   capiSecret.Namespace,
+	}
+	listOption := client.MatchingLabels(labelSelector)
+
+	var errs []error
+	for _, hub := range r.hubNames() {
+		hubClient, err := r.HubClient(ctx, hub)
+		if err != nil {
+			log.Error(err, "Failed to get Argo hub client", "hub", hub)
+			errs = append(errs, err)
+			continue
+		}
+
+		secretList := &corev1.SecretList{}
+		if err := hubClient.List(ctx, secretList, listOption); err != nil {
+			log.Error(err, "Failed to list Cluster Secrets", "hub", hub)
+			errs = append(errs, err)
+			continue
+		}
+
+		for i := range secretList.Items {
+			argoSecret := &secretList.Items[i]
+			target := ArgoTarget{Hub: hub, Namespace: argoSecret.Namespace}
+			if containsTarget(keep, target) {
+				continue
+			}
+			if err := GarbageCollectBootstraps(ctx, hubClient, log, BootstrapNamespaces(argoSecret), capiSecret); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			project, server := string(argoSecret.Data["project"]), string(argoSecret.Data["server"])
+			if err := ReleaseAppProject(ctx, hubClient, log, argoSecret.Namespace, project, server); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if err := hubClient.Delete(ctx, argoSecret); client.IgnoreNotFound(err) != nil {
+				log.Error(err, "Failed to delete ArgoSecret", "target", target.String(), "argoSecret", argoSecret.Name)
+				errs = append(errs, err)
+				continue
+			}
+			log.Info("Deleted successfully of ArgoSecret", "target", target.String(), "argoSecret", argoSecret.Name)
+			backupKey := types.NamespacedName{Name: BackupSecretName(argoSecret.Name), Namespace: argoSecret.Namespace}
+			if err := deleteBackupSecret(ctx, hubClient, log, backupKey); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return goErr.Join(errs...)
 }
 
 // SetupWithManager ..
 func (r *Capi2Argo) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).For(&corev1.Secret{}).Complete(r)
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Secret{})
+
+	// Refresh clusters trusting a CA bundle once it changes, only watching the kinds in use.
+	for _, bundle := range []struct {
+		kind string
+		obj  client.Object
+	}{{CABundleKindConfigMap, &corev1.ConfigMap{}}, {CABundleKindSecret, &corev1.Secret{}}} {
+		if !CABundleWatched(bundle.kind) {
+			continue
+		}
+		b = b.Watches(bundle.obj, handler.EnqueueRequestsFromMapFunc(r.CABundleRequests(bundle.kind)),
+			builder.WithPredicates(r.caBundles.Predicate(bundle.kind)))
+	}
+
+	// Register admin kubeconfig secrets once their ClusterDeployment gets installed.
+	if EnableHiveSource {
+		cd := &unstructured.Unstructured{}
+		cd.SetGroupVersionKind(HiveClusterDeploymentGVK)
+		b = b.Watches(cd, handler.EnqueueRequestsFromMapFunc(HiveClusterDeploymentRequests))
+	}
+
+	// Refresh topology labels and annotations once a CAPI Cluster changes, eg. on upgrades.
+	// Maintenance also depends on its phase, so status changes are watched as well.
+	// Clusters are re-routed, re-mapped to projects and scopes, get their server rewritten
+	// and approvals required once the labels rules select on change.
+	var clusterPredicates []predicate.Predicate
+	if len(TopologyRules) > 0 || Maintenance != nil {
+		clusterPredicates = append(clusterPredicates, predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{})
+	}
+	if ClusterLabelsInUse() {
+		clusterPredicates = append(clusterPredicates, predicate.LabelChangedPredicate{})
+	}
+	if len(clusterPredicates) > 0 {
+		cluster := &unstructured.Unstructured{}
+		cluster.SetGroupVersionKind(CapiClusterGVK)
+		var p predicate.Predicate = predicate.Or(clusterPredicates...)
+		if Maintenance != nil {
+			p = predicate.ResourceVersionChangedPredicate{}
+		}
+		b = b.Watches(cluster, handler.EnqueueRequestsFromMapFunc(r.CapiClusterRequests), builder.WithPredicates(p))
+	}
+
+	// Mark clusters under maintenance while their control plane or workers roll out, for
+	// the kinds installed. Other control plane kinds are picked up on changes of their
+	// CAPI Cluster.
+	if Maintenance != nil {
+		for _, gvk := range []schema.GroupVersionKind{CapiMachineDeploymentGVK, KubeadmControlPlaneGVK} {
+			if _, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+				r.Log.Info("Kind is not installed, not watching its rollouts", "kind", gvk.Kind)
+				continue
+			}
+			obj := &unstructured.Unstructured{}
+			obj.SetGroupVersionKind(gvk)
+			b = b.Watches(obj, handler.EnqueueRequestsFromMapFunc(r.CapiClusterOwnedRequests),
+				builder.WithPredicates(predicate.ResourceVersionChangedPredicate{}))
+		}
+	}
+
+	// Register clusters once approved on their namespace, and refresh inherited labels of
+	// every cluster in a namespace once its labels change.
+	var namespacePredicates []predicate.Predicate
+	if len(ApprovalRules) > 0 {
+		namespacePredicates = append(namespacePredicates, predicate.AnnotationChangedPredicate{})
+	}
+	if NamespaceLabels != nil {
+		namespacePredicates = append(namespacePredicates, predicate.LabelChangedPredicate{})
+	}
+	if len(namespacePredicates) > 0 {
+		b = b.Watches(&corev1.Namespace{}, handler.EnqueueRequestsFromMapFunc(r.NamespaceRequests),
+			builder.WithPredicates(predicate.Or(namespacePredicates...)))
+	}
+	return b.Complete(r)
 }
 
 // ValidateObjectOwner checks whether reconciled object is managed by CACO or not.
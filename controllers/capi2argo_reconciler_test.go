@@ -2,6 +2,8 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/stretchr/testify/assert"
@@ -152,6 +154,27 @@ func TestValidateObjectOwner(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestValidateConfig(t *testing.T) {
+	oldMode, oldRules, oldErrors := NameMigrationMode, RoutingRules, configErrors
+	defer func() { NameMigrationMode, RoutingRules, configErrors = oldMode, oldRules, oldErrors }()
+
+	configErrors = nil
+	assert.Nil(t, ValidateConfig())
+
+	NameMigrationMode = "bogus"
+	assert.NotNil(t, ValidateConfig())
+	NameMigrationMode = NameMigrationDryRun
+
+	RoutingRules = []RoutingRule{{}}
+	assert.NotNil(t, ValidateConfig())
+	RoutingRules = nil
+
+	t.Setenv("TEST_JSON_ENV", "{not-json")
+	var v []RoutingRule
+	parseJSONEnv("TEST_JSON_ENV", &v)
+	assert.NotNil(t, ValidateConfig())
+}
+
 func MockReconcileReq(name string, namespace string) reconcile.Request {
 	r := reconcile.Request{
 		NamespacedName: types.NamespacedName{
@@ -186,3 +209,45 @@ func MockReconcileEnv() error {
 
 	return K8sClient.Create(context.Background(), MockCapiSecret(validMock, validType, !validKey, "err-key-kubeconfig", TestNamespace))
 }
+
+func TestReconcileIgnoresSinkOwnedSecrets(t *testing.T) {
+	// Fleet sinks write <cluster>-kubeconfig secrets without the CAPI type.
+	sinkSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name: "test-kubeconfig", Namespace: TestNamespace, Labels: map[string]string{SinkOwnedLabel("fleet"): "true"},
+	}}
+	key := types.NamespacedName{Name: sinkSecret.Name, Namespace: sinkSecret.Namespace}
+	c := &mockClient{secrets: map[types.NamespacedName]*corev1.Secret{key: sinkSecret}}
+	r := &Capi2Argo{Client: c, Log: ctrl.Log.WithName("test")}
+
+	result, err := r.Reconcile(context.Background(), MockReconcileReq(key.Name, key.Namespace))
+	assert.Nil(t, err)
+	assert.Equal(t, reconcile.Result{}, result)
+	assert.Len(t, c.secrets, 1)
+}
+
+func TestHeldBackResult(t *testing.T) {
+	oldHealthCheck, oldWebhook := HealthCheck, TransformWebhook
+	defer func() { HealthCheck, TransformWebhook = oldHealthCheck, oldWebhook }()
+	HealthCheck = &HealthCheckConfig{RetryAfter: "2m"}
+	TransformWebhook = &TransformWebhookConfig{RetryAfter: "10m"}
+
+	tests := []struct {
+		testName       string
+		testMock       error
+		expectedResult reconcile.Result
+		expectedOk     bool
+	}{
+		{"test no error", nil, reconcile.Result{}, false},
+		{"test failure", errors.New("boom"), reconcile.Result{}, false},
+		{"test unhealthy", fmt.Errorf("probe: %w", ErrClusterUnhealthy), reconcile.Result{RequeueAfter: 2 * time.Minute}, true},
+		{"test approval pending", ErrApprovalPending, reconcile.Result{}, true},
+		{"test rejected", ErrClusterRejected, reconcile.Result{RequeueAfter: 10 * time.Minute}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			result, ok := heldBackResult(tt.testMock)
+			assert.Equal(t, tt.expectedResult, result)
+			assert.Equal(t, tt.expectedOk, ok)
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"gopkg.in/yaml.v2"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"strings"
 )
@@ -11,11 +12,16 @@ import (
 // CapiClusterSecretType represents the CAPI managed secret type.
 const CapiClusterSecretType corev1.SecretType = "cluster.x-k8s.io/secret"
 
+// CapiClusterGVK represents the CAPI Cluster kind that owns kubeconfig secrets.
+var CapiClusterGVK = schema.GroupVersionKind{Group: "cluster.x-k8s.io", Version: "v1beta1", Kind: "Cluster"}
+
 // CapiCluster is an one-on-one representation of KubeConfig fields.
 type CapiCluster struct {
-	Name       string     `yaml:"name"`
-	Namespace  string     `yaml:"namespace"`
-	KubeConfig KubeConfig `yaml:"kubeConfig"`
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+	KubeConfig  KubeConfig        `yaml:"kubeConfig"`
 }
 
 // KubeConfig is an one-on-one representation of KubeConfig fields.
@@ -34,8 +40,11 @@ type Cluster struct {
 
 // ClusterInfo represents kubeconfig.[]Clusters.Cluster.Clusterinfo fields.
 type ClusterInfo struct {
-	CaData string `yaml:"certificate-authority-data"`
-	Server string `yaml:"server"`
+	CaData                string `yaml:"certificate-authority-data"`
+	Server                string `yaml:"server"`
+	ProxyURL              string `yaml:"proxy-url,omitempty"`
+	TLSServerName         string `yaml:"tls-server-name,omitempty"`
+	InsecureSkipTLSVerify bool   `yaml:"insecure-skip-tls-verify,omitempty"`
 }
 
 // User represents kubeconfig.[]Users fields.
@@ -46,8 +55,25 @@ type User struct {
 
 // UserInfo represents kubeconfig.[]Users.User fields.
 type UserInfo struct {
-	CertData string `yaml:"client-certificate-data"`
-	KeyData  string `yaml:"client-key-data"`
+	CertData string      `yaml:"client-certificate-data"`
+	KeyData  string      `yaml:"client-key-data"`
+	Token    string      `yaml:"token,omitempty"`
+	Exec     *ExecConfig `yaml:"exec,omitempty"`
+}
+
+// ExecConfig represents kubeconfig.[]Users.User.Exec fields.
+type ExecConfig struct {
+	APIVersion  string       `yaml:"apiVersion"`
+	Command     string       `yaml:"command"`
+	Args        []string     `yaml:"args,omitempty"`
+	Env         []ExecEnvVar `yaml:"env,omitempty"`
+	InstallHint string       `yaml:"installHint,omitempty"`
+}
+
+// ExecEnvVar represents kubeconfig.[]Users.User.Exec.[]Env fields.
+type ExecEnvVar struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
 }
 
 // NewCapiCluster returns an empty CapiCluster type.
@@ -64,7 +90,12 @@ func (c *CapiCluster) Unmarshal(s *corev1.Secret) error {
 	if err := ValidateCapiSecret(s); err != nil {
 		return err
 	}
-	err := yaml.Unmarshal(s.Data["value"], &c.KubeConfig)
+	return c.UnmarshalKubeConfig(s.Data["value"])
+}
+
+// UnmarshalKubeConfig parses a raw kubeconfig into CapiCluster type.
+func (c *CapiCluster) UnmarshalKubeConfig(raw []byte) error {
+	err := yaml.Unmarshal(raw, &c.KubeConfig)
 	if err != nil || len(c.KubeConfig.Clusters) == 0 || len(c.KubeConfig.Users) == 0 || c.KubeConfig.APIVersion != "v1" || c.KubeConfig.Kind != "Config" {
 		return errors.New("invalid KubeConfig")
 
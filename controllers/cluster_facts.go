@@ -0,0 +1,344 @@
+package controllers
+
+import (
+	"context"
+	goErr "errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// KubernetesVersionLabel on an ArgoSecret holds the server version of the workload cluster.
+	KubernetesVersionLabel = "capi-to-argocd/kubernetes-version"
+	// NodeCountLabel on an ArgoSecret holds the node count of the workload cluster.
+	NodeCountLabel = "capi-to-argocd/node-count"
+	// ZonesAnnotation on an ArgoSecret lists the zones of the workload cluster nodes.
+	ZonesAnnotation = "capi-to-argocd/zones"
+	// RegionsAnnotation on an ArgoSecret lists the regions of the workload cluster nodes.
+	RegionsAnnotation = "capi-to-argocd/regions"
+
+	apiLabelPrefix = "capi-to-argocd/api-"
+
+	defaultClusterFactsInterval = 10 * time.Minute
+	minClusterFactsInterval     = time.Minute
+	clusterFactsTimeout         = 10 * time.Second
+)
+
+var (
+	// ClusterFactsDiscovery enables querying facts from workload clusters, when set.
+	ClusterFactsDiscovery *ClusterFactsConfig
+
+	// ErrExecProviderUnsupported is returned for clusters relying on exec based credentials,
+	// which the operator does not run.
+	ErrExecProviderUnsupported = goErr.New("exec based credentials are not supported by the operator")
+)
+
+// ClusterFactsConfig configures the facts queried from workload clusters.
+type ClusterFactsConfig struct {
+	// Interval facts are refreshed at, defaulting to 10m.
+	Interval string `json:"interval,omitempty"`
+	// APIs maps a name to an API group, or group/version, whose presence is published
+	// as the capi-to-argocd/api-<name> label, eg. istio: networking.istio.io.
+	APIs map[string]string `json:"apis,omitempty"`
+}
+
+// GetInterval returns the interval facts are refreshed at, or zero when disabled.
+func (f *ClusterFactsConfig) GetInterval() time.Duration {
+	if f == nil {
+		return 0
+	}
+	if d, err := time.ParseDuration(f.Interval); err == nil && f.Interval != "" {
+		return d
+	}
+	return defaultClusterFactsInterval
+}
+
+// ValidateClusterFacts validates the refresh interval and the API names and groups.
+func ValidateClusterFacts(f *ClusterFactsConfig) error {
+	if f == nil {
+		return nil
+	}
+	if f.Interval != "" {
+		d, err := time.ParseDuration(f.Interval)
+		if err != nil {
+			return err
+		}
+		if d < minClusterFactsInterval {
+			return fmt.Errorf("interval must be at least %s", minClusterFactsInterval)
+		}
+	}
+	for name, api := range f.APIs {
+		if errs := validation.IsQualifiedName(apiLabelPrefix + name); len(errs) > 0 {
+			return fmt.Errorf("api %q: %s", name, strings.Join(errs, ", "))
+		}
+		if api == "" {
+			return fmt.Errorf("api %q: missing group", name)
+		}
+	}
+	return nil
+}
+
+// ClusterFacts are queried from a workload cluster.
+type ClusterFacts struct {
+	KubernetesVersion string
+	NodeCount         int
+	Zones             []string
+	Regions           []string
+	// APIs reports the presence of every configured API, by name.
+	APIs        map[string]bool
+	RefreshedAt time.Time
+}
+
+// Labels returns the ArgoSecret labels describing the facts. The region label is only
+// set for clusters spanning a single region.
+func (f *ClusterFacts) Labels() map[string]string {
+	labels := map[string]string{
+		NodeCountLabel: strconv.Itoa(f.NodeCount),
+	}
+	if v := SanitizeLabelValue(f.KubernetesVersion); v != "" {
+		labels[KubernetesVersionLabel] = v
+	}
+	if len(f.Regions) == 1 {
+		if v := SanitizeLabelValue(f.Regions[0]); v != "" {
+			labels[RegionLabel] = v
+		}
+	}
+	for name, present := range f.APIs {
+		labels[apiLabelPrefix+name] = strconv.FormatBool(present)
+	}
+	return labels
+}
+
+// Annotations returns the ArgoSecret annotations listing zones and regions.
+func (f *ClusterFacts) Annotations() map[string]string {
+	annotations := map[string]string{}
+	if len(f.Zones) > 0 {
+		annotations[ZonesAnnotation] = strings.Join(f.Zones, ",")
+	}
+	if len(f.Regions) > 0 {
+		annotations[RegionsAnnotation] = strings.Join(f.Regions, ",")
+	}
+	return annotations
+}
+
+// RESTConfig returns a client config for the cluster, using the credentials of its ArgoSecret.
+// Exec based credentials are refused: their command comes from the kubeconfig secret and
+// is meant to run in the Argo controller, never in the operator.
+func (a *ArgoCluster) RESTConfig() (*rest.Config, error) {
+	if a.ClusterConfig.ExecProviderConfig != nil {
+		return nil, ErrExecProviderUnsupported
+	}
+	kubeConfig, err := a.ConvertToKubeConfig()
+	if err != nil {
+		return nil, err
+	}
+	config, err := clientcmd.RESTConfigFromKubeConfig(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	config.ExecProvider, config.AuthProvider = nil, nil
+	return config, nil
+}
+
+// DiscoverClusterFacts queries the facts of a workload cluster: its server version, nodes
+// with their zones and regions, and the presence of given APIs.
+func DiscoverClusterFacts(ctx context.Context, config *rest.Config, apis map[string]string) (*ClusterFacts, error) {
+	config = rest.CopyConfig(config)
+	config.Timeout = clusterFactsTimeout
+
+	dc, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	version, err := dc.ServerVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch server version: %w", err)
+	}
+	groups, err := dc.ServerGroups()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch API groups: %w", err)
+	}
+
+	c, err := client.New(config, client.Options{Scheme: scheme.Scheme})
+	if err != nil {
+		return nil, err
+	}
+	// Only node labels are needed, so skip fetching full node objects.
+	nodes := &metav1.PartialObjectMetadataList{}
+	nodes.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("NodeList"))
+	if err := c.List(ctx, nodes); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	facts := &ClusterFacts{
+		KubernetesVersion: version.GitVersion,
+		NodeCount:         len(nodes.Items),
+		APIs:              map[string]bool{},
+		RefreshedAt:       time.Now(),
+	}
+	zones, regions := map[string]bool{}, map[string]bool{}
+	for _, node := range nodes.Items {
+		if v := node.Labels[corev1.LabelTopologyZone]; v != "" {
+			zones[v] = true
+		}
+		if v := node.Labels[corev1.LabelTopologyRegion]; v != "" {
+			regions[v] = true
+		}
+	}
+	facts.Zones, facts.Regions = sortedKeys(zones), sortedKeys(regions)
+
+	for name, api := range apis {
+		facts.APIs[name] = serverHasAPI(groups, api)
+	}
+	return facts, nil
+}
+
+// serverHasAPI reports whether an API group, or group/version, is served.
+func serverHasAPI(groups *metav1.APIGroupList, api string) bool {
+	for _, group := range groups.Groups {
+		if group.Name == api {
+			return true
+		}
+		for _, version := range group.Versions {
+			if version.GroupVersion == api {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of a set in order.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// IsClusterFactKey reports whether an ArgoSecret label or annotation describes a fact
+// of the workload cluster.
+func IsClusterFactKey(key string) bool {
+	switch key {
+	case KubernetesVersionLabel, NodeCountLabel, RegionLabel, ZonesAnnotation, RegionsAnnotation:
+		return true
+	}
+	return strings.HasPrefix(key, apiLabelPrefix)
+}
+
+// KeepClusterFacts copies the facts of an existing ArgoSecret that are not desired into
+// the desired one, so facts are not lost while they cannot be discovered.
+func KeepClusterFacts(existing, desired *corev1.Secret) {
+	for key, value := range existing.Labels {
+		if _, ok := desired.Labels[key]; !ok && IsClusterFactKey(key) {
+			if desired.Labels == nil {
+				desired.Labels = map[string]string{}
+			}
+			desired.Labels[key] = value
+		}
+	}
+	for key, value := range existing.Annotations {
+		if _, ok := desired.Annotations[key]; !ok && IsClusterFactKey(key) {
+			if desired.Annotations == nil {
+				desired.Annotations = map[string]string{}
+			}
+			desired.Annotations[key] = value
+		}
+	}
+}
+
+// clusterFactsEntry caches the last known facts of a cluster along with when to query
+// the workload cluster again.
+type clusterFactsEntry struct {
+	facts     *ClusterFacts
+	failures  int
+	refreshAt time.Time
+}
+
+// clusterFactsBackoff returns how long to wait before querying a workload cluster again
+// after consecutive failures, doubling up to the refresh interval.
+func clusterFactsBackoff(failures int) time.Duration {
+	backoff := minClusterFactsInterval
+	for i := 1; i < failures && backoff < ClusterFactsDiscovery.GetInterval(); i++ {
+		backoff *= 2
+	}
+	if interval := ClusterFactsDiscovery.GetInterval(); backoff > interval {
+		return interval
+	}
+	return backoff
+}
+
+// FetchClusterFacts returns the facts of a cluster, querying the workload cluster once
+// the cached ones are older than the refresh interval. Unreachable clusters, eg. while
+// provisioning, keep their last known facts and are queried again with a backoff.
+func (r *Capi2Argo) FetchClusterFacts(ctx context.Context, log logr.Logger, key types.NamespacedName, a *ArgoCluster) *ClusterFacts {
+	r.factsMu.Lock()
+	entry := r.facts[key]
+	r.factsMu.Unlock()
+	if entry == nil {
+		entry = &clusterFactsEntry{}
+	}
+	if time.Now().Before(entry.refreshAt) {
+		return entry.facts
+	}
+
+	facts, err := r.discoverClusterFacts(ctx, log, a)
+	next := &clusterFactsEntry{facts: facts, refreshAt: time.Now().Add(ClusterFactsDiscovery.GetInterval())}
+	if err != nil {
+		next.facts, next.failures = entry.facts, entry.failures+1
+		if !goErr.Is(err, ErrExecProviderUnsupported) {
+			next.refreshAt = time.Now().Add(clusterFactsBackoff(next.failures))
+		}
+	}
+
+	r.factsMu.Lock()
+	defer r.factsMu.Unlock()
+	if r.facts == nil {
+		r.facts = map[types.NamespacedName]*clusterFactsEntry{}
+	}
+	r.facts[key] = next
+	return next.facts
+}
+
+// discoverClusterFacts queries the facts of the workload cluster of an ArgoCluster.
+func (r *Capi2Argo) discoverClusterFacts(ctx context.Context, log logr.Logger, a *ArgoCluster) (*ClusterFacts, error) {
+	config, err := a.RESTConfig()
+	if goErr.Is(err, ErrExecProviderUnsupported) {
+		log.Info("Skipping workload cluster facts discovery", "reason", err.Error())
+		return nil, err
+	}
+	if err != nil {
+		log.Error(err, "Failed to build workload cluster client config")
+		return nil, err
+	}
+	facts, err := DiscoverClusterFacts(ctx, config, ClusterFactsDiscovery.APIs)
+	if err != nil {
+		log.Error(err, "Failed to discover workload cluster facts")
+		return nil, err
+	}
+	log.Info("Discovered workload cluster facts", "version", facts.KubernetesVersion, "nodes", facts.NodeCount)
+	return facts, nil
+}
+
+// ForgetClusterFacts drops the cached facts of a cluster.
+func (r *Capi2Argo) ForgetClusterFacts(key types.NamespacedName) {
+	r.factsMu.Lock()
+	defer r.factsMu.Unlock()
+	delete(r.facts, key)
+}
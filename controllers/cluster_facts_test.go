@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+func TestValidateClusterFacts(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		testName          string
+		testMock          *ClusterFactsConfig
+		testExpectedError bool
+	}{
+		{"test disabled", nil, false},
+		{"test defaults", &ClusterFactsConfig{}, false},
+		{"test apis", &ClusterFactsConfig{Interval: "5m", APIs: map[string]string{"istio": "networking.istio.io", "gateway": "gateway.networking.k8s.io/v1"}}, false},
+		{"test invalid interval", &ClusterFactsConfig{Interval: "soon"}, true},
+		{"test short interval", &ClusterFactsConfig{Interval: "10s"}, true},
+		{"test invalid api name", &ClusterFactsConfig{APIs: map[string]string{"gateway api": "gateway.networking.k8s.io"}}, true},
+		{"test missing api group", &ClusterFactsConfig{APIs: map[string]string{"istio": ""}}, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateClusterFacts(tt.testMock)
+			if !tt.testExpectedError {
+				assert.Nil(t, err)
+			} else {
+				assert.NotNil(t, err)
+			}
+		})
+	}
+}
+
+func TestClusterFactsGetInterval(t *testing.T) {
+	t.Parallel()
+	var disabled *ClusterFactsConfig
+	assert.Equal(t, time.Duration(0), disabled.GetInterval())
+	assert.Equal(t, 10*time.Minute, (&ClusterFactsConfig{}).GetInterval())
+	assert.Equal(t, 5*time.Minute, (&ClusterFactsConfig{Interval: "5m"}).GetInterval())
+}
+
+func TestClusterFactsLabels(t *testing.T) {
+	t.Parallel()
+	facts := &ClusterFacts{
+		KubernetesVersion: "v1.29.2+k3s1",
+		NodeCount:         3,
+		Zones:             []string{"eu-west-1a", "eu-west-1b"},
+		Regions:           []string{"eu-west-1"},
+		APIs:              map[string]bool{"istio": true, "gateway": false},
+	}
+	assert.Equal(t, map[string]string{
+		KubernetesVersionLabel:       "v1.29.2-k3s1",
+		NodeCountLabel:               "3",
+		RegionLabel:                  "eu-west-1",
+		"capi-to-argocd/api-istio":   "true",
+		"capi-to-argocd/api-gateway": "false",
+	}, facts.Labels())
+	assert.Equal(t, map[string]string{
+		ZonesAnnotation:   "eu-west-1a,eu-west-1b",
+		RegionsAnnotation: "eu-west-1",
+	}, facts.Annotations())
+
+	facts.Regions = append(facts.Regions, "eu-central-1")
+	assert.NotContains(t, facts.Labels(), RegionLabel)
+}
+
+func TestArgoClusterRESTConfig(t *testing.T) {
+	t.Parallel()
+	a := MockArgoCluster(true)
+	a.ClusterServer = "https://server"
+	config, err := a.RESTConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, "https://server", config.Host)
+	assert.Equal(t, []byte("tester"), config.CAData)
+	assert.Equal(t, []byte("tester"), config.CertData)
+	assert.Equal(t, []byte("tester"), config.KeyData)
+
+	_, err = MockArgoCluster(false).RESTConfig()
+	assert.NotNil(t, err)
+}
+
+// TestDiscoverClusterFacts queries an envtest instance standing in for a workload cluster.
+func TestDiscoverClusterFacts(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set")
+	}
+
+	ctx := context.Background()
+	env := &envtest.Environment{}
+	cfg, err := env.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = env.Stop() }()
+
+	c, err := client.New(cfg, client.Options{Scheme: scheme.Scheme})
+	assert.Nil(t, err)
+	for name, zone := range map[string]string{"node-a": "eu-west-1a", "node-b": "eu-west-1b"} {
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{
+			corev1.LabelTopologyZone:   zone,
+			corev1.LabelTopologyRegion: "eu-west-1",
+		}}}
+		assert.Nil(t, c.Create(ctx, node))
+	}
+
+	a := MockArgoCluster(true)
+	a.ClusterServer = cfg.Host
+	a.ClusterConfig.BearerToken = cfg.BearerToken
+	a.ClusterConfig.TLSClientConfig = ArgoTLS{
+		CaData:   b64.StdEncoding.EncodeToString(cfg.CAData),
+		CertData: b64.StdEncoding.EncodeToString(cfg.CertData),
+		KeyData:  b64.StdEncoding.EncodeToString(cfg.KeyData),
+	}
+	config, err := a.RESTConfig()
+	assert.Nil(t, err)
+
+	facts, err := DiscoverClusterFacts(ctx, config, map[string]string{"apps": "apps/v1", "istio": "networking.istio.io"})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, facts.KubernetesVersion)
+	assert.Equal(t, 2, facts.NodeCount)
+	assert.Equal(t, []string{"eu-west-1a", "eu-west-1b"}, facts.Zones)
+	assert.Equal(t, []string{"eu-west-1"}, facts.Regions)
+	assert.Equal(t, map[string]bool{"apps": true, "istio": false}, facts.APIs)
+}
+
+func TestArgoClusterRESTConfigExecProvider(t *testing.T) {
+	t.Parallel()
+	marker := filepath.Join(t.TempDir(), "executed")
+	a := MockArgoCluster(true)
+	a.ClusterServer = "https://server"
+	a.ClusterConfig.ExecProviderConfig = &ArgoExecProvider{Command: "touch", Args: []string{marker}, APIVersion: "client.authentication.k8s.io/v1beta1"}
+
+	_, err := a.RESTConfig()
+	assert.ErrorIs(t, err, ErrExecProviderUnsupported)
+
+	r := &Capi2Argo{Log: logr.Discard()}
+	assert.Nil(t, r.FetchClusterFacts(context.Background(), logr.Discard(), types.NamespacedName{Name: "test", Namespace: "test"}, a))
+	_, err = os.Stat(marker)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestClusterFactsBackoff(t *testing.T) {
+	old := ClusterFactsDiscovery
+	defer func() { ClusterFactsDiscovery = old }()
+	ClusterFactsDiscovery = &ClusterFactsConfig{Interval: "10m"}
+
+	for failures, expected := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 3: 4 * time.Minute, 5: 10 * time.Minute, 100: 10 * time.Minute} {
+		assert.Equal(t, expected, clusterFactsBackoff(failures), failures)
+	}
+}
+
+func TestFetchClusterFactsCachesFailures(t *testing.T) {
+	old := ClusterFactsDiscovery
+	defer func() { ClusterFactsDiscovery = old }()
+	ClusterFactsDiscovery = &ClusterFactsConfig{}
+
+	var requests atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	a := MockArgoCluster(true)
+	a.ClusterServer = server.URL
+	a.ClusterConfig.BearerToken = "tester"
+	a.ClusterConfig.TLSClientConfig = ArgoTLS{CaData: b64.StdEncoding.EncodeToString(ca)}
+	key := types.NamespacedName{Name: "test", Namespace: "test"}
+	r := &Capi2Argo{Log: logr.Discard()}
+
+	assert.Nil(t, r.FetchClusterFacts(context.Background(), logr.Discard(), key, a))
+	attempted := requests.Load()
+	assert.NotZero(t, attempted)
+
+	// The failure is cached, the workload cluster is not queried again before the backoff.
+	assert.Nil(t, r.FetchClusterFacts(context.Background(), logr.Discard(), key, a))
+	assert.Equal(t, attempted, requests.Load())
+	assert.Equal(t, 1, r.facts[key].failures)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), r.facts[key].refreshAt, 5*time.Second)
+
+	// Last known facts are kept on failures.
+	facts := &ClusterFacts{KubernetesVersion: "v1.29.2"}
+	r.facts[key] = &clusterFactsEntry{facts: facts, failures: 1}
+	assert.Equal(t, facts, r.FetchClusterFacts(context.Background(), logr.Discard(), key, a))
+	assert.Equal(t, 2, r.facts[key].failures)
+}
+
+func TestKeepClusterFacts(t *testing.T) {
+	t.Parallel()
+	existing := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Labels: map[string]string{
+			KubernetesVersionLabel:   "v1.29.2",
+			NodeCountLabel:           "3",
+			apiLabelPrefix + "istio": "true",
+			"capi-to-argocd/owned":   "true",
+			"capi-to-argocd/source":  "capi",
+		},
+		Annotations: map[string]string{ZonesAnnotation: "eu-west-1a", "capi-to-argocd/approved-by": "admin"},
+	}}
+	desired := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{NodeCountLabel: "4"}}}
+
+	KeepClusterFacts(existing, desired)
+	assert.Equal(t, map[string]string{KubernetesVersionLabel: "v1.29.2", NodeCountLabel: "4", apiLabelPrefix + "istio": "true"}, desired.Labels)
+	assert.Equal(t, map[string]string{ZonesAnnotation: "eu-west-1a"}, desired.Annotations)
+}
@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"context"
+	b64 "encoding/base64"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	defaultFleetNamespace         = "fleet-default"
+	defaultKarmadaSecretNamespace = "karmada-cluster"
+
+	// sveltosSecretSuffix names the kubeconfig secrets of SveltosClusters. They default to
+	// the namespace of the cluster, so the suffix must not be matched by source adapters.
+	sveltosSecretSuffix = "-sveltos-credentials"
+)
+
+// SveltosClusterGVK represents the Sveltos SveltosCluster kind.
+var SveltosClusterGVK = schema.GroupVersionKind{Group: "lib.projectsveltos.io", Version: "v1beta1", Kind: "SveltosCluster"}
+
+// FleetClusterGVK represents the Rancher Fleet Cluster kind.
+var FleetClusterGVK = schema.GroupVersionKind{Group: "fleet.cattle.io", Version: "v1alpha1", Kind: "Cluster"}
+
+// KarmadaClusterGVK represents the cluster scoped Karmada Cluster kind.
+var KarmadaClusterGVK = schema.GroupVersionKind{Group: "cluster.karmada.io", Version: "v1alpha1", Kind: "Cluster"}
+
+// newSinkObject returns an object of a sink carrying the labels of an ArgoCluster.
+func newSinkObject(sink string, gvk schema.GroupVersionKind, name, namespace string, a *ArgoCluster, spec map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetName(name)
+	obj.SetNamespace(namespace)
+	obj.SetLabels(SinkLabels(sink, a))
+	return obj
+}
+
+// keepNamespace returns a GC keep func matching objects of given namespace.
+func keepNamespace(namespace string) func(client.Object) bool {
+	return func(obj client.Object) bool {
+		return obj.GetNamespace() == namespace
+	}
+}
+
+// SveltosClusterSink registers clusters as SveltosClusters referencing a kubeconfig secret.
+type SveltosClusterSink struct {
+	// Namespace of the SveltosClusters, defaulting to the namespace of the cluster.
+	Namespace string
+}
+
+// Name implements Sink.
+func (SveltosClusterSink) Name() string { return SinkSveltos }
+
+// Reconcile implements Sink.
+func (s SveltosClusterSink) Reconcile(ctx context.Context, r *Capi2Argo, log logr.Logger, c *CapiCluster, a *ArgoCluster) error {
+	kubeConfig, err := a.ConvertToKubeConfig()
+	if err != nil {
+		log.Error(err, "Failed to convert ArgoCluster to Sveltos kubeconfig")
+		return err
+	}
+
+	namespace := s.Namespace
+	if namespace == "" {
+		namespace = c.Namespace
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: a.NamespacedName.Name + sveltosSecretSuffix, Namespace: namespace, Labels: SinkLabels(SinkSveltos, a)},
+		Data:       map[string][]byte{"kubeconfig": kubeConfig},
+	}
+	if err := r.reconcileOwnedSecret(ctx, log, SinkSveltos, secret); err != nil {
+		return err
+	}
+	cluster := newSinkObject(SinkSveltos, SveltosClusterGVK, a.NamespacedName.Name, namespace, a, map[string]interface{}{
+		"kubeconfigName":    secret.Name,
+		"kubeconfigKeyName": "kubeconfig",
+	})
+	if err := r.reconcileOwnedObject(ctx, log, SinkSveltos, cluster); err != nil {
+		return err
+	}
+
+	if EnableGarbageCollection {
+		return r.garbageCollectSink(ctx, log, SinkSveltos, ArgoClusterSource(a), []schema.GroupVersionKind{SveltosClusterGVK}, keepNamespace(namespace))
+	}
+	return nil
+}
+
+// GarbageCollect implements Sink.
+func (SveltosClusterSink) GarbageCollect(ctx context.Context, r *Capi2Argo, log logr.Logger, source client.ObjectKey) error {
+	return r.garbageCollectSink(ctx, log, SinkSveltos, source, []schema.GroupVersionKind{SveltosClusterGVK}, nil)
+}
+
+// FleetClusterSink registers clusters as Fleet Clusters of a workspace namespace,
+// the Fleet manager deploying its agent through the referenced kubeconfig secret.
+type FleetClusterSink struct {
+	Namespace string
+}
+
+// NewFleetClusterSink returns a Fleet sink for given workspace namespace, fleet-default by default.
+func NewFleetClusterSink(namespace string) FleetClusterSink {
+	if namespace == "" {
+		namespace = defaultFleetNamespace
+	}
+	return FleetClusterSink{Namespace: namespace}
+}
+
+// Name implements Sink.
+func (FleetClusterSink) Name() string { return SinkFleet }
+
+// Reconcile implements Sink.
+func (f FleetClusterSink) Reconcile(ctx context.Context, r *Capi2Argo, log logr.Logger, _ *CapiCluster, a *ArgoCluster) error {
+	kubeConfig, err := a.ConvertToKubeConfig()
+	if err != nil {
+		log.Error(err, "Failed to convert ArgoCluster to Fleet kubeconfig")
+		return err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: a.NamespacedName.Name + "-kubeconfig", Namespace: f.Namespace, Labels: SinkLabels(SinkFleet, a)},
+		Data:       map[string][]byte{"value": kubeConfig},
+	}
+	if err := r.reconcileOwnedSecret(ctx, log, SinkFleet, secret); err != nil {
+		return err
+	}
+	cluster := newSinkObject(SinkFleet, FleetClusterGVK, a.NamespacedName.Name, f.Namespace, a, map[string]interface{}{
+		"kubeConfigSecret": secret.Name,
+	})
+	if err := r.reconcileOwnedObject(ctx, log, SinkFleet, cluster); err != nil {
+		return err
+	}
+
+	if EnableGarbageCollection {
+		return r.garbageCollectSink(ctx, log, SinkFleet, ArgoClusterSource(a), []schema.GroupVersionKind{FleetClusterGVK}, keepNamespace(f.Namespace))
+	}
+	return nil
+}
+
+// GarbageCollect implements Sink.
+func (FleetClusterSink) GarbageCollect(ctx context.Context, r *Capi2Argo, log logr.Logger, source client.ObjectKey) error {
+	return r.garbageCollectSink(ctx, log, SinkFleet, source, []schema.GroupVersionKind{FleetClusterGVK}, nil)
+}
+
+// KarmadaClusterSink registers clusters as Karmada Clusters in Push mode. Karmada only
+// authenticates with bearer tokens, so clusters without one are skipped.
+type KarmadaClusterSink struct {
+	// SecretNamespace holds the Karmada cluster secrets, karmada-cluster by default.
+	SecretNamespace string
+}
+
+// NewKarmadaClusterSink returns a Karmada sink writing its secrets to given namespace.
+func NewKarmadaClusterSink(secretNamespace string) KarmadaClusterSink {
+	if secretNamespace == "" {
+		secretNamespace = defaultKarmadaSecretNamespace
+	}
+	return KarmadaClusterSink{SecretNamespace: secretNamespace}
+}
+
+// Name implements Sink.
+func (KarmadaClusterSink) Name() string { return SinkKarmada }
+
+// Reconcile implements Sink.
+func (k KarmadaClusterSink) Reconcile(ctx context.Context, r *Capi2Argo, log logr.Logger, _ *CapiCluster, a *ArgoCluster) error {
+	config, err := a.ResolvedConfig()
+	if err != nil {
+		log.Error(err, "Failed to resolve ArgoCluster config for Karmada")
+		return err
+	}
+	if config.BearerToken == "" {
+		log.Info("Skipping Karmada registration of cluster without bearer token")
+		if EnableGarbageCollection {
+			return k.GarbageCollect(ctx, r, log, ArgoClusterSource(a))
+		}
+		return nil
+	}
+	caBundle, err := b64.StdEncoding.DecodeString(config.TLSClientConfig.CaData)
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: a.NamespacedName.Name, Namespace: k.SecretNamespace, Labels: SinkLabels(SinkKarmada, a)},
+		Data:       map[string][]byte{"token": []byte(config.BearerToken)},
+	}
+	if len(caBundle) > 0 {
+		secret.Data["caBundle"] = caBundle
+	}
+	if err := r.reconcileOwnedSecret(ctx, log, SinkKarmada, secret); err != nil {
+		return err
+	}
+	spec := map[string]interface{}{
+		"apiEndpoint": a.ClusterServer,
+		"syncMode":    "Push",
+		"secretRef":   map[string]interface{}{"namespace": secret.Namespace, "name": secret.Name},
+	}
+	if config.TLSClientConfig.Insecure {
+		spec["insecureSkipTLSVerification"] = true
+	}
+	if config.ProxyURL != "" {
+		spec["proxyURL"] = config.ProxyURL
+	}
+	if err := r.reconcileOwnedObject(ctx, log, SinkKarmada, newSinkObject(SinkKarmada, KarmadaClusterGVK, a.NamespacedName.Name, "", a, spec)); err != nil {
+		return err
+	}
+
+	if EnableGarbageCollection {
+		// Karmada Clusters are cluster scoped, only their secrets may move namespace.
+		return r.garbageCollectSink(ctx, log, SinkKarmada, ArgoClusterSource(a), nil, keepNamespace(k.SecretNamespace))
+	}
+	return nil
+}
+
+// GarbageCollect implements Sink.
+func (KarmadaClusterSink) GarbageCollect(ctx context.Context, r *Capi2Argo, log logr.Logger, source client.ObjectKey) error {
+	return r.garbageCollectSink(ctx, log, SinkKarmada, source, []schema.GroupVersionKind{KarmadaClusterGVK}, nil)
+}
@@ -1,12 +1,19 @@
 package controllers
 
 import (
+	"context"
 	b64 "encoding/base64"
 	"log"
 	"os"
+	"strings"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // MockCapiKubeConfig returns a based64-encoded string that
@@ -100,8 +107,187 @@ func MockArgoSecret() *corev1.Secret {
 	return s
 }
 
+// boolPtr returns a pointer to given bool.
+func boolPtr(b bool) *bool {
+	return &b
+}
+
 // IsBase64 returns true if given value is valid b64-encoded stream
 func IsBase64(s string) bool {
 	_, err := b64.StdEncoding.DecodeString(s)
 	return err == nil
 }
+
+// MockArgoApplication returns an Argo Application or ApplicationSet whose
+// destination targets the given cluster name.
+func MockArgoApplication(kind string, destination string) *unstructured.Unstructured {
+	spec := map[string]interface{}{
+		"destination": map[string]interface{}{
+			"name":      destination,
+			"namespace": "default",
+		},
+	}
+	if kind == "ApplicationSet" {
+		spec = map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": spec,
+			},
+		}
+	}
+
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"name":      "test",
+			"namespace": "argocd",
+		},
+		"spec": spec,
+	}}
+}
+
+// mockClient serves Namespaces, ConfigMaps, Secrets and unstructured objects from memory.
+// Secrets and unstructured objects are written as well, patches of other objects being
+// recorded in patched. Deletes are recorded in deleted.
+type mockClient struct {
+	client.Client
+	namespaces map[string]*corev1.Namespace
+	configMaps map[client.ObjectKey]*corev1.ConfigMap
+	secrets    map[client.ObjectKey]*corev1.Secret
+	objects    []*unstructured.Unstructured
+	patched    []client.Object
+	deleted    []client.ObjectKey
+}
+
+func (m *mockClient) Get(_ context.Context, key client.ObjectKey, obj client.Object, _ ...client.GetOption) error {
+	switch o := obj.(type) {
+	case *corev1.Namespace:
+		if ns, ok := m.namespaces[key.Name]; ok {
+			ns.DeepCopyInto(o)
+			return nil
+		}
+	case *corev1.ConfigMap:
+		if cm, ok := m.configMaps[key]; ok {
+			cm.DeepCopyInto(o)
+			return nil
+		}
+	case *corev1.Secret:
+		if s, ok := m.secrets[key]; ok {
+			s.DeepCopyInto(o)
+			return nil
+		}
+	case *unstructured.Unstructured:
+		if i := m.objectIndex(o.GroupVersionKind(), key); i >= 0 {
+			m.objects[i].DeepCopyInto(o)
+			return nil
+		}
+	}
+	return apierrors.NewNotFound(schema.GroupResource{}, key.Name)
+}
+
+func (m *mockClient) List(_ context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := &client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	if objectList, ok := list.(*unstructured.UnstructuredList); ok {
+		kind := strings.TrimSuffix(objectList.GetKind(), "List")
+		for _, u := range m.objects {
+			if u.GroupVersionKind() != objectList.GroupVersionKind().GroupVersion().WithKind(kind) {
+				continue
+			}
+			if listOpts.Namespace != "" && u.GetNamespace() != listOpts.Namespace {
+				continue
+			}
+			if listOpts.LabelSelector != nil && !listOpts.LabelSelector.Matches(labels.Set(u.GetLabels())) {
+				continue
+			}
+			objectList.Items = append(objectList.Items, *u.DeepCopy())
+		}
+		return nil
+	}
+	secretList, ok := list.(*corev1.SecretList)
+	if !ok {
+		return nil
+	}
+	for _, s := range m.secrets {
+		if listOpts.Namespace != "" && s.Namespace != listOpts.Namespace {
+			continue
+		}
+		if listOpts.LabelSelector != nil && !listOpts.LabelSelector.Matches(labels.Set(s.Labels)) {
+			continue
+		}
+		secretList.Items = append(secretList.Items, *s.DeepCopy())
+	}
+	return nil
+}
+
+func (m *mockClient) Create(_ context.Context, obj client.Object, _ ...client.CreateOption) error {
+	key := client.ObjectKeyFromObject(obj)
+	switch o := obj.(type) {
+	case *corev1.Secret:
+		if _, ok := m.secrets[key]; ok {
+			return apierrors.NewAlreadyExists(schema.GroupResource{Resource: "secrets"}, key.Name)
+		}
+		m.storeSecret(o)
+	case *unstructured.Unstructured:
+		if m.objectIndex(o.GroupVersionKind(), key) >= 0 {
+			return apierrors.NewAlreadyExists(schema.GroupResource{Group: o.GroupVersionKind().Group, Resource: o.GetKind()}, key.Name)
+		}
+		m.objects = append(m.objects, o.DeepCopy())
+	}
+	return nil
+}
+
+func (m *mockClient) Update(_ context.Context, obj client.Object, _ ...client.UpdateOption) error {
+	switch o := obj.(type) {
+	case *corev1.Secret:
+		m.storeSecret(o)
+	case *unstructured.Unstructured:
+		if i := m.objectIndex(o.GroupVersionKind(), client.ObjectKeyFromObject(o)); i >= 0 {
+			m.objects[i] = o.DeepCopy()
+		} else {
+			m.objects = append(m.objects, o.DeepCopy())
+		}
+	}
+	return nil
+}
+
+func (m *mockClient) Patch(_ context.Context, obj client.Object, _ client.Patch, _ ...client.PatchOption) error {
+	if s, ok := obj.(*corev1.Secret); ok {
+		m.storeSecret(s)
+		return nil
+	}
+	m.patched = append(m.patched, obj.DeepCopyObject().(client.Object))
+	return nil
+}
+
+func (m *mockClient) Delete(_ context.Context, obj client.Object, _ ...client.DeleteOption) error {
+	key := client.ObjectKeyFromObject(obj)
+	m.deleted = append(m.deleted, key)
+	switch o := obj.(type) {
+	case *corev1.Secret:
+		delete(m.secrets, key)
+	case *unstructured.Unstructured:
+		if i := m.objectIndex(o.GroupVersionKind(), key); i >= 0 {
+			m.objects = append(m.objects[:i], m.objects[i+1:]...)
+		}
+	}
+	return nil
+}
+
+// storeSecret stores a copy of a secret.
+func (m *mockClient) storeSecret(s *corev1.Secret) {
+	if m.secrets == nil {
+		m.secrets = map[client.ObjectKey]*corev1.Secret{}
+	}
+	m.secrets[client.ObjectKeyFromObject(s)] = s.DeepCopy()
+}
+
+// objectIndex returns the index of an unstructured object, or -1 when missing.
+func (m *mockClient) objectIndex(gvk schema.GroupVersionKind, key client.ObjectKey) int {
+	for i, u := range m.objects {
+		if u.GroupVersionKind() == gvk && client.ObjectKeyFromObject(u) == key {
+			return i
+		}
+	}
+	return -1
+}
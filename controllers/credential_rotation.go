@@ -0,0 +1,327 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	goErr "errors"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// RotatedAtAnnotation on an ArgoSecret records when its credentials were last rotated,
+	// while the rotation is still being verified.
+	RotatedAtAnnotation = "capi-to-argocd/rotated-at"
+	// RejectedConfigAnnotation on an ArgoSecret holds the hash of credentials rolled back
+	// after failing post-rotation checks, which are not rotated to again until the
+	// rejection expires.
+	RejectedConfigAnnotation = "capi-to-argocd/rejected-config"
+	// RejectedAtAnnotation on an ArgoSecret records when credentials were rolled back.
+	RejectedAtAnnotation = "capi-to-argocd/rejected-at"
+	// BackupOwnedLabel marks secrets backing up the previous credentials of an ArgoSecret.
+	BackupOwnedLabel = "capi-to-argocd/backup-owned"
+
+	// backupSecretPrefix never starts an ArgoSecret name, as those are prefixed with cluster-.
+	backupSecretPrefix = "backup-"
+
+	defaultRotationVerifyWindow  = 10 * time.Minute
+	defaultRotationCheckInterval = time.Minute
+	defaultRotationRejectPeriod  = time.Hour
+)
+
+var (
+	// ErrNotBackupOwned is returned when a secret named as a credentials backup is not one.
+	ErrNotBackupOwned = goErr.New("secret is not a credentials backup")
+
+	// CredentialRotation enables staged rotation of ArgoSecret credentials, when set.
+	CredentialRotation *CredentialRotationConfig
+)
+
+// CredentialRotationConfig configures staged credential rotation. New credentials are
+// verified before being swapped in, the previous ones being kept in a backup secret
+// and restored when rejected by the workload cluster within the verify window.
+type CredentialRotationConfig struct {
+	// VerifyWindow is how long rotated credentials are checked for, defaulting to 10m.
+	VerifyWindow string `json:"verifyWindow,omitempty"`
+	// CheckInterval is how often rotated credentials are checked, defaulting to 1m.
+	CheckInterval string `json:"checkInterval,omitempty"`
+	// RejectPeriod is how long rolled back credentials are not rotated to again,
+	// defaulting to 1h.
+	RejectPeriod string `json:"rejectPeriod,omitempty"`
+}
+
+// GetVerifyWindow returns how long rotated credentials are checked for.
+func (c *CredentialRotationConfig) GetVerifyWindow() time.Duration {
+	if d, err := time.ParseDuration(c.VerifyWindow); err == nil && c.VerifyWindow != "" {
+		return d
+	}
+	return defaultRotationVerifyWindow
+}
+
+// GetRejectPeriod returns how long rolled back credentials are not rotated to again.
+func (c *CredentialRotationConfig) GetRejectPeriod() time.Duration {
+	if d, err := time.ParseDuration(c.RejectPeriod); err == nil && c.RejectPeriod != "" {
+		return d
+	}
+	return defaultRotationRejectPeriod
+}
+
+// GetCheckInterval returns how often rotated credentials are checked, or zero when disabled.
+func (c *CredentialRotationConfig) GetCheckInterval() time.Duration {
+	if c == nil {
+		return 0
+	}
+	if d, err := time.ParseDuration(c.CheckInterval); err == nil && c.CheckInterval != "" {
+		return d
+	}
+	return defaultRotationCheckInterval
+}
+
+// ValidateCredentialRotation validates the verify window, check interval and reject period.
+func ValidateCredentialRotation(c *CredentialRotationConfig) error {
+	if c == nil {
+		return nil
+	}
+	for _, v := range []string{c.VerifyWindow, c.CheckInterval, c.RejectPeriod} {
+		if v == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+			return fmt.Errorf("invalid duration %q", v)
+		}
+	}
+	return nil
+}
+
+// BackupSecretName returns the name of the secret backing up the credentials of an ArgoSecret.
+func BackupSecretName(argoSecret string) string {
+	return backupSecretPrefix + argoSecret
+}
+
+// IsBackupSecret reports whether a secret is a credentials backup, never an ArgoSecret.
+func IsBackupSecret(s *corev1.Secret) bool {
+	return s.Labels[BackupOwnedLabel] == "true" && s.Labels["argocd.argoproj.io/secret-type"] != "cluster"
+}
+
+// argoCredentials holds the fields of an ArgoSecret config authenticating to the cluster.
+// Other fields, such as the CA or the proxy, are not rotated.
+type argoCredentials struct {
+	BearerToken        string            `json:"bearerToken,omitempty"`
+	CertData           string            `json:"certData,omitempty"`
+	KeyData            string            `json:"keyData,omitempty"`
+	ExecProviderConfig *ArgoExecProvider `json:"execProviderConfig,omitempty"`
+}
+
+// credentialsOf returns the credentials of an ArgoSecret config.
+func credentialsOf(config ArgoConfig) argoCredentials {
+	return argoCredentials{
+		BearerToken:        config.BearerToken,
+		CertData:           config.TLSClientConfig.CertData,
+		KeyData:            config.TLSClientConfig.KeyData,
+		ExecProviderConfig: config.ExecProviderConfig,
+	}
+}
+
+// ConfigHash returns the hash ArgoSecret credentials are identified with. Only the
+// credentials of the config are hashed, unless it cannot be parsed.
+func ConfigHash(config []byte) string {
+	var parsed ArgoConfig
+	if err := json.Unmarshal(config, &parsed); err == nil {
+		if raw, err := json.Marshal(credentialsOf(parsed)); err == nil {
+			config = raw
+		}
+	}
+	sum := sha256.Sum256(config)
+	return hex.EncodeToString(sum[:])
+}
+
+// keepCredentials returns config with the credentials of from, so changes of other
+// fields go through while credentials are kept back. From is returned when either
+// config cannot be parsed.
+func keepCredentials(config, from []byte) []byte {
+	var parsed, kept ArgoConfig
+	if json.Unmarshal(config, &parsed) != nil || json.Unmarshal(from, &kept) != nil {
+		return from
+	}
+	parsed.BearerToken = kept.BearerToken
+	parsed.TLSClientConfig.CertData = kept.TLSClientConfig.CertData
+	parsed.TLSClientConfig.KeyData = kept.TLSClientConfig.KeyData
+	parsed.ExecProviderConfig = kept.ExecProviderConfig
+	raw, err := json.Marshal(parsed)
+	if err != nil {
+		return from
+	}
+	return raw
+}
+
+// StageCredentialRotation stages a change of credentials between an existing ArgoSecret
+// and the desired one, before they are synced:
+//   - Changed credentials are verified against the workload cluster first and kept
+//     back while failing, the previous ones being backed up once they pass. Changes of
+//     other config fields, such as the CA or the proxy, are not staged.
+//   - Rotated credentials are checked during the verify window and rolled back to
+//     the backup when the cluster refuses them but accepts the backup, being rejected
+//     for the reject period. Other failures, such as outages, keep them.
+//
+// Credentials kept back are restored into the desired ArgoSecret, next to its other fields.
+func (r *Capi2Argo) StageCredentialRotation(ctx context.Context, c client.Client, log logr.Logger, existing, desired *corev1.Secret, a *ArgoCluster) error {
+	if desired.Annotations == nil {
+		desired.Annotations = map[string]string{}
+	}
+	current, next := existing.Data["config"], desired.Data["config"]
+	backupKey := types.NamespacedName{Name: BackupSecretName(existing.Name), Namespace: existing.Namespace}
+
+	if hash := ConfigHash(next); hash != ConfigHash(current) {
+		if existing.Annotations[RejectedConfigAnnotation] == hash && !RejectionExpired(existing.Annotations) {
+			log.Info("Keeping credentials, rotated ones were rolled back")
+			desired.Data["config"] = keepCredentials(next, current)
+			desired.Annotations[RejectedConfigAnnotation] = hash
+			desired.Annotations[RejectedAtAnnotation] = existing.Annotations[RejectedAtAnnotation]
+			return deleteBackupSecret(ctx, c, log, backupKey)
+		}
+
+		if condition := ProbeArgoCluster(ctx, a, HealthCheck); condition.Failed() {
+			log.Info("Keeping credentials, rotated ones failed verification", "reason", condition.Reason, "message", condition.Message)
+			r.recordEvent(existing, corev1.EventTypeWarning, "RotationFailed", condition.Message)
+			desired.Data["config"] = keepCredentials(next, current)
+			return nil
+		}
+
+		backup := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      backupKey.Name,
+				Namespace: backupKey.Namespace,
+				Labels: map[string]string{
+					BackupOwnedLabel:                     "true",
+					"capi-to-argocd/cluster-secret-name": existing.Labels["capi-to-argocd/cluster-secret-name"],
+					"capi-to-argocd/cluster-namespace":   existing.Labels["capi-to-argocd/cluster-namespace"],
+				},
+			},
+			Data: map[string][]byte{"config": current},
+		}
+		if err := r.reconcileBackupSecret(ctx, c, log, backup); err != nil {
+			return err
+		}
+		log.Info("Rotating verified credentials")
+		r.recordEvent(existing, corev1.EventTypeNormal, "CredentialsRotated", "Rotated credentials passed verification")
+		desired.Annotations[RotatedAtAnnotation] = time.Now().UTC().Format(time.RFC3339)
+		return nil
+	}
+
+	// Credentials are unchanged, check them while a rotation is being verified.
+	if v, ok := existing.Annotations[RejectedConfigAnnotation]; ok && !RejectionExpired(existing.Annotations) {
+		desired.Annotations[RejectedConfigAnnotation] = v
+		desired.Annotations[RejectedAtAnnotation] = existing.Annotations[RejectedAtAnnotation]
+	}
+	rotatedAt, err := time.Parse(time.RFC3339, existing.Annotations[RotatedAtAnnotation])
+	if err != nil || time.Since(rotatedAt) > CredentialRotation.GetVerifyWindow() {
+		return deleteBackupSecret(ctx, c, log, backupKey)
+	}
+
+	var backup corev1.Secret
+	if err := c.Get(ctx, backupKey, &backup); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		log.Error(err, "Failed to fetch credentials backup")
+		return err
+	}
+	if !IsBackupSecret(&backup) {
+		log.Info("Not managed by Controller, skipping..", "backup", backupKey.Name)
+		return nil
+	}
+	desired.Annotations[RotatedAtAnnotation] = existing.Annotations[RotatedAtAnnotation]
+	condition := ProbeArgoCluster(ctx, a, HealthCheck)
+	if !condition.Failed() {
+		return nil
+	}
+	if condition.Reason != HealthReasonUnauthorized && condition.Reason != HealthReasonTLSHandshakeFailed {
+		log.Info("Keeping rotated credentials, cluster is failing regardless", "reason", condition.Reason, "message", condition.Message)
+		return nil
+	}
+
+	// Only roll back to credentials the cluster still accepts.
+	restored := keepCredentials(next, backup.Data["config"])
+	previous := *a
+	if err := json.Unmarshal(restored, &previous.ClusterConfig); err != nil {
+		log.Error(err, "Failed to parse credentials backup")
+		return nil
+	}
+	if backupCondition := ProbeArgoCluster(ctx, &previous, HealthCheck); backupCondition.Failed() {
+		log.Info("Keeping rotated credentials, backup ones failed verification", "reason", backupCondition.Reason, "message", backupCondition.Message)
+		return nil
+	}
+	log.Info("Rolling back rotated credentials", "reason", condition.Reason, "message", condition.Message)
+	r.recordEvent(existing, corev1.EventTypeWarning, "RotationRolledBack", condition.Message)
+	delete(desired.Annotations, RotatedAtAnnotation)
+	desired.Data["config"] = restored
+	desired.Annotations[RejectedConfigAnnotation] = ConfigHash(current)
+	desired.Annotations[RejectedAtAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	return nil
+}
+
+// RejectionExpired reports whether rolled back credentials may be rotated to again.
+func RejectionExpired(annotations map[string]string) bool {
+	rejectedAt, err := time.Parse(time.RFC3339, annotations[RejectedAtAnnotation])
+	return err != nil || time.Since(rejectedAt) > CredentialRotation.GetRejectPeriod()
+}
+
+// reconcileBackupSecret creates or updates the backup of ArgoSecret credentials.
+func (r *Capi2Argo) reconcileBackupSecret(ctx context.Context, c client.Client, log logr.Logger, backup *corev1.Secret) error {
+	var existing corev1.Secret
+	err := c.Get(ctx, client.ObjectKeyFromObject(backup), &existing)
+	if errors.IsNotFound(err) {
+		if err := c.Create(ctx, backup); err != nil {
+			log.Error(err, "Failed to create credentials backup")
+			return err
+		}
+		log.Info("Created new credentials backup")
+		return nil
+	}
+	if err != nil {
+		log.Error(err, "Failed to fetch credentials backup")
+		return err
+	}
+	if !IsBackupSecret(&existing) {
+		log.Info("Not managed by Controller, skipping..", "backup", existing.Name)
+		return ErrNotBackupOwned
+	}
+	existing.Labels, existing.Data = backup.Labels, backup.Data
+	if err := c.Update(ctx, &existing); err != nil {
+		log.Error(err, "Failed to update credentials backup")
+		return err
+	}
+	log.Info("Updated successfully of credentials backup")
+	return nil
+}
+
+// deleteBackupSecret deletes the backup of ArgoSecret credentials, if any.
+func deleteBackupSecret(ctx context.Context, c client.Client, log logr.Logger, key types.NamespacedName) error {
+	var backup corev1.Secret
+	if err := c.Get(ctx, key, &backup); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		log.Error(err, "Failed to fetch credentials backup")
+		return err
+	}
+	if !IsBackupSecret(&backup) {
+		log.Info("Not managed by Controller, skipping..", "backup", key.Name)
+		return nil
+	}
+	if err := c.Delete(ctx, &backup); client.IgnoreNotFound(err) != nil {
+		log.Error(err, "Failed to delete credentials backup")
+		return err
+	}
+	log.Info("Deleted successfully of credentials backup")
+	return nil
+}
@@ -0,0 +1,259 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestStageCredentialRotation(t *testing.T) {
+	oldRotation, oldHealthCheck := CredentialRotation, HealthCheck
+	defer func() { CredentialRotation, HealthCheck = oldRotation, oldHealthCheck }()
+	CredentialRotation, HealthCheck = &CredentialRotationConfig{}, nil
+
+	server := mockHealthCheckServer()
+	defer server.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	previous, err := mockProbedArgoCluster(server.URL, ca, "previous").ConvertToSecret()
+	assert.Nil(t, err)
+	backupKey := types.NamespacedName{Name: BackupSecretName(previous.Name), Namespace: previous.Namespace}
+	r := &Capi2Argo{Log: logr.Discard()}
+
+	tests := []struct {
+		testName   string
+		token      string
+		existing   map[string]string
+		backup     bool
+		wantConfig string
+		wantBackup bool
+		wantAnno   []string
+	}{
+		{"test verified rotation", "tester", nil, false, "next", true, []string{RotatedAtAnnotation}},
+		{"test failed verification", "wrong", nil, false, "existing", false, nil},
+		{"test rejected rotation", "tester", map[string]string{RejectedConfigAnnotation: "next", RejectedAtAnnotation: time.Now().UTC().Format(time.RFC3339)},
+			true, "existing", false, []string{RejectedConfigAnnotation}},
+		{"test expired rejection", "tester", map[string]string{RejectedConfigAnnotation: "next", RejectedAtAnnotation: time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)},
+			false, "next", true, []string{RotatedAtAnnotation}},
+		{"test verified rotation in window", "", map[string]string{RotatedAtAnnotation: time.Now().UTC().Format(time.RFC3339)}, true, "existing", true, []string{RotatedAtAnnotation}},
+		{"test expired verify window", "", map[string]string{RotatedAtAnnotation: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)}, true, "existing", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			a := mockProbedArgoCluster(server.URL, ca, "tester")
+			if tt.token != "" {
+				a = mockProbedArgoCluster(server.URL, ca, tt.token)
+			}
+			desired, err := a.ConvertToSecret()
+			assert.Nil(t, err)
+
+			existing := previous.DeepCopy()
+			if tt.token == "" {
+				existing.Data["config"] = desired.Data["config"]
+			}
+			configs := map[string][]byte{"existing": existing.Data["config"], "next": desired.Data["config"]}
+			existing.Annotations = map[string]string{}
+			for key, value := range tt.existing {
+				if key == RejectedConfigAnnotation {
+					value = ConfigHash(configs[value])
+				}
+				existing.Annotations[key] = value
+			}
+
+			c := &mockClient{secrets: map[types.NamespacedName]*corev1.Secret{}}
+			if tt.backup {
+				c.secrets[backupKey] = &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: backupKey.Name, Namespace: backupKey.Namespace, Labels: map[string]string{BackupOwnedLabel: "true"}},
+					Data:       map[string][]byte{"config": previous.Data["config"]},
+				}
+			}
+
+			assert.Nil(t, r.StageCredentialRotation(context.Background(), c, logr.Discard(), existing, desired, a))
+			assert.Equal(t, configs[tt.wantConfig], desired.Data["config"])
+			_, hasBackup := c.secrets[backupKey]
+			assert.Equal(t, tt.wantBackup, hasBackup)
+			for _, key := range []string{RotatedAtAnnotation, RejectedConfigAnnotation} {
+				_, ok := desired.Annotations[key]
+				assert.Equal(t, containsString(tt.wantAnno, key), ok, key)
+			}
+		})
+	}
+}
+
+func TestStageCredentialRotationNonCredentialChange(t *testing.T) {
+	oldRotation, oldHealthCheck := CredentialRotation, HealthCheck
+	defer func() { CredentialRotation, HealthCheck = oldRotation, oldHealthCheck }()
+	CredentialRotation, HealthCheck = &CredentialRotationConfig{}, nil
+
+	server := mockHealthCheckServer()
+	defer server.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	existing, err := mockProbedArgoCluster(server.URL, ca, "tester").ConvertToSecret()
+	assert.Nil(t, err)
+	backupKey := types.NamespacedName{Name: BackupSecretName(existing.Name), Namespace: existing.Namespace}
+	r := &Capi2Argo{Log: logr.Discard()}
+
+	// A proxy change is synced as is, without staging a rotation.
+	a := mockProbedArgoCluster(server.URL, ca, "tester")
+	a.ClusterConfig.ProxyURL = "http://proxy.local:3128"
+	desired, err := a.ConvertToSecret()
+	assert.Nil(t, err)
+	next := desired.Data["config"]
+	c := &mockClient{secrets: map[types.NamespacedName]*corev1.Secret{}}
+	assert.Nil(t, r.StageCredentialRotation(context.Background(), c, logr.Discard(), existing.DeepCopy(), desired, a))
+	assert.Equal(t, next, desired.Data["config"])
+	assert.NotContains(t, c.secrets, backupKey)
+	assert.NotContains(t, desired.Annotations, RotatedAtAnnotation)
+
+	// Credentials failing verification are kept back, the proxy change going through.
+	a = mockProbedArgoCluster(server.URL, ca, "wrong")
+	a.ClusterConfig.ProxyURL = "http://proxy.local:3128"
+	desired, err = a.ConvertToSecret()
+	assert.Nil(t, err)
+	assert.Nil(t, r.StageCredentialRotation(context.Background(), c, logr.Discard(), existing.DeepCopy(), desired, a))
+	var config ArgoConfig
+	assert.Nil(t, json.Unmarshal(desired.Data["config"], &config))
+	assert.Equal(t, "tester", config.BearerToken)
+	assert.Equal(t, "http://proxy.local:3128", config.ProxyURL)
+}
+
+func TestStageCredentialRotationRollback(t *testing.T) {
+	oldRotation, oldHealthCheck := CredentialRotation, HealthCheck
+	defer func() { CredentialRotation, HealthCheck = oldRotation, oldHealthCheck }()
+	CredentialRotation, HealthCheck = &CredentialRotationConfig{VerifyWindow: "5m"}, nil
+
+	server := mockHealthCheckServer()
+	defer server.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	outage := mockHealthCheckServer()
+	outage.Close()
+
+	tests := []struct {
+		testName     string
+		server       string
+		backupToken  string
+		wantRollback bool
+	}{
+		// Rotated credentials got revoked after the swap.
+		{"test revoked credentials", server.URL, "tester", true},
+		{"test revoked credentials with failing backup", server.URL, "wrong", false},
+		{"test cluster outage", outage.URL, "tester", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			a := mockProbedArgoCluster(tt.server, ca, "revoked")
+			desired, err := a.ConvertToSecret()
+			assert.Nil(t, err)
+			existing := desired.DeepCopy()
+			existing.Annotations = map[string]string{RotatedAtAnnotation: time.Now().UTC().Format(time.RFC3339)}
+
+			previous, err := mockProbedArgoCluster(server.URL, ca, tt.backupToken).ConvertToSecret()
+			assert.Nil(t, err)
+			backupKey := types.NamespacedName{Name: BackupSecretName(existing.Name), Namespace: existing.Namespace}
+			c := &mockClient{secrets: map[types.NamespacedName]*corev1.Secret{
+				backupKey: {
+					ObjectMeta: metav1.ObjectMeta{Name: backupKey.Name, Namespace: backupKey.Namespace, Labels: map[string]string{BackupOwnedLabel: "true"}},
+					Data:       map[string][]byte{"config": previous.Data["config"]},
+				},
+			}}
+			r := &Capi2Argo{Log: logr.Discard()}
+
+			assert.Nil(t, r.StageCredentialRotation(context.Background(), c, logr.Discard(), existing, desired, a))
+			if !tt.wantRollback {
+				assert.Equal(t, existing.Data["config"], desired.Data["config"])
+				assert.NotContains(t, desired.Annotations, RejectedConfigAnnotation)
+				assert.Equal(t, existing.Annotations[RotatedAtAnnotation], desired.Annotations[RotatedAtAnnotation])
+				return
+			}
+			assert.Equal(t, previous.Data["config"], desired.Data["config"])
+			assert.Equal(t, ConfigHash(existing.Data["config"]), desired.Annotations[RejectedConfigAnnotation])
+			assert.Contains(t, desired.Annotations, RejectedAtAnnotation)
+			assert.NotContains(t, desired.Annotations, RotatedAtAnnotation)
+		})
+	}
+}
+
+func TestStageCredentialRotationBackupCollision(t *testing.T) {
+	oldRotation, oldHealthCheck := CredentialRotation, HealthCheck
+	defer func() { CredentialRotation, HealthCheck = oldRotation, oldHealthCheck }()
+	CredentialRotation, HealthCheck = &CredentialRotationConfig{}, nil
+
+	server := mockHealthCheckServer()
+	defer server.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	// Clusters foo and foo-backup are registered side by side.
+	foo := mockProbedArgoCluster(server.URL, ca, "tester")
+	foo.NamespacedName.Name = "cluster-foo"
+	existing, err := foo.ConvertToSecret()
+	assert.Nil(t, err)
+	fooBackup := existing.DeepCopy()
+	fooBackup.Name = "cluster-foo-backup"
+	assert.NotEqual(t, fooBackup.Name, BackupSecretName(existing.Name))
+
+	r := &Capi2Argo{Log: logr.Discard()}
+	ctx := context.Background()
+
+	tests := []struct {
+		testName  string
+		rotatedAt string
+	}{
+		{"test expired verify window", time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)},
+		{"test rotation in window", time.Now().UTC().Format(time.RFC3339)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			c := &mockClient{secrets: map[types.NamespacedName]*corev1.Secret{
+				client.ObjectKeyFromObject(fooBackup): fooBackup.DeepCopy(),
+			}}
+			current := existing.DeepCopy()
+			current.Annotations = map[string]string{RotatedAtAnnotation: tt.rotatedAt}
+			desired := existing.DeepCopy()
+
+			assert.Nil(t, r.StageCredentialRotation(ctx, c, logr.Discard(), current, desired, foo))
+			assert.Equal(t, fooBackup, c.secrets[client.ObjectKeyFromObject(fooBackup)])
+		})
+	}
+
+	// ArgoSecrets are never touched under a backup name.
+	backupKey := types.NamespacedName{Name: BackupSecretName(existing.Name), Namespace: existing.Namespace}
+	argoSecret := fooBackup.DeepCopy()
+	argoSecret.Name, argoSecret.Labels[BackupOwnedLabel] = backupKey.Name, "true"
+	c := &mockClient{secrets: map[types.NamespacedName]*corev1.Secret{backupKey: argoSecret.DeepCopy()}}
+	assert.Nil(t, deleteBackupSecret(ctx, c, logr.Discard(), backupKey))
+	backup := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: backupKey.Name, Namespace: backupKey.Namespace, Labels: map[string]string{BackupOwnedLabel: "true"}},
+		Data:       map[string][]byte{"config": []byte("{}")},
+	}
+	assert.ErrorIs(t, r.reconcileBackupSecret(ctx, c, logr.Discard(), backup), ErrNotBackupOwned)
+	assert.Equal(t, argoSecret, c.secrets[backupKey])
+}
+
+func TestValidateCredentialRotation(t *testing.T) {
+	t.Parallel()
+	assert.Nil(t, ValidateCredentialRotation(nil))
+	assert.Nil(t, ValidateCredentialRotation(&CredentialRotationConfig{VerifyWindow: "15m", CheckInterval: "30s", RejectPeriod: "2h"}))
+	assert.NotNil(t, ValidateCredentialRotation(&CredentialRotationConfig{VerifyWindow: "soon"}))
+	assert.NotNil(t, ValidateCredentialRotation(&CredentialRotationConfig{CheckInterval: "-1m"}))
+	assert.NotNil(t, ValidateCredentialRotation(&CredentialRotationConfig{RejectPeriod: "later"}))
+}
+
+func TestRequeueInterval(t *testing.T) {
+	oldFacts, oldRotation := ClusterFactsDiscovery, CredentialRotation
+	defer func() { ClusterFactsDiscovery, CredentialRotation = oldFacts, oldRotation }()
+
+	ClusterFactsDiscovery, CredentialRotation = nil, nil
+	assert.Equal(t, time.Duration(0), RequeueInterval())
+	ClusterFactsDiscovery = &ClusterFactsConfig{Interval: "5m"}
+	assert.Equal(t, 5*time.Minute, RequeueInterval())
+	CredentialRotation = &CredentialRotationConfig{}
+	assert.Equal(t, time.Minute, RequeueInterval())
+}
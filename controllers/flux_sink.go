@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	// FluxSink configures the Flux sink, enabling it when set.
+	FluxSink *FluxSinkConfig
+)
+
+// FluxKustomizationGVK represents the Flux Kustomization kind.
+var FluxKustomizationGVK = schema.GroupVersionKind{Group: "kustomize.toolkit.fluxcd.io", Version: "v1", Kind: "Kustomization"}
+
+// FluxHelmReleaseGVK represents the Flux HelmRelease kind.
+var FluxHelmReleaseGVK = schema.GroupVersionKind{Group: "helm.toolkit.fluxcd.io", Version: "v2", Kind: "HelmRelease"}
+
+// FluxSinkConfig describes how clusters are published to Flux.
+type FluxSinkConfig struct {
+	// Namespaces the kubeconfig secrets are written to. Empty means the namespace of the cluster.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Kustomization is the spec of a Kustomization bootstrapping each cluster, when set.
+	Kustomization map[string]interface{} `json:"kustomization,omitempty"`
+	// HelmRelease is the spec of a HelmRelease bootstrapping each cluster, when set.
+	HelmRelease map[string]interface{} `json:"helmRelease,omitempty"`
+}
+
+// TargetNamespaces returns the namespaces a cluster of given namespace is published to.
+func (f *FluxSinkConfig) TargetNamespaces(clusterNamespace string) []string {
+	if len(f.Namespaces) == 0 {
+		return []string{clusterNamespace}
+	}
+	return f.Namespaces
+}
+
+// fluxBootstrapKinds are the kinds of the Flux bootstraps.
+var fluxBootstrapKinds = []schema.GroupVersionKind{FluxKustomizationGVK, FluxHelmReleaseGVK}
+
+// bootstraps returns the configured bootstrap specs by kind.
+func (f *FluxSinkConfig) bootstraps() map[schema.GroupVersionKind]map[string]interface{} {
+	return map[schema.GroupVersionKind]map[string]interface{}{
+		FluxKustomizationGVK: f.Kustomization,
+		FluxHelmReleaseGVK:   f.HelmRelease,
+	}
+}
+
+// ConvertToKubeConfig converts an ArgoCluster into a kubeconfig equivalent to its ArgoSecret.
+func (a *ArgoCluster) ConvertToKubeConfig() ([]byte, error) {
+	config, err := a.ResolvedConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	cluster := clientcmdapi.NewCluster()
+	cluster.Server = a.ClusterServer
+	cluster.ProxyURL = config.ProxyURL
+	cluster.TLSServerName = config.TLSClientConfig.ServerName
+	cluster.InsecureSkipTLSVerify = config.TLSClientConfig.Insecure
+
+	authInfo := clientcmdapi.NewAuthInfo()
+	authInfo.Token = config.BearerToken
+	for _, field := range []struct {
+		value string
+		into  *[]byte
+	}{
+		{config.TLSClientConfig.CaData, &cluster.CertificateAuthorityData},
+		{config.TLSClientConfig.CertData, &authInfo.ClientCertificateData},
+		{config.TLSClientConfig.KeyData, &authInfo.ClientKeyData},
+	} {
+		if field.value == "" {
+			continue
+		}
+		if *field.into, err = b64.StdEncoding.DecodeString(field.value); err != nil {
+			return nil, err
+		}
+	}
+	if e := config.ExecProviderConfig; e != nil {
+		authInfo.Exec = &clientcmdapi.ExecConfig{
+			Command:         e.Command,
+			Args:            e.Args,
+			APIVersion:      e.APIVersion,
+			InstallHint:     e.InstallHint,
+			InteractiveMode: clientcmdapi.NeverExecInteractiveMode,
+		}
+		for name, value := range e.Env {
+			authInfo.Exec.Env = append(authInfo.Exec.Env, clientcmdapi.ExecEnvVar{Name: name, Value: value})
+		}
+	}
+
+	kubeConfig := clientcmdapi.NewConfig()
+	kubeConfig.Clusters[a.ClusterName] = cluster
+	kubeConfig.AuthInfos[a.ClusterName] = authInfo
+	kubeConfig.Contexts[a.ClusterName] = &clientcmdapi.Context{Cluster: a.ClusterName, AuthInfo: a.ClusterName}
+	kubeConfig.CurrentContext = a.ClusterName
+	return clientcmd.Write(*kubeConfig)
+}
+
+// ExpandPlaceholders deep copies a template, expanding placeholders such as {{namespace}}
+// and {{cluster}} in its string values.
+func ExpandPlaceholders(spec interface{}, replacer *strings.Replacer) interface{} {
+	switch v := spec.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			out[key] = ExpandPlaceholders(value, replacer)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, value := range v {
+			out[i] = ExpandPlaceholders(value, replacer)
+		}
+		return out
+	case string:
+		return replacer.Replace(v)
+	default:
+		return v
+	}
+}
+
+// FluxSecretSink publishes clusters as Flux kubeconfig secrets, optionally bootstrapped
+// by a Kustomization or HelmRelease, into every target namespace.
+type FluxSecretSink struct{}
+
+// Name implements Sink.
+func (FluxSecretSink) Name() string { return SinkFlux }
+
+// Reconcile implements Sink.
+func (FluxSecretSink) Reconcile(ctx context.Context, r *Capi2Argo, log logr.Logger, c *CapiCluster, a *ArgoCluster) error {
+	kubeConfig, err := a.ConvertToKubeConfig()
+	if err != nil {
+		log.Error(err, "Failed to convert ArgoCluster to Flux kubeconfig")
+		return err
+	}
+
+	namespaces := FluxSink.TargetNamespaces(c.Namespace)
+	replacer := strings.NewReplacer("{{namespace}}", c.Namespace, "{{cluster}}", a.ClusterName)
+	bootstraps := FluxSink.bootstraps()
+	for _, namespace := range namespaces {
+		desired := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: a.NamespacedName.Name, Namespace: namespace, Labels: SinkLabels(SinkFlux, a)},
+			Data:       map[string][]byte{"value": kubeConfig},
+		}
+		if err := r.reconcileOwnedSecret(ctx, log, SinkFlux, desired); err != nil {
+			return err
+		}
+
+		for gvk, spec := range bootstraps {
+			if spec == nil {
+				continue
+			}
+			desiredSpec := ExpandPlaceholders(spec, replacer).(map[string]interface{})
+			desiredSpec["kubeConfig"] = map[string]interface{}{
+				"secretRef": map[string]interface{}{"name": desired.Name, "key": "value"},
+			}
+			bootstrap := &unstructured.Unstructured{Object: map[string]interface{}{"spec": desiredSpec}}
+			bootstrap.SetGroupVersionKind(gvk)
+			bootstrap.SetName(desired.Name)
+			bootstrap.SetNamespace(namespace)
+			bootstrap.SetLabels(desired.Labels)
+			if err := r.reconcileOwnedObject(ctx, log, SinkFlux, bootstrap); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !EnableGarbageCollection {
+		return nil
+	}
+	// Keep secrets and configured bootstraps of the target namespaces.
+	return r.garbageCollectSink(ctx, log, SinkFlux, ArgoClusterSource(a), fluxBootstrapKinds, func(obj client.Object) bool {
+		if !containsString(namespaces, obj.GetNamespace()) {
+			return false
+		}
+		u, ok := obj.(*unstructured.Unstructured)
+		return !ok || bootstraps[u.GroupVersionKind()] != nil
+	})
+}
+
+// GarbageCollect implements Sink.
+func (FluxSecretSink) GarbageCollect(ctx context.Context, r *Capi2Argo, log logr.Logger, source client.ObjectKey) error {
+	return r.garbageCollectSink(ctx, log, SinkFlux, source, fluxBootstrapKinds, nil)
+}
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	b64 "encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func TestConvertToKubeConfig(t *testing.T) {
+	t.Parallel()
+	a := MockArgoCluster(true)
+	a.ClusterConfig.ProxyURL = "http://proxy:3128"
+	a.ClusterConfig.TLSClientConfig.ServerName = "api.internal"
+
+	raw, err := a.ConvertToKubeConfig()
+	assert.Nil(t, err)
+	kubeConfig, err := clientcmd.Load(raw)
+	assert.Nil(t, err)
+	assert.Equal(t, "test", kubeConfig.CurrentContext)
+
+	cluster := kubeConfig.Clusters["test"]
+	assert.Equal(t, "server", cluster.Server)
+	assert.Equal(t, "http://proxy:3128", cluster.ProxyURL)
+	assert.Equal(t, "api.internal", cluster.TLSServerName)
+	assert.Equal(t, []byte("tester"), cluster.CertificateAuthorityData)
+	assert.Equal(t, []byte("tester"), kubeConfig.AuthInfos["test"].ClientCertificateData)
+	assert.Equal(t, []byte("tester"), kubeConfig.AuthInfos["test"].ClientKeyData)
+
+	a.ClusterConfig.TLSClientConfig.CaData = "tester"
+	_, err = a.ConvertToKubeConfig()
+	assert.NotNil(t, err)
+}
+
+func TestConvertToKubeConfigExtraCaData(t *testing.T) {
+	t.Parallel()
+	a := MockArgoCluster(true)
+	a.ClusterExtraCaData = b64.StdEncoding.EncodeToString([]byte("extra"))
+
+	raw, err := a.ConvertToKubeConfig()
+	assert.Nil(t, err)
+	kubeConfig, err := clientcmd.Load(raw)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("tester\nextra"), kubeConfig.Clusters["test"].CertificateAuthorityData)
+}
+
+func TestExpandPlaceholders(t *testing.T) {
+	t.Parallel()
+	spec := map[string]interface{}{
+		"path":     "./clusters/{{namespace}}/{{cluster}}",
+		"prune":    true,
+		"patches":  []interface{}{map[string]interface{}{"patch": "{{cluster}}"}},
+		"interval": "10m",
+	}
+	replacer := strings.NewReplacer("{{namespace}}", "tenant", "{{cluster}}", "test")
+
+	expanded := ExpandPlaceholders(spec, replacer)
+	assert.Equal(t, map[string]interface{}{
+		"path":     "./clusters/tenant/test",
+		"prune":    true,
+		"patches":  []interface{}{map[string]interface{}{"patch": "test"}},
+		"interval": "10m",
+	}, expanded)
+	assert.Equal(t, "./clusters/{{namespace}}/{{cluster}}", spec["path"])
+}
+
+func TestFluxSinkTargetNamespaces(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, []string{"tenant"}, (&FluxSinkConfig{}).TargetNamespaces("tenant"))
+	assert.Equal(t, []string{"flux-system"}, (&FluxSinkConfig{Namespaces: []string{"flux-system"}}).TargetNamespaces("tenant"))
+}
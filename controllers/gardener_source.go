@@ -0,0 +1,309 @@
+package controllers
+
+import (
+	"context"
+	b64 "encoding/base64"
+	goErr "errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// PurposeLabel on an ArgoSecret holds the purpose of the cluster, eg. production.
+	PurposeLabel = "capi-to-argocd/purpose"
+
+	// gardenerSourceSuffix names the virtual kubeconfig secret of a Shoot, which ArgoSecrets
+	// are labeled with. Dots never show up in CAPI kubeconfig secret names.
+	gardenerSourceSuffix = ".adminkubeconfig"
+
+	// minGardenerKubeConfigExpiration is the shortest expiration accepted by Gardener.
+	minGardenerKubeConfigExpiration = 10 * time.Minute
+)
+
+var (
+	// EnableGardenerSource enables registering Gardener Shoots.
+	EnableGardenerSource bool
+
+	// GardenerKubeConfigExpiration is the lifetime of requested Shoot admin kubeconfigs.
+	GardenerKubeConfigExpiration = 24 * time.Hour
+)
+
+// GardenerShootGVK represents the Gardener Shoot kind.
+var GardenerShootGVK = schema.GroupVersionKind{Group: "core.gardener.cloud", Version: "v1beta1", Kind: "Shoot"}
+
+// GardenerAdminKubeConfigRequestGVK represents the body of the shoots/adminkubeconfig subresource.
+var GardenerAdminKubeConfigRequestGVK = schema.GroupVersionKind{Group: "authentication.gardener.cloud", Version: "v1alpha1", Kind: "AdminKubeconfigRequest"}
+
+// ValidateGardenerKubeConfigExpiration validates the lifetime of requested admin kubeconfigs.
+func ValidateGardenerKubeConfigExpiration(d time.Duration) error {
+	if d < minGardenerKubeConfigExpiration {
+		return fmt.Errorf("expiration must be at least %s", minGardenerKubeConfigExpiration)
+	}
+	return nil
+}
+
+// ShootSourceName returns the name ArgoSecrets of a Shoot are labeled with.
+func ShootSourceName(shoot string) string {
+	return shoot + gardenerSourceSuffix
+}
+
+// ShootReady reports whether a Shoot finished its creation and is not being deleted.
+func ShootReady(shoot *unstructured.Unstructured) bool {
+	if shoot.GetDeletionTimestamp() != nil {
+		return false
+	}
+	opType, found, _ := unstructured.NestedString(shoot.Object, "status", "lastOperation", "type")
+	if !found {
+		return false
+	}
+	state, _, _ := unstructured.NestedString(shoot.Object, "status", "lastOperation", "state")
+	return opType != "Create" || state == "Succeeded"
+}
+
+// ShootMetadata describes the cluster of a Shoot, mapping its purpose, region and
+// provider to Argo labels.
+func ShootMetadata(shoot *unstructured.Unstructured) ClusterMetadata {
+	metadata := ClusterMetadata{Labels: shoot.GetLabels(), Annotations: shoot.GetAnnotations(), ArgoLabels: map[string]string{}}
+	for label, path := range map[string][]string{
+		PurposeLabel:  {"spec", "purpose"},
+		RegionLabel:   {"spec", "region"},
+		PlatformLabel: {"spec", "provider", "type"},
+	} {
+		if v, _, _ := unstructured.NestedString(shoot.Object, path...); v != "" {
+			metadata.ArgoLabels[label] = v
+		}
+	}
+	return metadata
+}
+
+// ParseAdminKubeConfigRequest returns the kubeconfig and expiration of an answered
+// AdminKubeconfigRequest.
+func ParseAdminKubeConfigRequest(req *unstructured.Unstructured) ([]byte, time.Time, error) {
+	encoded, _, _ := unstructured.NestedString(req.Object, "status", "kubeconfig")
+	kubeConfig, err := b64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(kubeConfig) == 0 {
+		return nil, time.Time{}, goErr.New("missing kubeconfig in AdminKubeconfigRequest status")
+	}
+	expiration, _, _ := unstructured.NestedString(req.Object, "status", "expirationTimestamp")
+	expiresAt, err := time.Parse(time.RFC3339, expiration)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid expirationTimestamp in AdminKubeconfigRequest status: %w", err)
+	}
+	return kubeConfig, expiresAt, nil
+}
+
+// KubeConfigRefreshAt returns when a kubeconfig issued at given time should be
+// refreshed, leaving a fifth of its lifetime as safety margin.
+func KubeConfigRefreshAt(issuedAt, expiresAt time.Time) time.Time {
+	return issuedAt.Add(expiresAt.Sub(issuedAt) * 4 / 5)
+}
+
+// shootCredentials caches the admin kubeconfig of a Shoot until it has to be refreshed.
+type shootCredentials struct {
+	uid        types.UID
+	kubeConfig []byte
+	refreshAt  time.Time
+}
+
+// GardenerShoots reconciles Gardener Shoots into ArgoSecrets, using time-limited
+// admin kubeconfigs requested through the shoots/adminkubeconfig subresource.
+type GardenerShoots struct {
+	*Capi2Argo
+
+	mu          sync.Mutex
+	credentials map[types.NamespacedName]shootCredentials
+
+	// caBundles tracks the CA bundles referenced by annotation, by Shoot.
+	caBundles caBundleTracker
+}
+
+// +kubebuilder:rbac:groups=core.gardener.cloud,resources=shoots,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=core.gardener.cloud,resources=shoots/adminkubeconfig,verbs=create
+
+// Reconcile registers a ready Shoot into Argo and requeues it before its kubeconfig expires.
+func (r *GardenerShoots) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("shoot", req.NamespacedName)
+	source := types.NamespacedName{Name: ShootSourceName(req.Name), Namespace: req.Namespace}
+
+	shoot := &unstructured.Unstructured{}
+	shoot.SetGroupVersionKind(GardenerShootGVK)
+	if err := r.Get(ctx, req.NamespacedName, shoot); err != nil {
+		if client.IgnoreNotFound(err) != nil {
+			log.Error(err, "Failed to fetch Shoot")
+			return ctrl.Result{}, err
+		}
+		log.Info("Shoot deleted")
+		return ctrl.Result{}, r.deregister(ctx, log, req.NamespacedName, source)
+	}
+
+	if shoot.GetDeletionTimestamp() != nil {
+		log.Info("Shoot is being deleted")
+		return ctrl.Result{}, r.deregister(ctx, log, req.NamespacedName, source)
+	}
+	if !ShootReady(shoot) {
+		log.Info("Shoot is not ready to be registered yet, skipping..")
+		return ctrl.Result{}, nil
+	}
+
+	creds, err := r.shootCredentials(ctx, log, shoot)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	capiCluster := NewCapiCluster(shoot.GetName(), shoot.GetNamespace())
+	metadata := ShootMetadata(shoot)
+	capiCluster.Labels, capiCluster.Annotations = metadata.Labels, metadata.Annotations
+	r.caBundles.Track(req.NamespacedName, capiCluster)
+	if err := capiCluster.UnmarshalKubeConfig(creds.kubeConfig); err != nil {
+		log.Error(err, "Failed to unmarshal Shoot admin kubeconfig")
+		return ctrl.Result{}, err
+	}
+
+	err = r.registerCluster(ctx, log, capiCluster, source, shoot, "gardener", metadata)
+	if result, ok := heldBackResult(err); ok {
+		return result, nil
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// Refresh credentials before they expire, or the cluster when due earlier.
+	requeueAfter := time.Until(creds.refreshAt)
+	if interval := RequeueInterval(); interval > 0 && interval < requeueAfter {
+		requeueAfter = interval
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// shootCredentials returns the cached admin kubeconfig of a Shoot, requesting a new
+// one when none is cached or the cached one is due for refresh.
+func (r *GardenerShoots) shootCredentials(ctx context.Context, log logr.Logger, shoot *unstructured.Unstructured) (shootCredentials, error) {
+	key := client.ObjectKeyFromObject(shoot)
+	r.mu.Lock()
+	creds, ok := r.credentials[key]
+	r.mu.Unlock()
+	if ok && creds.uid == shoot.GetUID() && time.Now().Before(creds.refreshAt) {
+		return creds, nil
+	}
+
+	req := &unstructured.Unstructured{}
+	req.SetGroupVersionKind(GardenerAdminKubeConfigRequestGVK)
+	if err := unstructured.SetNestedField(req.Object, int64(GardenerKubeConfigExpiration.Seconds()), "spec", "expirationSeconds"); err != nil {
+		return shootCredentials{}, err
+	}
+	issuedAt := time.Now()
+	if err := r.SubResource("adminkubeconfig").Create(ctx, shoot, req); err != nil {
+		log.Error(err, "Failed to request Shoot admin kubeconfig")
+		return shootCredentials{}, err
+	}
+	kubeConfig, expiresAt, err := ParseAdminKubeConfigRequest(req)
+	if err != nil {
+		log.Error(err, "Failed to request Shoot admin kubeconfig")
+		return shootCredentials{}, err
+	}
+	log.Info("Requested Shoot admin kubeconfig", "expiresAt", expiresAt)
+
+	creds = shootCredentials{uid: shoot.GetUID(), kubeConfig: kubeConfig, refreshAt: KubeConfigRefreshAt(issuedAt, expiresAt)}
+	r.mu.Lock()
+	if r.credentials == nil {
+		r.credentials = map[types.NamespacedName]shootCredentials{}
+	}
+	r.credentials[key] = creds
+	r.mu.Unlock()
+	return creds, nil
+}
+
+// deregister forgets the credentials of a Shoot and deletes its ArgoSecrets and sink objects.
+func (r *GardenerShoots) deregister(ctx context.Context, log logr.Logger, shoot, source types.NamespacedName) error {
+	r.mu.Lock()
+	delete(r.credentials, shoot)
+	r.mu.Unlock()
+	r.caBundles.Forget(shoot)
+
+	if EnableGarbageCollection {
+		return r.GarbageCollectSinks(ctx, log, source)
+	}
+	return nil
+}
+
+// NamespaceRequests returns the reconcile requests of every Shoot in a project namespace.
+func (r *GardenerShoots) NamespaceRequests(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.shootRequests(ctx, client.InNamespace(obj.GetName()))
+}
+
+// CABundleRequests maps a changed ConfigMap or Secret to the Shoots trusting it. Every
+// Shoot trusts the global bundle, while bundles referenced by annotation are tracked once
+// their Shoots are registered.
+func (r *GardenerShoots) CABundleRequests(kind string) func(context.Context, client.Object) []reconcile.Request {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		key := client.ObjectKeyFromObject(obj)
+		if GlobalCABundle == nil || !GlobalCABundle.Refers(kind, key) {
+			return r.caBundles.Users(kind, key)
+		}
+		return r.shootRequests(ctx)
+	}
+}
+
+// shootRequests returns the reconcile requests of every Shoot matching given options.
+func (r *GardenerShoots) shootRequests(ctx context.Context, opts ...client.ListOption) []reconcile.Request {
+	shoots := &unstructured.UnstructuredList{}
+	shoots.SetGroupVersionKind(GardenerShootGVK.GroupVersion().WithKind(GardenerShootGVK.Kind + "List"))
+	if err := r.List(ctx, shoots, opts...); err != nil {
+		r.Log.Error(err, "Failed to list Shoots")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(shoots.Items))
+	for i := range shoots.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&shoots.Items[i])})
+	}
+	return requests
+}
+
+// SetupWithManager ..
+func (r *GardenerShoots) SetupWithManager(mgr ctrl.Manager) error {
+	shoot := &unstructured.Unstructured{}
+	shoot.SetGroupVersionKind(GardenerShootGVK)
+	b := ctrl.NewControllerManagedBy(mgr).
+		Named("gardenershoots").
+		For(shoot)
+
+	// Refresh Shoots trusting a CA bundle once it changes, only watching the kinds in use.
+	for _, bundle := range []struct {
+		kind string
+		obj  client.Object
+	}{{CABundleKindConfigMap, &corev1.ConfigMap{}}, {CABundleKindSecret, &corev1.Secret{}}} {
+		if !CABundleWatched(bundle.kind) {
+			continue
+		}
+		b = b.Watches(bundle.obj, handler.EnqueueRequestsFromMapFunc(r.CABundleRequests(bundle.kind)),
+			builder.WithPredicates(r.caBundles.Predicate(bundle.kind)))
+	}
+
+	// Register Shoots once approved on their project namespace, and refresh their inherited
+	// labels once the namespace labels change.
+	var namespacePredicates []predicate.Predicate
+	if len(ApprovalRules) > 0 {
+		namespacePredicates = append(namespacePredicates, predicate.AnnotationChangedPredicate{})
+	}
+	if NamespaceLabels != nil {
+		namespacePredicates = append(namespacePredicates, predicate.LabelChangedPredicate{})
+	}
+	if len(namespacePredicates) > 0 {
+		b = b.Watches(&corev1.Namespace{}, handler.EnqueueRequestsFromMapFunc(r.NamespaceRequests),
+			builder.WithPredicates(predicate.Or(namespacePredicates...)))
+	}
+	return b.Complete(r)
+}
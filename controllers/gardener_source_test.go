@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// MockGardenerShoot returns a production Shoot on AWS whose last operation is given.
+func MockGardenerShoot(opType, state string) *unstructured.Unstructured {
+	shoot := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"purpose":  "production",
+			"region":   "eu-west-1",
+			"provider": map[string]interface{}{"type": "aws"},
+		},
+	}}
+	if opType != "" {
+		shoot.Object["status"] = map[string]interface{}{
+			"lastOperation": map[string]interface{}{"type": opType, "state": state},
+		}
+	}
+	shoot.SetGroupVersionKind(GardenerShootGVK)
+	shoot.SetName("test")
+	shoot.SetNamespace("garden-test")
+	shoot.SetLabels(map[string]string{"env": "prod"})
+	return shoot
+}
+
+func TestShootReady(t *testing.T) {
+	t.Parallel()
+	deleting := MockGardenerShoot("Reconcile", "Succeeded")
+	deleting.SetDeletionTimestamp(&metav1.Time{Time: time.Now()})
+
+	tests := []struct {
+		testName string
+		shoot    *unstructured.Unstructured
+		expected bool
+	}{
+		{"test no last operation", MockGardenerShoot("", ""), false},
+		{"test creating", MockGardenerShoot("Create", "Processing"), false},
+		{"test created", MockGardenerShoot("Create", "Succeeded"), true},
+		{"test reconciling", MockGardenerShoot("Reconcile", "Processing"), true},
+		{"test deleting", deleting, false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.expected, ShootReady(tt.shoot))
+		})
+	}
+}
+
+func TestShootMetadata(t *testing.T) {
+	t.Parallel()
+	metadata := ShootMetadata(MockGardenerShoot("Create", "Succeeded"))
+	assert.Equal(t, map[string]string{"env": "prod"}, metadata.Labels)
+	assert.Equal(t, map[string]string{
+		PurposeLabel:  "production",
+		RegionLabel:   "eu-west-1",
+		PlatformLabel: "aws",
+	}, metadata.ArgoLabels)
+}
+
+func TestParseAdminKubeConfigRequest(t *testing.T) {
+	t.Parallel()
+	expiresAt := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		testName   string
+		status     map[string]interface{}
+		kubeConfig []byte
+		expectErr  bool
+	}{
+		{"test answered request", map[string]interface{}{
+			"kubeconfig":          b64.StdEncoding.EncodeToString([]byte("kubeconfig")),
+			"expirationTimestamp": expiresAt.Format(time.RFC3339),
+		}, []byte("kubeconfig"), false},
+		{"test missing kubeconfig", map[string]interface{}{
+			"expirationTimestamp": expiresAt.Format(time.RFC3339),
+		}, nil, true},
+		{"test missing expiration", map[string]interface{}{
+			"kubeconfig": b64.StdEncoding.EncodeToString([]byte("kubeconfig")),
+		}, nil, true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			req := &unstructured.Unstructured{Object: map[string]interface{}{"status": tt.status}}
+			kubeConfig, expiration, err := ParseAdminKubeConfigRequest(req)
+			if tt.expectErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.kubeConfig, kubeConfig)
+			assert.True(t, expiresAt.Equal(expiration))
+		})
+	}
+}
+
+func TestKubeConfigRefreshAt(t *testing.T) {
+	t.Parallel()
+	issuedAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	refreshAt := KubeConfigRefreshAt(issuedAt, issuedAt.Add(10*time.Hour))
+	assert.Equal(t, issuedAt.Add(8*time.Hour), refreshAt)
+}
+
+func TestValidateGardenerKubeConfigExpiration(t *testing.T) {
+	t.Parallel()
+	assert.Nil(t, ValidateGardenerKubeConfigExpiration(24*time.Hour))
+	assert.NotNil(t, ValidateGardenerKubeConfigExpiration(time.Minute))
+}
+
+func TestShootCABundleRequests(t *testing.T) {
+	oldEnabled, oldGlobal := EnableCABundleAnnotation, GlobalCABundle
+	defer func() { EnableCABundleAnnotation, GlobalCABundle = oldEnabled, oldGlobal }()
+	EnableCABundleAnnotation, GlobalCABundle = true, nil
+
+	c := NewCapiCluster("test", "garden-test")
+	c.Annotations = map[string]string{CABundleAnnotation: "secret/corp-ca"}
+	shoot := client.ObjectKey{Name: "test", Namespace: "garden-test"}
+	bundle := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "corp-ca", Namespace: "garden-test"}}
+
+	// Shoots are tracked apart from kubeconfig secrets, by Shoot.
+	r := &GardenerShoots{Capi2Argo: &Capi2Argo{}}
+	r.caBundles.Track(shoot, c)
+	assert.Equal(t, []reconcile.Request{{NamespacedName: shoot}}, r.CABundleRequests(CABundleKindSecret)(context.Background(), bundle))
+	assert.Empty(t, r.Capi2Argo.CABundleRequests(CABundleKindSecret)(context.Background(), bundle))
+
+	r.caBundles.Forget(shoot)
+	assert.Empty(t, r.CABundleRequests(CABundleKindSecret)(context.Background(), bundle))
+}
@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	goErr "errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// HealthAnnotation on an ArgoSecret holds the reason of the last health check, Healthy when it passed.
+	HealthAnnotation = "capi-to-argocd/health"
+	// HealthConditionAnnotation on an ArgoSecret holds the last health check as a JSON encoded condition.
+	HealthConditionAnnotation = "capi-to-argocd/health-condition"
+
+	// HealthPolicyRegister registers clusters failing the health check anyway.
+	HealthPolicyRegister = "register"
+	// HealthPolicyHoldBack skips registering or updating clusters failing the health check.
+	HealthPolicyHoldBack = "holdBack"
+
+	// HealthConditionType is the type of health check conditions.
+	HealthConditionType = "Reachable"
+
+	// Health check reasons.
+	HealthReasonHealthy            = "Healthy"
+	HealthReasonInvalidConfig      = "InvalidConfig"
+	HealthReasonUnreachable        = "Unreachable"
+	HealthReasonTLSHandshakeFailed = "TLSHandshakeFailed"
+	HealthReasonUnauthorized       = "Unauthorized"
+	HealthReasonForbidden          = "Forbidden"
+	HealthReasonFailed             = "Failed"
+	// HealthReasonUnsupported is reported for clusters that are not probed, such as the
+	// ones relying on exec based credentials.
+	HealthReasonUnsupported = "Unsupported"
+
+	defaultHealthCheckTimeout    = 10 * time.Second
+	defaultHealthCheckRetryAfter = time.Minute
+)
+
+var (
+	// HealthCheck enables probing clusters before registering or updating them, when set.
+	HealthCheck *HealthCheckConfig
+
+	// ErrClusterUnhealthy is returned for clusters held back as they failed the health check.
+	ErrClusterUnhealthy = goErr.New("cluster failed health check")
+)
+
+// HealthCheckConfig configures the probe run against workload clusters.
+type HealthCheckConfig struct {
+	// Policy is register or holdBack, defaulting to register.
+	Policy string `json:"policy,omitempty"`
+	// Timeout of the probe, defaulting to 10s.
+	Timeout string `json:"timeout,omitempty"`
+	// RetryAfter is how long held back clusters wait for another probe, defaulting to 1m.
+	RetryAfter string `json:"retryAfter,omitempty"`
+	// AccessReview is checked with a SelfSubjectAccessReview after the /version call, when set.
+	AccessReview *authorizationv1.ResourceAttributes `json:"accessReview,omitempty"`
+}
+
+// GetPolicy returns the policy applied to clusters failing the health check.
+func (h *HealthCheckConfig) GetPolicy() string {
+	if h == nil || h.Policy == "" {
+		return HealthPolicyRegister
+	}
+	return h.Policy
+}
+
+// GetTimeout returns the timeout of the probe.
+func (h *HealthCheckConfig) GetTimeout() time.Duration {
+	if h == nil {
+		return defaultHealthCheckTimeout
+	}
+	if d, err := time.ParseDuration(h.Timeout); err == nil && h.Timeout != "" {
+		return d
+	}
+	return defaultHealthCheckTimeout
+}
+
+// GetRetryAfter returns how long held back clusters wait for another probe.
+func (h *HealthCheckConfig) GetRetryAfter() time.Duration {
+	if h == nil {
+		return 0
+	}
+	if d, err := time.ParseDuration(h.RetryAfter); err == nil && h.RetryAfter != "" {
+		return d
+	}
+	return defaultHealthCheckRetryAfter
+}
+
+// ValidateHealthCheck validates the health check policy, durations and access review.
+func ValidateHealthCheck(h *HealthCheckConfig) error {
+	if h == nil {
+		return nil
+	}
+	switch h.Policy {
+	case "", HealthPolicyRegister, HealthPolicyHoldBack:
+	default:
+		return fmt.Errorf("unknown policy %q", h.Policy)
+	}
+	for _, v := range []string{h.Timeout, h.RetryAfter} {
+		if v == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+			return fmt.Errorf("invalid duration %q", v)
+		}
+	}
+	if h.AccessReview != nil && (h.AccessReview.Verb == "" || h.AccessReview.Resource == "") {
+		return goErr.New("accessReview requires verb and resource")
+	}
+	return nil
+}
+
+// HealthCondition describes the result of a health check.
+type HealthCondition struct {
+	Type    string                 `json:"type"`
+	Status  corev1.ConditionStatus `json:"status"`
+	Reason  string                 `json:"reason"`
+	Message string                 `json:"message,omitempty"`
+}
+
+// Healthy reports whether the health check passed.
+func (c HealthCondition) Healthy() bool {
+	return c.Status == corev1.ConditionTrue
+}
+
+// Failed reports whether the health check failed, skipped checks being neither healthy nor failed.
+func (c HealthCondition) Failed() bool {
+	return c.Status == corev1.ConditionFalse
+}
+
+// Annotations returns the ArgoSecret annotations describing the condition.
+func (c HealthCondition) Annotations() map[string]string {
+	raw, _ := json.Marshal(c)
+	return map[string]string{
+		HealthAnnotation:          c.Reason,
+		HealthConditionAnnotation: string(raw),
+	}
+}
+
+// newHealthCondition returns a condition for a health check result.
+func newHealthCondition(reason string, err error) HealthCondition {
+	c := HealthCondition{Type: HealthConditionType, Status: corev1.ConditionTrue, Reason: reason}
+	if err != nil {
+		c.Status, c.Message = corev1.ConditionFalse, err.Error()
+	}
+	return c
+}
+
+// ProbeArgoCluster checks that a cluster is reachable with the credentials of its
+// ArgoSecret: the TLS handshake and an authenticated /version call must succeed, as
+// well as the SelfSubjectAccessReview when configured. A nil config uses the defaults.
+// Clusters relying on exec based credentials are not probed.
+func ProbeArgoCluster(ctx context.Context, a *ArgoCluster, h *HealthCheckConfig) HealthCondition {
+	config, err := a.RESTConfig()
+	if goErr.Is(err, ErrExecProviderUnsupported) {
+		return HealthCondition{Type: HealthConditionType, Status: corev1.ConditionUnknown, Reason: HealthReasonUnsupported, Message: err.Error()}
+	}
+	if err != nil {
+		return newHealthCondition(HealthReasonInvalidConfig, err)
+	}
+	config.Timeout = h.GetTimeout()
+
+	dc, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return newHealthCondition(HealthReasonInvalidConfig, err)
+	}
+	if _, err := dc.ServerVersion(); err != nil {
+		return newHealthCondition(HealthFailureReason(err), err)
+	}
+
+	if h != nil && h.AccessReview != nil {
+		if err := reviewAccess(ctx, config, h.AccessReview); err != nil {
+			return newHealthCondition(HealthFailureReason(err), err)
+		}
+	}
+	return newHealthCondition(HealthReasonHealthy, nil)
+}
+
+// reviewAccess checks that the credentials are allowed the given access.
+func reviewAccess(ctx context.Context, config *rest.Config, attributes *authorizationv1.ResourceAttributes) error {
+	c, err := client.New(config, client.Options{Scheme: scheme.Scheme})
+	if err != nil {
+		return err
+	}
+	review := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: attributes.DeepCopy()},
+	}
+	if err := c.Create(ctx, review); err != nil {
+		return err
+	}
+	if !review.Status.Allowed {
+		return errors.NewForbidden(schema.GroupResource{Group: attributes.Group, Resource: attributes.Resource}, attributes.Name,
+			fmt.Errorf("%s is not allowed: %s", attributes.Verb, review.Status.Reason))
+	}
+	return nil
+}
+
+// HealthFailureReason classifies a failed health check.
+func HealthFailureReason(err error) string {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var netErr net.Error
+	switch {
+	case goErr.As(err, &unknownAuthority), goErr.As(err, &hostname), goErr.As(err, &invalid):
+		return HealthReasonTLSHandshakeFailed
+	// Client certificates rejected by the server surface as TLS alerts.
+	case strings.Contains(err.Error(), "remote error: tls:"):
+		return HealthReasonTLSHandshakeFailed
+	case errors.IsUnauthorized(err):
+		return HealthReasonUnauthorized
+	case errors.IsForbidden(err):
+		return HealthReasonForbidden
+	case goErr.As(err, &netErr):
+		return HealthReasonUnreachable
+	}
+	return HealthReasonFailed
+}
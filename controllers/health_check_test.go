@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	b64 "encoding/base64"
+	"encoding/pem"
+	goErr "errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// mockHealthCheckServer serves /version, answering 401 to requests without the test token.
+func mockHealthCheckServer() *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer tester" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Unauthorized","code":401}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"major":"1","minor":"29","gitVersion":"v1.29.2"}`))
+	}))
+}
+
+// mockProbedArgoCluster returns an ArgoCluster authenticating with a bearer token
+// against a server trusting given CA.
+func mockProbedArgoCluster(server string, ca []byte, token string) *ArgoCluster {
+	a := MockArgoCluster(true)
+	a.ClusterServer = server
+	a.ClusterConfig = ArgoConfig{
+		BearerToken:     token,
+		TLSClientConfig: ArgoTLS{CaData: b64.StdEncoding.EncodeToString(ca)},
+	}
+	return a
+}
+
+// mockCACertificate returns a PEM encoded self-signed CA, which httptest servers are not signed by.
+func mockCACertificate(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw})
+}
+
+func TestProbeArgoCluster(t *testing.T) {
+	t.Parallel()
+	server := mockHealthCheckServer()
+	// Subtests run in parallel, outliving the test function.
+	t.Cleanup(server.Close)
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	otherCA := mockCACertificate(t)
+	other := httptest.NewTLSServer(http.NotFoundHandler())
+	other.Close()
+
+	tests := []struct {
+		testName string
+		testMock *ArgoCluster
+		reason   string
+	}{
+		{"test healthy cluster", mockProbedArgoCluster(server.URL, ca, "tester"), HealthReasonHealthy},
+		{"test unknown certificate", mockProbedArgoCluster(server.URL, otherCA, "tester"), HealthReasonTLSHandshakeFailed},
+		{"test rejected token", mockProbedArgoCluster(server.URL, ca, "wrong"), HealthReasonUnauthorized},
+		{"test unreachable cluster", mockProbedArgoCluster(other.URL, ca, "tester"), HealthReasonUnreachable},
+		{"test invalid config", MockArgoCluster(false), HealthReasonInvalidConfig},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			condition := ProbeArgoCluster(context.Background(), tt.testMock, &HealthCheckConfig{Timeout: "5s"})
+			assert.Equal(t, tt.reason, condition.Reason)
+			assert.Equal(t, tt.reason == HealthReasonHealthy, condition.Healthy())
+			assert.Equal(t, HealthConditionType, condition.Type)
+		})
+	}
+}
+
+func TestHealthFailureReason(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, HealthReasonForbidden, HealthFailureReason(errors.NewForbidden(schema.GroupResource{Resource: "namespaces"}, "", goErr.New("denied"))))
+	assert.Equal(t, HealthReasonTLSHandshakeFailed, HealthFailureReason(goErr.New("remote error: tls: bad certificate")))
+	assert.Equal(t, HealthReasonFailed, HealthFailureReason(goErr.New("unexpected")))
+}
+
+func TestHealthConditionAnnotations(t *testing.T) {
+	t.Parallel()
+	condition := newHealthCondition(HealthReasonUnauthorized, goErr.New("Unauthorized"))
+	assert.False(t, condition.Healthy())
+	assert.Equal(t, map[string]string{
+		HealthAnnotation:          HealthReasonUnauthorized,
+		HealthConditionAnnotation: `{"type":"Reachable","status":"False","reason":"Unauthorized","message":"Unauthorized"}`,
+	}, condition.Annotations())
+}
+
+func TestValidateHealthCheck(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		testName          string
+		testMock          *HealthCheckConfig
+		testExpectedError bool
+	}{
+		{"test disabled", nil, false},
+		{"test defaults", &HealthCheckConfig{}, false},
+		{"test hold back", &HealthCheckConfig{Policy: HealthPolicyHoldBack, Timeout: "5s", RetryAfter: "30s"}, false},
+		{"test access review", &HealthCheckConfig{AccessReview: &authorizationv1.ResourceAttributes{Verb: "list", Resource: "namespaces"}}, false},
+		{"test unknown policy", &HealthCheckConfig{Policy: "ignore"}, true},
+		{"test invalid timeout", &HealthCheckConfig{Timeout: "soon"}, true},
+		{"test incomplete access review", &HealthCheckConfig{AccessReview: &authorizationv1.ResourceAttributes{Verb: "list"}}, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateHealthCheck(tt.testMock)
+			if !tt.testExpectedError {
+				assert.Nil(t, err)
+			} else {
+				assert.NotNil(t, err)
+			}
+		})
+	}
+}
+
+func TestProbeArgoClusterExecProvider(t *testing.T) {
+	t.Parallel()
+	server := mockHealthCheckServer()
+	t.Cleanup(server.Close)
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	marker := filepath.Join(t.TempDir(), "executed")
+	a := mockProbedArgoCluster(server.URL, ca, "")
+	a.ClusterConfig.ExecProviderConfig = &ArgoExecProvider{Command: "touch", Args: []string{marker}, APIVersion: "client.authentication.k8s.io/v1beta1"}
+
+	condition := ProbeArgoCluster(context.Background(), a, &HealthCheckConfig{Timeout: "5s"})
+	assert.Equal(t, HealthReasonUnsupported, condition.Reason)
+	assert.False(t, condition.Healthy())
+	assert.False(t, condition.Failed())
+	_, err := os.Stat(marker)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// PlatformLabel on an ArgoSecret holds the cloud platform of the cluster.
+	PlatformLabel = "capi-to-argocd/platform"
+	// RegionLabel on an ArgoSecret holds the cloud region of the cluster.
+	RegionLabel = "capi-to-argocd/region"
+
+	hiveClusterDeploymentLabel = "hive.openshift.io/cluster-deployment-name"
+	hiveSecretTypeLabel        = "hive.openshift.io/secret-type"
+	hiveAdminKubeConfigSuffix  = "-admin-kubeconfig"
+)
+
+var (
+	// EnableHiveSource enables registering OpenShift Hive ClusterDeployments.
+	EnableHiveSource bool
+)
+
+// HiveClusterDeploymentGVK represents the Hive ClusterDeployment kind.
+var HiveClusterDeploymentGVK = schema.GroupVersionKind{Group: "hive.openshift.io", Version: "v1", Kind: "ClusterDeployment"}
+
+// HiveSourceAdapter sources the admin kubeconfig secrets of installed Hive ClusterDeployments.
+type HiveSourceAdapter struct{}
+
+// Name implements SourceAdapter.
+func (HiveSourceAdapter) Name() string { return "hive" }
+
+// ParseSecretName implements SourceAdapter. Hive suffixes admin kubeconfig secrets
+// with a random string, so the cluster name is only a guess here.
+func (HiveSourceAdapter) ParseSecretName(name string) (string, bool) {
+	if !strings.HasSuffix(name, hiveAdminKubeConfigSuffix) {
+		return "", false
+	}
+	return strings.TrimSuffix(name, hiveAdminKubeConfigSuffix), true
+}
+
+// Validate implements SourceAdapter.
+func (HiveSourceAdapter) Validate(s *corev1.Secret) error {
+	if s.Labels[hiveClusterDeploymentLabel] == "" || s.Labels[hiveSecretTypeLabel] != "kubeconfig" {
+		return errors.New("not a Hive admin kubeconfig secret")
+	}
+	if _, ok := s.Data["kubeconfig"]; !ok {
+		return errors.New("wrong secret key")
+	}
+	return nil
+}
+
+// ClusterName implements SourceAdapter.
+func (HiveSourceAdapter) ClusterName(s *corev1.Secret) string {
+	return s.Labels[hiveClusterDeploymentLabel]
+}
+
+// ClusterSecrets implements SourceAdapter.
+func (HiveSourceAdapter) ClusterSecrets(string, map[string]string) []string {
+	return nil
+}
+
+// KubeConfig implements SourceAdapter.
+func (HiveSourceAdapter) KubeConfig(s *corev1.Secret) []byte {
+	return s.Data["kubeconfig"]
+}
+
+// FetchMetadata implements SourceAdapter. ClusterDeployments are only ready once installed.
+func (HiveSourceAdapter) FetchMetadata(ctx context.Context, r client.Reader, namespace, cluster string) (ClusterMetadata, error) {
+	cd, err := fetchOwner(ctx, r, HiveClusterDeploymentGVK, types.NamespacedName{Name: cluster, Namespace: namespace})
+	if err != nil {
+		return ClusterMetadata{}, err
+	}
+	if cd == nil {
+		return ClusterMetadata{}, ErrClusterNotReady
+	}
+	if installed, _, _ := unstructured.NestedBool(cd.Object, "spec", "installed"); !installed {
+		return ClusterMetadata{}, ErrClusterNotReady
+	}
+
+	metadata := ClusterMetadata{Labels: cd.GetLabels(), Annotations: cd.GetAnnotations(), ArgoLabels: map[string]string{}}
+	platform, region := HivePlatform(cd)
+	if platform != "" {
+		metadata.ArgoLabels[PlatformLabel] = platform
+	}
+	if region != "" {
+		metadata.ArgoLabels[RegionLabel] = region
+	}
+	return metadata, nil
+}
+
+// HivePlatform returns the platform and region set on a ClusterDeployment spec.
+func HivePlatform(cd *unstructured.Unstructured) (string, string) {
+	platforms, _, _ := unstructured.NestedMap(cd.Object, "spec", "platform")
+	names := make([]string, 0, len(platforms))
+	for name := range platforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		spec, ok := platforms[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		region, _ := spec["region"].(string)
+		return name, region
+	}
+	return "", ""
+}
+
+// HiveClusterDeploymentRequests maps a ClusterDeployment to its admin kubeconfig secret.
+func HiveClusterDeploymentRequests(_ context.Context, obj client.Object) []reconcile.Request {
+	cd, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil
+	}
+	name, _, _ := unstructured.NestedString(cd.Object, "spec", "clusterMetadata", "adminKubeconfigSecretRef", "name")
+	if name == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name, Namespace: cd.GetNamespace()}}}
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// MockHiveClusterDeployment returns a ClusterDeployment on AWS referencing its admin kubeconfig secret.
+func MockHiveClusterDeployment(name string, installed bool) *unstructured.Unstructured {
+	cd := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"installed": installed,
+			"platform": map[string]interface{}{
+				"aws": map[string]interface{}{"region": "eu-west-1"},
+			},
+			"clusterMetadata": map[string]interface{}{
+				"adminKubeconfigSecretRef": map[string]interface{}{"name": name + "-x7k2p-admin-kubeconfig"},
+			},
+		},
+	}}
+	cd.SetGroupVersionKind(HiveClusterDeploymentGVK)
+	cd.SetName(name)
+	cd.SetNamespace("test")
+	cd.SetLabels(map[string]string{"env": "prod"})
+	return cd
+}
+
+func TestHiveSourceAdapter(t *testing.T) {
+	t.Parallel()
+	adapter := HiveSourceAdapter{}
+	s := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-x7k2p-admin-kubeconfig", Namespace: "test", Labels: map[string]string{
+			hiveClusterDeploymentLabel: "test",
+			hiveSecretTypeLabel:        "kubeconfig",
+		}},
+		Data: map[string][]byte{"kubeconfig": []byte("kubeconfig")},
+	}
+
+	_, ok := adapter.ParseSecretName(s.Name)
+	assert.True(t, ok)
+	assert.Nil(t, adapter.Validate(s))
+	assert.Equal(t, "test", adapter.ClusterName(s))
+	assert.Equal(t, []byte("kubeconfig"), adapter.KubeConfig(s))
+
+	delete(s.Labels, hiveSecretTypeLabel)
+	assert.NotNil(t, adapter.Validate(s))
+}
+
+func TestHiveSourceAdapterFetchMetadata(t *testing.T) {
+	t.Parallel()
+	r := &mockClient{objects: []*unstructured.Unstructured{
+		MockHiveClusterDeployment("installed", true),
+		MockHiveClusterDeployment("installing", false),
+	}}
+	adapter := HiveSourceAdapter{}
+
+	metadata, err := adapter.FetchMetadata(context.Background(), r, "test", "installed")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"env": "prod"}, metadata.Labels)
+	assert.Equal(t, map[string]string{PlatformLabel: "aws", RegionLabel: "eu-west-1"}, metadata.ArgoLabels)
+
+	_, err = adapter.FetchMetadata(context.Background(), r, "test", "installing")
+	assert.ErrorIs(t, err, ErrClusterNotReady)
+
+	_, err = adapter.FetchMetadata(context.Background(), r, "test", "missing")
+	assert.ErrorIs(t, err, ErrClusterNotReady)
+}
+
+func TestHiveClusterDeploymentRequests(t *testing.T) {
+	t.Parallel()
+	requests := HiveClusterDeploymentRequests(context.Background(), MockHiveClusterDeployment("test", true))
+	assert.Len(t, requests, 1)
+	assert.Equal(t, types.NamespacedName{Name: "test-x7k2p-admin-kubeconfig", Namespace: "test"}, requests[0].NamespacedName)
+
+	cd := MockHiveClusterDeployment("test", false)
+	unstructured.RemoveNestedField(cd.Object, "spec", "clusterMetadata")
+	assert.Empty(t, HiveClusterDeploymentRequests(context.Background(), cd))
+}
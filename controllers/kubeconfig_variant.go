@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// KubeConfigVariantAdmin sources the <cluster>-kubeconfig secret.
+	KubeConfigVariantAdmin = "admin"
+	// KubeConfigVariantUser sources the <cluster>-user-kubeconfig secret written
+	// by managed control planes (eg. CAPA EKS).
+	KubeConfigVariantUser = "user"
+
+	// KubeConfigVariantAnnotation on a CAPI Cluster selects the kubeconfig variant
+	// to source, taking precedence over KubeConfigVariant.
+	KubeConfigVariantAnnotation = "capi-to-argocd/kubeconfig-variant"
+
+	// CapiClusterNameLabel is set by CAPI on kubeconfig secrets.
+	CapiClusterNameLabel = "cluster.x-k8s.io/cluster-name"
+
+	adminKubeConfigSuffix = "-kubeconfig"
+	userKubeConfigSuffix  = "-user-kubeconfig"
+)
+
+var (
+	// KubeConfigVariant is the kubeconfig variant sourced by default.
+	KubeConfigVariant string
+)
+
+// ValidateKubeConfigVariant validates a kubeconfig variant.
+func ValidateKubeConfigVariant(variant string) error {
+	switch variant {
+	case KubeConfigVariantAdmin, KubeConfigVariantUser:
+		return nil
+	}
+	return fmt.Errorf("unknown variant %q", variant)
+}
+
+// ParseCapiSecretName returns the cluster name and kubeconfig variant of a CapiSecret.
+// The CAPI cluster name label wins over the secret name suffix, as a cluster
+// named <name>-user would otherwise be mistaken for the user variant of <name>.
+func ParseCapiSecretName(s *corev1.Secret) (string, string) {
+	if cluster := s.Labels[CapiClusterNameLabel]; cluster != "" {
+		if s.Name == cluster+userKubeConfigSuffix {
+			return cluster, KubeConfigVariantUser
+		}
+		return cluster, KubeConfigVariantAdmin
+	}
+	if strings.HasSuffix(s.Name, userKubeConfigSuffix) {
+		return strings.TrimSuffix(s.Name, userKubeConfigSuffix), KubeConfigVariantUser
+	}
+	return strings.TrimSuffix(s.Name, adminKubeConfigSuffix), KubeConfigVariantAdmin
+}
+
+// KubeConfigSecretName returns the name of the CapiSecret holding given kubeconfig variant.
+func KubeConfigSecretName(cluster, variant string) string {
+	if variant == KubeConfigVariantUser {
+		return cluster + userKubeConfigSuffix
+	}
+	return cluster + adminKubeConfigSuffix
+}
+
+// ResolveKubeConfigVariant returns the kubeconfig variant to source for a cluster.
+// Invalid annotations are ignored.
+func ResolveKubeConfigVariant(clusterAnnotations map[string]string) string {
+	if variant := clusterAnnotations[KubeConfigVariantAnnotation]; ValidateKubeConfigVariant(variant) == nil {
+		return variant
+	}
+	return KubeConfigVariant
+}
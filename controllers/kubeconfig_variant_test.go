@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestParseCapiSecretName(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		testName            string
+		testSecretName      string
+		testClusterLabel    string
+		testExpectedCluster string
+		testExpectedVariant string
+	}{
+		{"test admin kubeconfig", "test-kubeconfig", "", "test", KubeConfigVariantAdmin},
+		{"test user kubeconfig", "test-user-kubeconfig", "", "test", KubeConfigVariantUser},
+		{"test user kubeconfig with label", "test-user-kubeconfig", "test", "test", KubeConfigVariantUser},
+		{"test admin kubeconfig of cluster named user", "test-user-kubeconfig", "test-user", "test-user", KubeConfigVariantAdmin},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: tt.testSecretName}}
+			if tt.testClusterLabel != "" {
+				s.Labels = map[string]string{CapiClusterNameLabel: tt.testClusterLabel}
+			}
+			cluster, variant := ParseCapiSecretName(s)
+			assert.Equal(t, tt.testExpectedCluster, cluster)
+			assert.Equal(t, tt.testExpectedVariant, variant)
+			assert.Equal(t, tt.testSecretName, KubeConfigSecretName(cluster, variant))
+		})
+	}
+}
+
+func TestResolveKubeConfigVariant(t *testing.T) {
+	oldVariant := KubeConfigVariant
+	defer func() { KubeConfigVariant = oldVariant }()
+	KubeConfigVariant = KubeConfigVariantAdmin
+
+	assert.Equal(t, KubeConfigVariantAdmin, ResolveKubeConfigVariant(nil))
+	assert.Equal(t, KubeConfigVariantUser, ResolveKubeConfigVariant(map[string]string{KubeConfigVariantAnnotation: "user"}))
+	assert.Equal(t, KubeConfigVariantAdmin, ResolveKubeConfigVariant(map[string]string{KubeConfigVariantAnnotation: "root"}))
+}
+
+func TestNewArgoClusterUserKubeConfig(t *testing.T) {
+	t.Parallel()
+	raw, err := os.ReadFile("../tests/capi-user-kubeconfig.yaml")
+	assert.Nil(t, err)
+
+	s := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-user-kubeconfig", Namespace: "test"},
+		Type:       CapiClusterSecretType,
+		Data:       map[string][]byte{"value": raw},
+	}
+	name, _ := ParseCapiSecretName(s)
+	c := NewCapiCluster(name, s.Namespace)
+	assert.Nil(t, c.Unmarshal(s))
+
+	a := NewArgoCluster(c, types.NamespacedName{Name: s.Name, Namespace: s.Namespace})
+	assert.Equal(t, "cluster-test", a.NamespacedName.Name)
+	assert.Equal(t, "test-user-kubeconfig", a.ClusterLabels["capi-to-argocd/cluster-secret-name"])
+	assert.Equal(t, &ArgoExecProvider{
+		Command:    "aws",
+		Args:       []string{"eks", "get-token", "--cluster-name", "kube-cluster-test"},
+		Env:        map[string]string{"AWS_REGION": "eu-west-1"},
+		APIVersion: "client.authentication.k8s.io/v1beta1",
+	}, a.ClusterConfig.ExecProviderConfig)
+
+	argoSecret, err := a.ConvertToSecret()
+	assert.Nil(t, err)
+	assert.NotContains(t, string(argoSecret.Data["config"]), "certData")
+}
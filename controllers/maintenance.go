@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// MaintenanceLabel is the default marker set on ArgoSecrets of clusters under maintenance.
+const MaintenanceLabel = "capi-to-argocd/maintenance"
+
+var (
+	// Maintenance enables marking ArgoSecrets of clusters under maintenance, when set.
+	Maintenance *MaintenanceConfig
+
+	// CapiMachineDeploymentGVK represents the CAPI MachineDeployment kind rolling out workers.
+	CapiMachineDeploymentGVK = schema.GroupVersionKind{Group: "cluster.x-k8s.io", Version: "v1beta1", Kind: "MachineDeployment"}
+	// KubeadmControlPlaneGVK represents the control plane kind watched for rollouts, when installed.
+	KubeadmControlPlaneGVK = schema.GroupVersionKind{Group: "controlplane.cluster.x-k8s.io", Version: "v1beta1", Kind: "KubeadmControlPlane"}
+
+	defaultMaintenancePhases = []string{"Provisioning", "Deleting"}
+)
+
+// MaintenanceConfig configures how clusters under maintenance are detected and marked.
+// A cluster is under maintenance while its control plane or one of its MachineDeployments
+// is rolling out, or while its phase is one of Phases.
+type MaintenanceConfig struct {
+	// Label set to "true" on ArgoSecrets of clusters under maintenance. It defaults to
+	// capi-to-argocd/maintenance when no annotation is set either.
+	Label string `json:"label,omitempty"`
+	// Annotation set to the maintenance reason on ArgoSecrets of clusters under maintenance.
+	Annotation string `json:"annotation,omitempty"`
+	// Phases of the CAPI Cluster considered as maintenance, defaulting to Provisioning and Deleting.
+	Phases []string `json:"phases,omitempty"`
+}
+
+// GetLabel returns the label marking clusters under maintenance, if any.
+func (m *MaintenanceConfig) GetLabel() string {
+	if m == nil {
+		return ""
+	}
+	if m.Label == "" && m.Annotation == "" {
+		return MaintenanceLabel
+	}
+	return m.Label
+}
+
+// GetPhases returns the phases of the CAPI Cluster considered as maintenance.
+func (m *MaintenanceConfig) GetPhases() []string {
+	if m == nil || len(m.Phases) == 0 {
+		return defaultMaintenancePhases
+	}
+	return m.Phases
+}
+
+// ValidateMaintenance validates the label and annotation marking clusters under maintenance.
+func ValidateMaintenance(m *MaintenanceConfig) error {
+	if m == nil {
+		return nil
+	}
+	for _, key := range []string{m.Label, m.Annotation} {
+		if key == "" {
+			continue
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid key %q: %s", key, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// MaintenanceMetadata returns the ArgoSecret labels and annotations marking a cluster
+// under maintenance for the given reason.
+func (m *MaintenanceConfig) MaintenanceMetadata(reason string) (map[string]string, map[string]string) {
+	labels, annotations := map[string]string{}, map[string]string{}
+	if label := m.GetLabel(); label != "" {
+		labels[label] = "true"
+	}
+	if m.Annotation != "" {
+		annotations[m.Annotation] = reason
+	}
+	return labels, annotations
+}
+
+// ClusterMaintenance returns why a CAPI Cluster is under maintenance, or an empty
+// reason when it is not. Missing control planes or CRDs are not treated as errors.
+func ClusterMaintenance(ctx context.Context, r client.Reader, c *unstructured.Unstructured, phases []string) (string, error) {
+	phase, _, _ := unstructured.NestedString(c.Object, "status", "phase")
+	if containsString(phases, phase) {
+		return fmt.Sprintf("Cluster is %s", phase), nil
+	}
+
+	cp, err := fetchControlPlane(ctx, r, c)
+	if err != nil {
+		return "", err
+	}
+	if cp != nil && RolloutInProgress(cp) {
+		return fmt.Sprintf("%s %s is rolling out", cp.GetKind(), cp.GetName()), nil
+	}
+
+	mds, err := listMachineDeployments(ctx, r, c)
+	if err != nil {
+		return "", err
+	}
+	for i := range mds {
+		if RolloutInProgress(&mds[i]) {
+			return fmt.Sprintf("%s %s is rolling out", CapiMachineDeploymentGVK.Kind, mds[i].GetName()), nil
+		}
+	}
+	return "", nil
+}
+
+// fetchControlPlane returns the control plane referenced by a CAPI Cluster, or nil when
+// the Cluster references none or the control plane or its CRD is missing.
+func fetchControlPlane(ctx context.Context, r client.Reader, c *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	ref, _, _ := unstructured.NestedStringMap(c.Object, "spec", "controlPlaneRef")
+	if ref["kind"] == "" || ref["name"] == "" {
+		return nil, nil
+	}
+	gv, err := schema.ParseGroupVersion(ref["apiVersion"])
+	if err != nil {
+		return nil, err
+	}
+	namespace := ref["namespace"]
+	if namespace == "" {
+		namespace = c.GetNamespace()
+	}
+	return fetchOwner(ctx, r, gv.WithKind(ref["kind"]), types.NamespacedName{Name: ref["name"], Namespace: namespace})
+}
+
+// listMachineDeployments returns the MachineDeployments of a CAPI Cluster, or none when
+// their CRD is missing.
+func listMachineDeployments(ctx context.Context, r client.Reader, c *unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+	mds := &unstructured.UnstructuredList{}
+	mds.SetGroupVersionKind(CapiMachineDeploymentGVK.GroupVersion().WithKind(CapiMachineDeploymentGVK.Kind + "List"))
+	err := r.List(ctx, mds, client.InNamespace(c.GetNamespace()), client.MatchingLabels{CapiClusterNameLabel: c.GetName()})
+	if meta.IsNoMatchError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return mds.Items, nil
+}
+
+// RolloutInProgress reports whether a control plane or MachineDeployment is rolling out,
+// from the replica counts both report: its spec is not observed yet or outdated replicas
+// remain. Replicas are only outdated by template or version changes, so scaling is not
+// a rollout.
+func RolloutInProgress(obj *unstructured.Unstructured) bool {
+	observed, found, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	if found && observed < obj.GetGeneration() {
+		return true
+	}
+	updated, found, _ := unstructured.NestedInt64(obj.Object, "status", "updatedReplicas")
+	if !found {
+		return false
+	}
+	if replicas, ok, _ := unstructured.NestedInt64(obj.Object, "status", "replicas"); ok && updated < replicas {
+		return true
+	}
+	return false
+}
+
+// CapiClusterOwnedRequests maps an object of a CAPI Cluster, such as its control plane or
+// MachineDeployments, to the preferred existing kubeconfig secret of the Cluster.
+func (r *Capi2Argo) CapiClusterOwnedRequests(ctx context.Context, obj client.Object) []reconcile.Request {
+	name := obj.GetLabels()[CapiClusterNameLabel]
+	for _, owner := range obj.GetOwnerReferences() {
+		if name == "" && owner.Kind == CapiClusterGVK.Kind && strings.HasPrefix(owner.APIVersion, CapiClusterGVK.Group+"/") {
+			name = owner.Name
+		}
+	}
+	if name == "" {
+		return nil
+	}
+	cluster, err := fetchOwner(ctx, r, CapiClusterGVK, types.NamespacedName{Name: name, Namespace: obj.GetNamespace()})
+	if err != nil {
+		r.Log.Error(err, "Failed to fetch CAPI Cluster", "object", client.ObjectKeyFromObject(obj))
+		return nil
+	}
+	if cluster == nil {
+		return nil
+	}
+	return r.CapiClusterRequests(ctx, cluster)
+}
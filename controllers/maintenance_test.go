@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// mockRollout returns a control plane or MachineDeployment of the test cluster with given replica counts.
+func mockRollout(gvk schema.GroupVersionKind, name string, desired, replicas, updated int64) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec":   map[string]interface{}{"replicas": desired},
+		"status": map[string]interface{}{"observedGeneration": int64(1), "replicas": replicas, "updatedReplicas": updated},
+	}}
+	u.SetGroupVersionKind(gvk)
+	u.SetName(name)
+	u.SetNamespace("test")
+	u.SetGeneration(1)
+	u.SetLabels(map[string]string{CapiClusterNameLabel: "test"})
+	return u
+}
+
+// mockMaintenanceCluster returns a CAPI Cluster in given phase, referencing a KubeadmControlPlane.
+func mockMaintenanceCluster(phase string) *unstructured.Unstructured {
+	c := MockCapiTopologyCluster("test")
+	_ = unstructured.SetNestedField(c.Object, phase, "status", "phase")
+	_ = unstructured.SetNestedStringMap(c.Object, map[string]string{
+		"apiVersion": KubeadmControlPlaneGVK.GroupVersion().String(),
+		"kind":       KubeadmControlPlaneGVK.Kind,
+		"name":       "test-cp",
+	}, "spec", "controlPlaneRef")
+	return c
+}
+
+func TestRolloutInProgress(t *testing.T) {
+	t.Parallel()
+	unobserved := mockRollout(CapiMachineDeploymentGVK, "md-0", 3, 3, 3)
+	unobserved.SetGeneration(2)
+	tests := []struct {
+		testName string
+		testMock *unstructured.Unstructured
+		expected bool
+	}{
+		{"test rolled out", mockRollout(CapiMachineDeploymentGVK, "md-0", 3, 3, 3), false},
+		{"test replicas not updated", mockRollout(KubeadmControlPlaneGVK, "test-cp", 3, 3, 1), true},
+		{"test outdated replicas remaining", mockRollout(KubeadmControlPlaneGVK, "test-cp", 3, 4, 3), true},
+		{"test scale up", mockRollout(CapiMachineDeploymentGVK, "md-0", 5, 3, 3), false},
+		{"test scale down", mockRollout(KubeadmControlPlaneGVK, "test-cp", 1, 3, 3), false},
+		{"test spec not observed", unobserved, true},
+		{"test no status", &unstructured.Unstructured{Object: map[string]interface{}{}}, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.expected, RolloutInProgress(tt.testMock))
+		})
+	}
+}
+
+func TestClusterMaintenance(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		testName string
+		phase    string
+		objects  []*unstructured.Unstructured
+		expected string
+	}{
+		{"test provisioned", "Provisioned", []*unstructured.Unstructured{
+			mockRollout(KubeadmControlPlaneGVK, "test-cp", 3, 3, 3),
+			mockRollout(CapiMachineDeploymentGVK, "md-0", 2, 2, 2),
+		}, ""},
+		{"test maintenance phase", "Deleting", nil, "Cluster is Deleting"},
+		{"test control plane rollout", "Provisioned", []*unstructured.Unstructured{
+			mockRollout(KubeadmControlPlaneGVK, "test-cp", 3, 4, 1),
+		}, "KubeadmControlPlane test-cp is rolling out"},
+		{"test machine deployment rollout", "Provisioned", []*unstructured.Unstructured{
+			mockRollout(KubeadmControlPlaneGVK, "test-cp", 3, 3, 3),
+			mockRollout(CapiMachineDeploymentGVK, "md-0", 2, 3, 1),
+		}, "MachineDeployment md-0 is rolling out"},
+		{"test machine deployment scale up", "Provisioned", []*unstructured.Unstructured{
+			mockRollout(KubeadmControlPlaneGVK, "test-cp", 3, 3, 3),
+			mockRollout(CapiMachineDeploymentGVK, "md-0", 5, 2, 2),
+		}, ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			r := &mockClient{objects: tt.objects}
+			reason, err := ClusterMaintenance(context.Background(), r, mockMaintenanceCluster(tt.phase), (*MaintenanceConfig)(nil).GetPhases())
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, reason)
+		})
+	}
+}
+
+func TestMaintenanceMetadata(t *testing.T) {
+	t.Parallel()
+	labels, annotations := (&MaintenanceConfig{}).MaintenanceMetadata("Cluster is Deleting")
+	assert.Equal(t, map[string]string{MaintenanceLabel: "true"}, labels)
+	assert.Empty(t, annotations)
+
+	labels, annotations = (&MaintenanceConfig{Annotation: "example.com/maintenance"}).MaintenanceMetadata("Cluster is Deleting")
+	assert.Empty(t, labels)
+	assert.Equal(t, map[string]string{"example.com/maintenance": "Cluster is Deleting"}, annotations)
+}
+
+func TestCapiSourceAdapterMaintenance(t *testing.T) {
+	old := Maintenance
+	defer func() { Maintenance = old }()
+	Maintenance = &MaintenanceConfig{Label: "example.com/maintenance", Annotation: "example.com/maintenance-reason"}
+
+	r := &mockClient{objects: []*unstructured.Unstructured{mockMaintenanceCluster("Provisioning")}}
+	metadata, err := CapiSourceAdapter{}.FetchMetadata(context.Background(), r, "test", "test")
+	assert.Nil(t, err)
+	assert.Equal(t, "true", metadata.ArgoLabels["example.com/maintenance"])
+	assert.Equal(t, "Cluster is Provisioning", metadata.ArgoAnnotations["example.com/maintenance-reason"])
+	assert.Contains(t, ManagedArgoLabels(), "example.com/maintenance")
+	assert.Contains(t, ManagedArgoAnnotations(), "example.com/maintenance-reason")
+}
+
+func TestValidateMaintenance(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		testName          string
+		testMock          *MaintenanceConfig
+		testExpectedError bool
+	}{
+		{"test disabled", nil, false},
+		{"test defaults", &MaintenanceConfig{}, false},
+		{"test custom markers", &MaintenanceConfig{Label: "example.com/maintenance", Annotation: "example.com/reason", Phases: []string{"Provisioning"}}, false},
+		{"test invalid label", &MaintenanceConfig{Label: "under maintenance"}, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateMaintenance(tt.testMock)
+			if !tt.testExpectedError {
+				assert.Nil(t, err)
+			} else {
+				assert.NotNil(t, err)
+			}
+		})
+	}
+}
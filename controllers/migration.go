@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// NameMigrationDisabled keeps legacy ArgoSecrets untouched.
+	NameMigrationDisabled = ""
+	// NameMigrationDryRun only reports what a migration would change.
+	NameMigrationDryRun = "dry-run"
+	// NameMigrationApply rewrites Argo destinations and deletes legacy ArgoSecrets.
+	NameMigrationApply = "apply"
+)
+
+var (
+	// NameMigrationMode controls how ArgoSecrets left behind by a naming change
+	// (eg. toggling EnableNamespacedNames) are handled.
+	NameMigrationMode string
+
+	// EnableMigrationAppRewrite enables rewriting Argo Application and ApplicationSet
+	// destinations from the legacy cluster name to the new one during migration.
+	EnableMigrationAppRewrite bool
+
+	// MigrationAppNamespaces lists the namespaces Applications and ApplicationSets are
+	// rewritten in besides the Argo namespace of the ArgoSecret, for Argo instances
+	// serving apps in any namespace. Other namespaces may belong to other Argo instances.
+	MigrationAppNamespaces []string
+)
+
+// migratedAnnotations hold operator state on an ArgoSecret that is not derived from the
+// cluster, so it is carried over from legacy ArgoSecrets instead of being recomputed. Each
+// group is carried over as a whole.
+var migratedAnnotations = [][]string{
+	{BootstrappedAnnotation},
+	{RejectedConfigAnnotation, RejectedAtAnnotation},
+}
+
+var (
+	argoApplicationListGVK    = schema.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "ApplicationList"}
+	argoApplicationSetListGVK = schema.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "ApplicationSetList"}
+)
+
+// FindLegacySecrets returns ArgoSecrets that were generated for the same CapiSecret
+// but under a different name than the current one.
+func FindLegacySecrets(secrets []corev1.Secret, current types.NamespacedName) []corev1.Secret {
+	var legacy []corev1.Secret
+	for _, s := range secrets {
+		if s.Name == current.Name && s.Namespace == current.Namespace {
+			continue
+		}
+		if ValidateObjectOwner(s) != nil {
+			continue
+		}
+		legacy = append(legacy, s)
+	}
+	return legacy
+}
+
+// CarryOverAnnotations copies the operator state annotations of a legacy ArgoSecret that
+// are missing on the current one. It reports whether the current ArgoSecret changed.
+func CarryOverAnnotations(legacy, current *corev1.Secret) bool {
+	changed := false
+group:
+	for _, keys := range migratedAnnotations {
+		for _, key := range keys {
+			if _, ok := current.Annotations[key]; ok {
+				continue group
+			}
+		}
+		for _, key := range keys {
+			value, ok := legacy.Annotations[key]
+			if !ok {
+				continue
+			}
+			if current.Annotations == nil {
+				current.Annotations = make(map[string]string)
+			}
+			current.Annotations[key] = value
+			changed = true
+		}
+	}
+	return changed
+}
+
+// RewriteDestinationName points an Argo Application or ApplicationSet template
+// destination that targets oldName to newName. It reports whether the object changed.
+func RewriteDestinationName(obj *unstructured.Unstructured, oldName, newName string) (bool, error) {
+	path := []string{"spec", "destination", "name"}
+	if obj.GetKind() == "ApplicationSet" {
+		path = []string{"spec", "template", "spec", "destination", "name"}
+	}
+
+	name, found, err := unstructured.NestedString(obj.Object, path...)
+	if err != nil || !found || name != oldName {
+		return false, err
+	}
+	if err := unstructured.SetNestedField(obj.Object, newName, path...); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// MigrateLegacySecrets moves everything that references ArgoSecrets generated under a
+// previous naming scheme over to the current ArgoCluster. It must only be called once
+// the current ArgoSecret exists, as legacy secrets get deleted in apply mode. Operator state
+// of legacy secrets is carried over to current. Given client must point to the hub holding
+// the ArgoSecret.
+func (r *Capi2Argo) MigrateLegacySecrets(ctx context.Context, c client.Client, log logr.Logger, a *ArgoCluster, current *corev1.Secret) error {
+	if NameMigrationMode == NameMigrationDisabled {
+		return nil
+	}
+	dryRun := NameMigrationMode != NameMigrationApply
+
+	secretList := &corev1.SecretList{}
+	listOptions := []client.ListOption{
+		client.InNamespace(a.NamespacedName.Namespace),
+		client.MatchingLabels{
+			"capi-to-argocd/cluster-secret-name": a.ClusterLabels["capi-to-argocd/cluster-secret-name"],
+			"capi-to-argocd/cluster-namespace":   a.ClusterLabels["capi-to-argocd/cluster-namespace"],
+		},
+	}
+	if err := c.List(ctx, secretList, listOptions...); err != nil {
+		log.Error(err, "Failed to list ArgoSecrets for migration")
+		return err
+	}
+
+	for _, legacy := range FindLegacySecrets(secretList.Items, a.NamespacedName) {
+		legacyName := string(legacy.Data["name"])
+		log := log.WithValues("legacySecret", legacy.Name, "legacyName", legacyName, "dryRun", dryRun)
+
+		if EnableMigrationAppRewrite && legacyName != a.ClusterName {
+			for _, gvk := range []schema.GroupVersionKind{argoApplicationListGVK, argoApplicationSetListGVK} {
+				if err := rewriteDestinations(ctx, c, log, gvk, a.NamespacedName.Namespace, legacyName, a.ClusterName, dryRun); err != nil {
+					return err
+				}
+			}
+		}
+
+		if dryRun {
+			log.Info("Would delete legacy ArgoSecret")
+			continue
+		}
+		if CarryOverAnnotations(&legacy, current) {
+			if err := c.Update(ctx, current); err != nil {
+				log.Error(err, "Failed to carry over annotations of legacy ArgoSecret")
+				return err
+			}
+			log.Info("Carried over annotations of legacy ArgoSecret")
+		}
+		if err := c.Delete(ctx, &legacy); client.IgnoreNotFound(err) != nil {
+			log.Error(err, "Failed to delete legacy ArgoSecret")
+			return err
+		}
+		log.Info("Deleted legacy ArgoSecret")
+	}
+	return nil
+}
+
+// rewriteDestinations rewrites all objects of the given list kind that target oldName,
+// in the Argo namespace and MigrationAppNamespaces.
+func rewriteDestinations(ctx context.Context, c client.Client, log logr.Logger, gvk schema.GroupVersionKind, argoNamespace, oldName, newName string, dryRun bool) error {
+	list := &unstructured.UnstructuredList{}
+	for _, namespace := range migrationAppNamespaces(argoNamespace) {
+		namespaceList := &unstructured.UnstructuredList{}
+		namespaceList.SetGroupVersionKind(gvk)
+		if err := c.List(ctx, namespaceList, client.InNamespace(namespace)); err != nil {
+			if meta.IsNoMatchError(err) {
+				log.Info("Argo API is not installed, skipping destination rewrite", "kind", gvk.Kind)
+				return nil
+			}
+			log.Error(err, "Failed to list Argo objects for migration", "kind", gvk.Kind, "namespace", namespace)
+			return err
+		}
+		list.Items = append(list.Items, namespaceList.Items...)
+	}
+
+	for i := range list.Items {
+		obj := &list.Items[i]
+		changed, err := RewriteDestinationName(obj, oldName, newName)
+		if err != nil {
+			log.Error(err, "Failed to parse Argo object destination", "object", client.ObjectKeyFromObject(obj))
+			return err
+		}
+		if !changed {
+			continue
+		}
+		if dryRun {
+			log.Info("Would rewrite destination", "kind", obj.GetKind(), "object", client.ObjectKeyFromObject(obj), "newName", newName)
+			continue
+		}
+		if err := c.Update(ctx, obj); err != nil {
+			log.Error(err, "Failed to rewrite destination", "kind", obj.GetKind(), "object", client.ObjectKeyFromObject(obj))
+			return err
+		}
+		log.Info("Rewrote destination", "kind", obj.GetKind(), "object", client.ObjectKeyFromObject(obj), "newName", newName)
+	}
+	return nil
+}
+
+// migrationAppNamespaces returns the namespaces Argo objects are rewritten in.
+func migrationAppNamespaces(argoNamespace string) []string {
+	namespaces := []string{argoNamespace}
+	for _, namespace := range MigrationAppNamespaces {
+		if namespace != argoNamespace {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
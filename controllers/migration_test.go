@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestFindLegacySecrets(t *testing.T) {
+	t.Parallel()
+	current := types.NamespacedName{Name: "cluster-test-ns-test", Namespace: "argocd"}
+	owned := map[string]string{"capi-to-argocd/owned": "true"}
+	secrets := []corev1.Secret{
+		{ObjectMeta: metav1.ObjectMeta{Name: "cluster-test-ns-test", Namespace: "argocd", Labels: owned}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "cluster-test", Namespace: "argocd", Labels: owned}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "cluster-manual", Namespace: "argocd"}},
+	}
+
+	legacy := FindLegacySecrets(secrets, current)
+	assert.Len(t, legacy, 1)
+	assert.Equal(t, "cluster-test", legacy[0].Name)
+	assert.Empty(t, FindLegacySecrets(secrets[:1], current))
+}
+
+func TestCarryOverAnnotations(t *testing.T) {
+	t.Parallel()
+	legacy := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		BootstrappedAnnotation:   "true",
+		RejectedConfigAnnotation: "abc",
+		RejectedAtAnnotation:     "2024-01-01T00:00:00Z",
+		"capi-to-argocd/shard":   "1",
+	}}}
+	current := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		RejectedConfigAnnotation: "def",
+	}}}
+
+	assert.True(t, CarryOverAnnotations(legacy, current))
+	assert.Equal(t, "true", current.Annotations[BootstrappedAnnotation])
+	assert.Equal(t, "def", current.Annotations[RejectedConfigAnnotation])
+	assert.NotContains(t, current.Annotations, RejectedAtAnnotation)
+	assert.NotContains(t, current.Annotations, "capi-to-argocd/shard")
+	assert.False(t, CarryOverAnnotations(legacy, current))
+
+	fresh := &corev1.Secret{}
+	assert.True(t, CarryOverAnnotations(legacy, fresh))
+	assert.Equal(t, "true", fresh.Annotations[BootstrappedAnnotation])
+	assert.Equal(t, "abc", fresh.Annotations[RejectedConfigAnnotation])
+	assert.Equal(t, "2024-01-01T00:00:00Z", fresh.Annotations[RejectedAtAnnotation])
+}
+
+func TestRewriteDestinationName(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		testName        string
+		testMock        *unstructured.Unstructured
+		testExpectedHit bool
+		testExpectedErr bool
+	}{
+		{"test application targeting legacy name", MockArgoApplication("Application", "test"), true, false},
+		{"test application targeting other cluster", MockArgoApplication("Application", "other"), false, false},
+		{"test applicationset targeting legacy name", MockArgoApplication("ApplicationSet", "test"), true, false},
+		{"test application without destination", &unstructured.Unstructured{Object: map[string]interface{}{"kind": "Application"}}, false, false},
+		{"test application with malformed destination", &unstructured.Unstructured{Object: map[string]interface{}{
+			"kind": "Application",
+			"spec": map[string]interface{}{"destination": "test"},
+		}}, false, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			changed, err := RewriteDestinationName(tt.testMock, "test", "test-ns-test")
+			assert.Equal(t, tt.testExpectedHit, changed)
+			if tt.testExpectedErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			if changed {
+				// Rewriting again must be a no-op once the destination points to the new name.
+				changed, err = RewriteDestinationName(tt.testMock, "test", "test-ns-test")
+				assert.False(t, changed)
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestRewriteDestinationsInArgoNamespaces(t *testing.T) {
+	old := MigrationAppNamespaces
+	defer func() { MigrationAppNamespaces = old }()
+	MigrationAppNamespaces = []string{"team-a"}
+
+	var objects []*unstructured.Unstructured
+	for _, namespace := range []string{"argocd", "team-a", "other-argocd"} {
+		app := MockArgoApplication("Application", "test")
+		app.SetNamespace(namespace)
+		objects = append(objects, app)
+	}
+	c := &mockClient{objects: objects}
+	assert.Nil(t, rewriteDestinations(context.Background(), c, logr.Discard(), argoApplicationListGVK, "argocd", "test", "test-ns-test", false))
+
+	// Applications of other Argo instances are left alone.
+	for _, app := range c.objects {
+		name, _, _ := unstructured.NestedString(app.Object, "spec", "destination", "name")
+		if app.GetNamespace() == "other-argocd" {
+			assert.Equal(t, "test", name)
+		} else {
+			assert.Equal(t, "test-ns-test", name, app.GetNamespace())
+		}
+	}
+}
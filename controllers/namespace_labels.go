@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"context"
+	goErr "errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var (
+	// NamespaceLabels selects the labels of cluster namespaces inherited by ArgoSecrets, when set.
+	NamespaceLabels *NamespaceLabelsConfig
+)
+
+// NamespaceLabelsConfig selects namespace labels by prefix or by key, eg. tenant,
+// cost-centre or environment labels.
+type NamespaceLabelsConfig struct {
+	Prefixes []string `json:"prefixes,omitempty"`
+	Keys     []string `json:"keys,omitempty"`
+}
+
+// Matches reports whether a namespace label is inherited.
+func (n *NamespaceLabelsConfig) Matches(key string) bool {
+	if n == nil {
+		return false
+	}
+	if containsString(n.Keys, key) {
+		return true
+	}
+	for _, prefix := range n.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateNamespaceLabels validates that namespace labels are selected by non empty prefixes or keys.
+func ValidateNamespaceLabels(n *NamespaceLabelsConfig) error {
+	if n == nil {
+		return nil
+	}
+	if len(n.Prefixes) == 0 && len(n.Keys) == 0 {
+		return goErr.New("prefixes or keys must be set")
+	}
+	for i, prefix := range n.Prefixes {
+		if prefix == "" {
+			return fmt.Errorf("prefix %d: must not be empty", i)
+		}
+	}
+	for i, key := range n.Keys {
+		if key == "" {
+			return fmt.Errorf("key %d: must not be empty", i)
+		}
+	}
+	return nil
+}
+
+// InheritedNamespaceLabels returns the namespace labels inherited by ArgoSecrets.
+func InheritedNamespaceLabels(n *NamespaceLabelsConfig, labels map[string]string) map[string]string {
+	inherited := map[string]string{}
+	for key, value := range labels {
+		if n.Matches(key) {
+			inherited[key] = value
+		}
+	}
+	return inherited
+}
+
+// FetchNamespaceLabels returns the inherited labels of a cluster namespace.
+func (r *Capi2Argo) FetchNamespaceLabels(ctx context.Context, log logr.Logger, namespace string) (map[string]string, error) {
+	if NamespaceLabels == nil {
+		return nil, nil
+	}
+	var ns corev1.Namespace
+	if err := r.Get(ctx, types.NamespacedName{Name: namespace}, &ns); err != nil {
+		log.Error(err, "Failed to fetch cluster namespace")
+		return nil, err
+	}
+	return InheritedNamespaceLabels(NamespaceLabels, ns.Labels), nil
+}
+
+// NamespaceRequests maps a namespace to the kubeconfig secrets it holds, so namespace
+// label changes and approvals are reflected on the ArgoSecrets of every cluster in it.
+func (r *Capi2Argo) NamespaceRequests(ctx context.Context, obj client.Object) []reconcile.Request {
+	secretList := &corev1.SecretList{}
+	if err := r.List(ctx, secretList, client.InNamespace(obj.GetName())); err != nil {
+		r.Log.Error(err, "Failed to list secrets of namespace", "namespace", obj.GetName())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range secretList.Items {
+		s := &secretList.Items[i]
+		if _, err := SourceAdapterForSecret(s, SourceAdaptersForName(s.Name)); err != nil {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(s)})
+	}
+	return requests
+}
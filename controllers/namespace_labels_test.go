@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateNamespaceLabels(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		testName          string
+		testMock          *NamespaceLabelsConfig
+		testExpectedError bool
+	}{
+		{"test disabled", nil, false},
+		{"test prefixes", &NamespaceLabelsConfig{Prefixes: []string{"example.com/"}}, false},
+		{"test keys", &NamespaceLabelsConfig{Keys: []string{"tenant", "cost-centre"}}, false},
+		{"test empty selection", &NamespaceLabelsConfig{}, true},
+		{"test empty prefix", &NamespaceLabelsConfig{Prefixes: []string{""}}, true},
+		{"test empty key", &NamespaceLabelsConfig{Keys: []string{""}}, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateNamespaceLabels(tt.testMock)
+			if !tt.testExpectedError {
+				assert.Nil(t, err)
+			} else {
+				assert.NotNil(t, err)
+			}
+		})
+	}
+}
+
+func TestInheritedNamespaceLabels(t *testing.T) {
+	t.Parallel()
+	labels := map[string]string{
+		"tenant":                      "payments",
+		"example.com/environment":     "prod",
+		"kubernetes.io/metadata.name": "test",
+	}
+
+	assert.Equal(t, map[string]string{"tenant": "payments", "example.com/environment": "prod"},
+		InheritedNamespaceLabels(&NamespaceLabelsConfig{Prefixes: []string{"example.com/"}, Keys: []string{"tenant"}}, labels))
+	assert.Empty(t, InheritedNamespaceLabels(nil, labels))
+}
+
+func TestIsManagedArgoLabel(t *testing.T) {
+	oldNamespaceLabels := NamespaceLabels
+	defer func() { NamespaceLabels = oldNamespaceLabels }()
+	NamespaceLabels = &NamespaceLabelsConfig{Prefixes: []string{"example.com/"}, Keys: []string{"tenant"}}
+
+	assert.True(t, IsManagedArgoLabel("capi-to-argocd/owned"))
+	assert.True(t, IsManagedArgoLabel("tenant"))
+	assert.True(t, IsManagedArgoLabel("example.com/environment"))
+	assert.False(t, IsManagedArgoLabel("team"))
+
+	desired := MockArgoSecret()
+	existing := desired.DeepCopy()
+	existing.Labels["tenant"] = "payments"
+	existing.Labels["team"] = "platform"
+	assert.True(t, SyncArgoSecretLabels(existing, desired))
+	assert.NotContains(t, existing.Labels, "tenant")
+	assert.Equal(t, "platform", existing.Labels["team"])
+}
@@ -0,0 +1,371 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	goErr "errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// PluginGeneratorPath is served by ApplicationSet plugin generators.
+	PluginGeneratorPath = "/api/v1/getparams.execute"
+
+	defaultPluginGeneratorTokenKey = "token"
+)
+
+var (
+	// PluginGenerator configures the ApplicationSet plugin generator endpoint, serving it when set.
+	PluginGenerator *PluginGeneratorConfig
+)
+
+// PluginGeneratorConfig configures the ApplicationSet plugin generator endpoint.
+type PluginGeneratorConfig struct {
+	// BindAddress the endpoint listens on, eg. :4355.
+	BindAddress string `json:"bindAddress"`
+	// TokenSecret holds the bearer token ApplicationSets authenticate with. It is read
+	// on every request, so rotating the token needs no restart.
+	TokenSecret PluginGeneratorTokenSecret `json:"tokenSecret"`
+}
+
+// PluginGeneratorTokenSecret references the key of a Secret holding the plugin token.
+type PluginGeneratorTokenSecret struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Key       string `json:"key,omitempty"`
+}
+
+// GetKey returns the referenced key, defaulting to token.
+func (s PluginGeneratorTokenSecret) GetKey() string {
+	if s.Key == "" {
+		return defaultPluginGeneratorTokenKey
+	}
+	return s.Key
+}
+
+// ValidatePluginGenerator validates the plugin generator configuration.
+func ValidatePluginGenerator(p *PluginGeneratorConfig) error {
+	if p == nil {
+		return nil
+	}
+	if p.BindAddress == "" {
+		return goErr.New("missing bindAddress")
+	}
+	if p.TokenSecret.Name == "" || p.TokenSecret.Namespace == "" {
+		return goErr.New("missing tokenSecret name or namespace")
+	}
+	return nil
+}
+
+// pluginGeneratorRequest is the body ApplicationSet plugin generators post.
+type pluginGeneratorRequest struct {
+	ApplicationSetName string `json:"applicationSetName"`
+	Input              struct {
+		Parameters map[string]interface{} `json:"parameters"`
+	} `json:"input"`
+}
+
+// pluginGeneratorResponse is the body answered to ApplicationSet plugin generators.
+type pluginGeneratorResponse struct {
+	Output struct {
+		Parameters []map[string]interface{} `json:"parameters"`
+	} `json:"output"`
+}
+
+// PluginGeneratorServer serves the registered clusters to ApplicationSet plugin generators,
+// enriched with metadata of their CAPI Cluster.
+type PluginGeneratorServer struct {
+	Client client.Reader
+	Log    logr.Logger
+	Config *PluginGeneratorConfig
+	// Hubs provides clients for remote Argo hubs. When nil, only the local cluster is listed.
+	Hubs *ArgoHubClients
+}
+
+// hubReaders returns the readers of the given hub, or of every hub when unset.
+func (s *PluginGeneratorServer) hubReaders(ctx context.Context, hub string) (map[string]client.Reader, error) {
+	names := []string{LocalHub}
+	if s.Hubs != nil {
+		names = s.Hubs.Names()
+	}
+	if hub != "" {
+		if !containsString(names, hub) {
+			return nil, fmt.Errorf("unknown hub %s", hub)
+		}
+		names = []string{hub}
+	}
+
+	readers := map[string]client.Reader{}
+	for _, name := range names {
+		if name == LocalHub {
+			readers[name] = s.Client
+			continue
+		}
+		c, err := s.Hubs.Get(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		readers[name] = c
+	}
+	return readers, nil
+}
+
+// Start implements manager.Runnable, serving until the context is done.
+func (s *PluginGeneratorServer) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle(PluginGeneratorPath, s)
+	srv := &http.Server{Addr: s.Config.BindAddress, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			s.Log.Error(err, "Failed to shutdown plugin generator endpoint")
+		}
+	}()
+
+	s.Log.Info("Serving ApplicationSet plugin generator", "address", s.Config.BindAddress)
+	if err := srv.ListenAndServe(); err != nil && !goErr.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, serving on every replica.
+func (s *PluginGeneratorServer) NeedLeaderElection() bool {
+	return false
+}
+
+// ServeHTTP implements http.Handler.
+func (s *PluginGeneratorServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.authenticate(ctx, req); err != nil {
+		s.Log.Info("Rejected plugin generator request", "reason", err.Error())
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	var body pluginGeneratorRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	log := s.Log.WithValues("applicationSet", body.ApplicationSetName)
+
+	namespace := ArgoNamespace
+	if v, ok := body.Input.Parameters["namespace"].(string); ok && v != "" {
+		namespace = v
+	}
+	hub, _ := body.Input.Parameters["hub"].(string)
+	hubs, err := s.hubReaders(ctx, hub)
+	if err != nil {
+		log.Error(err, "Failed to get Argo hub clients")
+		http.Error(w, "failed to generate parameters", http.StatusInternalServerError)
+		return
+	}
+	params, err := PluginGeneratorParams(ctx, s.Client, hubs, namespace)
+	if err != nil {
+		log.Error(err, "Failed to generate plugin parameters")
+		http.Error(w, "failed to generate parameters", http.StatusInternalServerError)
+		return
+	}
+
+	var resp pluginGeneratorResponse
+	resp.Output.Parameters = params
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Error(err, "Failed to write plugin generator response")
+	}
+}
+
+// authenticate checks the bearer token of a request against the token secret.
+func (s *PluginGeneratorServer) authenticate(ctx context.Context, req *http.Request) error {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return goErr.New("missing bearer token")
+	}
+	ref := s.Config.TokenSecret
+	var secret corev1.Secret
+	if err := s.Client.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}, &secret); err != nil {
+		return fmt.Errorf("failed to fetch token secret: %w", err)
+	}
+	expected := secret.Data[ref.GetKey()]
+	if len(expected) == 0 || subtle.ConstantTimeCompare([]byte(token), expected) != 1 {
+		return goErr.New("invalid bearer token")
+	}
+	return nil
+}
+
+// PluginGeneratorParams returns one parameter set per ArgoSecret of an Argo namespace of
+// the given hubs, sorted by cluster name and hub. CAPI Clusters are read from the local cluster.
+func PluginGeneratorParams(ctx context.Context, r client.Reader, hubs map[string]client.Reader, namespace string) ([]map[string]interface{}, error) {
+	params := []map[string]interface{}{}
+	for hub, hubReader := range hubs {
+		secretList := &corev1.SecretList{}
+		if err := hubReader.List(ctx, secretList, client.InNamespace(namespace), client.MatchingLabels(GetArgoCommonLabels())); err != nil {
+			return nil, err
+		}
+		for i := range secretList.Items {
+			p, err := ArgoSecretParams(ctx, r, &secretList.Items[i])
+			if err != nil {
+				return nil, err
+			}
+			p["hub"] = hub
+			params = append(params, p)
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i]["name"] != params[j]["name"] {
+			return params[i]["name"].(string) < params[j]["name"].(string)
+		}
+		return params[i]["hub"].(string) < params[j]["hub"].(string)
+	})
+	return params, nil
+}
+
+// ArgoSecretParams returns the parameters of an ArgoSecret, adding CAPI Cluster fields for
+// clusters sourced from CAPI.
+func ArgoSecretParams(ctx context.Context, r client.Reader, argoSecret *corev1.Secret) (map[string]interface{}, error) {
+	labels := map[string]interface{}{}
+	for key, value := range argoSecret.Labels {
+		labels[key] = value
+	}
+	namespace := argoSecret.Labels["capi-to-argocd/cluster-namespace"]
+	p := map[string]interface{}{
+		"name":             string(argoSecret.Data["name"]),
+		"server":           string(argoSecret.Data["server"]),
+		"project":          string(argoSecret.Data["project"]),
+		"labels":           labels,
+		"clusterNamespace": namespace,
+	}
+
+	// Clusters of other source adapters carry no ArgoSecret source label of CAPI.
+	source := argoSecret.Labels[SourceLabel]
+	if source != "" && source != (CapiSourceAdapter{}).Name() {
+		return p, nil
+	}
+	cluster, ok := CapiSourceAdapter{}.ParseSecretName(argoSecret.Labels["capi-to-argocd/cluster-secret-name"])
+	if !ok {
+		return p, nil
+	}
+	p["clusterName"] = cluster
+	capiCluster, err := fetchOwner(ctx, r, CapiClusterGVK, types.NamespacedName{Name: cluster, Namespace: namespace})
+	if err != nil || capiCluster == nil {
+		return p, err
+	}
+	for key, value := range CapiClusterParams(capiCluster) {
+		p[key] = value
+	}
+	objectParams, err := CapiClusterObjectParams(ctx, r, capiCluster)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range objectParams {
+		p[key] = value
+	}
+	return p, nil
+}
+
+// CapiClusterParams describes a CAPI Cluster: its Kubernetes version, ClusterClass,
+// topology variables, infrastructure provider and desired replica counts. Ready replica
+// counts, and the Kubernetes version of Clusters without topology, are added by
+// CapiClusterObjectParams.
+func CapiClusterParams(c *unstructured.Unstructured) map[string]interface{} {
+	p := map[string]interface{}{}
+	if v, _, _ := unstructured.NestedString(c.Object, "spec", "topology", "version"); v != "" {
+		p["kubernetesVersion"] = v
+	}
+	if v, _, _ := unstructured.NestedString(c.Object, "spec", "topology", "class"); v != "" {
+		p["clusterClass"] = v
+	}
+	if v, _, _ := unstructured.NestedString(c.Object, "spec", "infrastructureRef", "kind"); v != "" {
+		p["infrastructureProvider"] = v
+	}
+
+	variables := map[string]interface{}{}
+	list, _, _ := unstructured.NestedSlice(c.Object, "spec", "topology", "variables")
+	for _, item := range list {
+		variable, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if name, ok := variable["name"].(string); ok {
+			variables[name] = variable["value"]
+		}
+	}
+	p["variables"] = variables
+
+	if replicas, found, _ := unstructured.NestedInt64(c.Object, "spec", "topology", "controlPlane", "replicas"); found {
+		p["desiredControlPlaneReplicas"] = replicas
+	}
+	machineDeployments, found, _ := unstructured.NestedSlice(c.Object, "spec", "topology", "workers", "machineDeployments")
+	if found {
+		var workers int64
+		for _, item := range machineDeployments {
+			md, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if replicas, found, _ := unstructured.NestedInt64(md, "replicas"); found {
+				workers += replicas
+			}
+		}
+		p["desiredWorkerReplicas"] = workers
+	}
+	return p
+}
+
+// CapiClusterObjectParams returns the ready replica counts of the control plane and the
+// MachineDeployments of a CAPI Cluster, as reported in their status. Counts are left out
+// when the Cluster has no such objects or they report none yet. Clusters without topology
+// get their Kubernetes version from the control plane.
+func CapiClusterObjectParams(ctx context.Context, r client.Reader, c *unstructured.Unstructured) (map[string]interface{}, error) {
+	p := map[string]interface{}{}
+	cp, err := fetchControlPlane(ctx, r, c)
+	if err != nil {
+		return nil, err
+	}
+	if cp != nil {
+		if _, found, _ := unstructured.NestedMap(c.Object, "spec", "topology"); !found {
+			if v, _, _ := unstructured.NestedString(cp.Object, "spec", "version"); v != "" {
+				p["kubernetesVersion"] = v
+			}
+		}
+		if ready, found, _ := unstructured.NestedInt64(cp.Object, "status", "readyReplicas"); found {
+			p["readyControlPlaneReplicas"] = ready
+		}
+	}
+
+	mds, err := listMachineDeployments(ctx, r, c)
+	if err != nil {
+		return nil, err
+	}
+	var workers int64
+	reported := false
+	for i := range mds {
+		if ready, found, _ := unstructured.NestedInt64(mds[i].Object, "status", "readyReplicas"); found {
+			workers += ready
+			reported = true
+		}
+	}
+	if reported {
+		p["readyWorkerReplicas"] = workers
+	}
+	return p, nil
+}
@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// MockCapiTopologyCluster returns a CAPI Cluster with a managed topology.
+func MockCapiTopologyCluster(name string) *unstructured.Unstructured {
+	c := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"infrastructureRef": map[string]interface{}{"kind": "AWSCluster"},
+			"topology": map[string]interface{}{
+				"class":        "aws-default",
+				"version":      "v1.29.2",
+				"controlPlane": map[string]interface{}{"replicas": int64(3)},
+				"workers": map[string]interface{}{
+					"machineDeployments": []interface{}{
+						map[string]interface{}{"name": "md-0", "replicas": int64(2)},
+						map[string]interface{}{"name": "md-1", "replicas": int64(3)},
+					},
+				},
+				"variables": []interface{}{
+					map[string]interface{}{"name": "region", "value": "eu-west-1"},
+				},
+			},
+		},
+	}}
+	c.SetGroupVersionKind(CapiClusterGVK)
+	c.SetName(name)
+	c.SetNamespace("test")
+	return c
+}
+
+func mockPluginGeneratorServer() *PluginGeneratorServer {
+	argoSecret := MockArgoSecret()
+	argoSecret.Namespace = ArgoNamespace
+	tokenSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "plugin-token", Namespace: ArgoNamespace},
+		Data:       map[string][]byte{"token": []byte("s3cr3t")},
+	}
+	return &PluginGeneratorServer{
+		Client: &mockClient{
+			secrets: map[types.NamespacedName]*corev1.Secret{
+				{Name: argoSecret.Name, Namespace: argoSecret.Namespace}:   argoSecret,
+				{Name: tokenSecret.Name, Namespace: tokenSecret.Namespace}: tokenSecret,
+			},
+			objects: []*unstructured.Unstructured{MockCapiTopologyCluster("test")},
+		},
+		Log:    logr.Discard(),
+		Config: &PluginGeneratorConfig{BindAddress: ":4355", TokenSecret: PluginGeneratorTokenSecret{Name: "plugin-token", Namespace: ArgoNamespace}},
+	}
+}
+
+func TestPluginGeneratorServeHTTP(t *testing.T) {
+	t.Parallel()
+	body := []byte(`{"applicationSetName":"addons","input":{"parameters":{}}}`)
+
+	tests := []struct {
+		testName string
+		method   string
+		token    string
+		status   int
+	}{
+		{"test valid token", http.MethodPost, "s3cr3t", http.StatusOK},
+		{"test invalid token", http.MethodPost, "wrong", http.StatusForbidden},
+		{"test missing token", http.MethodPost, "", http.StatusForbidden},
+		{"test wrong method", http.MethodGet, "s3cr3t", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			server := httptest.NewServer(mockPluginGeneratorServer())
+			defer server.Close()
+
+			req, err := http.NewRequest(tt.method, server.URL+PluginGeneratorPath, bytes.NewReader(body))
+			assert.Nil(t, err)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			assert.Nil(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.status, resp.StatusCode)
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var out pluginGeneratorResponse
+			assert.Nil(t, json.NewDecoder(resp.Body).Decode(&out))
+			assert.Len(t, out.Output.Parameters, 1)
+			p := out.Output.Parameters[0]
+			assert.Equal(t, "test", p["name"])
+			assert.Equal(t, "server", p["server"])
+			assert.Equal(t, "test", p["clusterName"])
+			assert.Equal(t, "v1.29.2", p["kubernetesVersion"])
+			assert.Equal(t, "aws-default", p["clusterClass"])
+			assert.Equal(t, "AWSCluster", p["infrastructureProvider"])
+			assert.Equal(t, map[string]interface{}{"region": "eu-west-1"}, p["variables"])
+			assert.Equal(t, float64(3), p["desiredControlPlaneReplicas"])
+			assert.Equal(t, float64(5), p["desiredWorkerReplicas"])
+		})
+	}
+}
+
+func TestPluginGeneratorHubs(t *testing.T) {
+	t.Parallel()
+	s := mockPluginGeneratorServer()
+	remote := MockArgoSecret()
+	remote.Namespace = ArgoNamespace
+	s.Hubs = NewArgoHubClients(nil, nil, nil)
+	s.Hubs.Set("hub-a", &mockClient{secrets: map[types.NamespacedName]*corev1.Secret{
+		{Name: remote.Name, Namespace: remote.Namespace}: remote,
+	}})
+
+	tests := []struct {
+		testName      string
+		hub           string
+		expectedHubs  []string
+		expectedError bool
+	}{
+		{"test every hub", "", []string{LocalHub, "hub-a"}, false},
+		{"test remote hub", "hub-a", []string{"hub-a"}, false},
+		{"test unknown hub", "hub-b", nil, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			hubs, err := s.hubReaders(context.Background(), tt.hub)
+			if tt.expectedError {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			params, err := PluginGeneratorParams(context.Background(), s.Client, hubs, ArgoNamespace)
+			assert.Nil(t, err)
+			var names []string
+			for _, p := range params {
+				names = append(names, p["hub"].(string))
+				// CAPI Clusters of remote hubs are read from the local cluster.
+				assert.Equal(t, "v1.29.2", p["kubernetesVersion"])
+			}
+			assert.Equal(t, tt.expectedHubs, names)
+		})
+	}
+}
+
+func TestArgoSecretParamsOtherSource(t *testing.T) {
+	t.Parallel()
+	argoSecret := MockArgoSecret()
+	argoSecret.Labels[SourceLabel] = "hive"
+	r := &mockClient{objects: []*unstructured.Unstructured{MockCapiTopologyCluster("test")}}
+
+	p, err := ArgoSecretParams(context.Background(), r, argoSecret)
+	assert.Nil(t, err)
+	assert.Equal(t, "test", p["clusterNamespace"])
+	assert.NotContains(t, p, "clusterName")
+	assert.NotContains(t, p, "kubernetesVersion")
+}
+
+func TestValidatePluginGenerator(t *testing.T) {
+	t.Parallel()
+	assert.Nil(t, ValidatePluginGenerator(nil))
+	assert.Nil(t, ValidatePluginGenerator(&PluginGeneratorConfig{BindAddress: ":4355", TokenSecret: PluginGeneratorTokenSecret{Name: "token", Namespace: "argocd"}}))
+	assert.NotNil(t, ValidatePluginGenerator(&PluginGeneratorConfig{TokenSecret: PluginGeneratorTokenSecret{Name: "token", Namespace: "argocd"}}))
+	assert.NotNil(t, ValidatePluginGenerator(&PluginGeneratorConfig{BindAddress: ":4355"}))
+}
+
+func TestCapiClusterObjectParams(t *testing.T) {
+	t.Parallel()
+	ready := func(u *unstructured.Unstructured, replicas int64) *unstructured.Unstructured {
+		_ = unstructured.SetNestedField(u.Object, replicas, "status", "readyReplicas")
+		return u
+	}
+	tests := []struct {
+		testName string
+		objects  []*unstructured.Unstructured
+		expected map[string]interface{}
+	}{
+		{"test ready replicas", []*unstructured.Unstructured{
+			ready(mockRollout(KubeadmControlPlaneGVK, "test-cp", 3, 3, 3), 2),
+			ready(mockRollout(CapiMachineDeploymentGVK, "md-0", 2, 2, 2), 2),
+			ready(mockRollout(CapiMachineDeploymentGVK, "md-1", 3, 3, 3), 1),
+		}, map[string]interface{}{"readyControlPlaneReplicas": int64(2), "readyWorkerReplicas": int64(3)}},
+		{"test no status reported", []*unstructured.Unstructured{
+			mockRollout(CapiMachineDeploymentGVK, "md-0", 2, 2, 2),
+		}, map[string]interface{}{}},
+		{"test missing objects", nil, map[string]interface{}{}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			p, err := CapiClusterObjectParams(context.Background(), &mockClient{objects: tt.objects}, mockMaintenanceCluster("Provisioned"))
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, p)
+		})
+	}
+}
+
+func TestCapiClusterObjectParamsWithoutTopology(t *testing.T) {
+	t.Parallel()
+	cp := mockRollout(KubeadmControlPlaneGVK, "test-cp", 3, 3, 3)
+	_ = unstructured.SetNestedField(cp.Object, "v1.28.5", "spec", "version")
+	r := &mockClient{objects: []*unstructured.Unstructured{cp}}
+
+	// The topology version wins for ClusterClass based Clusters.
+	p, err := CapiClusterObjectParams(context.Background(), r, mockMaintenanceCluster("Provisioned"))
+	assert.Nil(t, err)
+	assert.NotContains(t, p, "kubernetesVersion")
+
+	c := mockMaintenanceCluster("Provisioned")
+	unstructured.RemoveNestedField(c.Object, "spec", "topology")
+	assert.NotContains(t, CapiClusterParams(c), "kubernetesVersion")
+	p, err = CapiClusterObjectParams(context.Background(), r, c)
+	assert.Nil(t, err)
+	assert.Equal(t, "v1.28.5", p["kubernetesVersion"])
+}
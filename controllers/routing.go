@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var (
+	// RoutingRules map CAPI clusters to the Argo namespaces they get registered into.
+	// Clusters matching no rule are registered into ArgoNamespace of ArgoDefaultHub.
+	RoutingRules []RoutingRule
+)
+
+// ClusterSelector matches CAPI clusters by their namespace and/or their Cluster labels.
+// An empty selector matches every cluster.
+type ClusterSelector struct {
+	Namespaces    []string              `json:"namespaces,omitempty"`
+	LabelSelector *metav1.LabelSelector `json:"labelSelector,omitempty"`
+}
+
+// RoutingRule represents a single ClusterSelector --> Argo namespaces mapping.
+// Hub selects the cluster hosting these namespaces and defaults to ArgoDefaultHub.
+type RoutingRule struct {
+	ClusterSelector
+	Hub            string   `json:"hub,omitempty"`
+	ArgoNamespaces []string `json:"argoNamespaces"`
+}
+
+// Matches returns true if given cluster namespace and labels are selected.
+func (s ClusterSelector) Matches(namespace string, clusterLabels map[string]string) bool {
+	if len(s.Namespaces) > 0 && !containsString(s.Namespaces, namespace) {
+		return false
+	}
+	if s.LabelSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(s.LabelSelector)
+		if err != nil || !selector.Matches(labels.Set(clusterLabels)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks that the selector can be evaluated.
+func (s ClusterSelector) Validate() error {
+	if s.LabelSelector == nil {
+		return nil
+	}
+	_, err := metav1.LabelSelectorAsSelector(s.LabelSelector)
+	return err
+}
+
+// ValidateRoutingRules validates that every rule is usable and only references known hubs.
+func ValidateRoutingRules(rules []RoutingRule, hubs []ArgoHub) error {
+	for i, rule := range rules {
+		if len(rule.ArgoNamespaces) == 0 {
+			return fmt.Errorf("routing rule %d: missing argoNamespaces", i)
+		}
+		if rule.Hub != LocalHub && !containsHub(hubs, rule.Hub) {
+			return fmt.Errorf("routing rule %d: unknown hub %s", i, rule.Hub)
+		}
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("routing rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// RouteCluster returns the sorted Argo targets a cluster must be registered into.
+func RouteCluster(namespace string, clusterLabels map[string]string) []ArgoTarget {
+	set := map[ArgoTarget]struct{}{}
+	for _, rule := range RoutingRules {
+		if !rule.Matches(namespace, clusterLabels) {
+			continue
+		}
+		hub := rule.Hub
+		if hub == LocalHub {
+			hub = ArgoDefaultHub
+		}
+		for _, ns := range rule.ArgoNamespaces {
+			set[ArgoTarget{Hub: hub, Namespace: ns}] = struct{}{}
+		}
+	}
+	if len(set) == 0 {
+		return []ArgoTarget{{Hub: ArgoDefaultHub, Namespace: ArgoNamespace}}
+	}
+
+	targets := make([]ArgoTarget, 0, len(set))
+	for target := range set {
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].String() < targets[j].String()
+	})
+	return targets
+}
+
+// ClusterLabelsInUse reports whether routing, project, scope, server rewrite or approval
+// rules depend on Cluster labels, so label changes of CAPI Clusters must be watched.
+func ClusterLabelsInUse() bool {
+	var selectors []ClusterSelector
+	for _, rule := range RoutingRules {
+		selectors = append(selectors, rule.ClusterSelector)
+	}
+	for _, rule := range ProjectRules {
+		if rule.ProjectLabel != "" {
+			return true
+		}
+		selectors = append(selectors, rule.ClusterSelector)
+	}
+	for _, rule := range ScopeRules {
+		selectors = append(selectors, rule.ClusterSelector)
+	}
+	for _, rule := range ServerRewriteRules {
+		selectors = append(selectors, rule.ClusterSelector)
+	}
+	selectors = append(selectors, ApprovalRules...)
+	for _, selector := range selectors {
+		if selector.LabelSelector != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// containsTarget returns true if t is part of targets.
+func containsTarget(targets []ArgoTarget, t ArgoTarget) bool {
+	for _, v := range targets {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// containsHub returns true if a hub with the given name is defined.
+func containsHub(hubs []ArgoHub, name string) bool {
+	for _, hub := range hubs {
+		if hub.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString returns true if s is part of list.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestClusterSelectorMatches(t *testing.T) {
+	t.Parallel()
+	prodLabels := map[string]string{"env": "prod"}
+	tests := []struct {
+		testName      string
+		testMock      ClusterSelector
+		testNamespace string
+		testLabels    map[string]string
+		testExpected  bool
+	}{
+		{"test empty selector", ClusterSelector{}, "test", nil, true},
+		{"test matching namespace", ClusterSelector{Namespaces: []string{"test"}}, "test", nil, true},
+		{"test non-matching namespace", ClusterSelector{Namespaces: []string{"other"}}, "test", nil, false},
+		{"test matching labels", ClusterSelector{LabelSelector: &metav1.LabelSelector{MatchLabels: prodLabels}}, "test", prodLabels, true},
+		{"test non-matching labels", ClusterSelector{LabelSelector: &metav1.LabelSelector{MatchLabels: prodLabels}}, "test", nil, false},
+		{"test matching namespace and non-matching labels",
+			ClusterSelector{Namespaces: []string{"test"}, LabelSelector: &metav1.LabelSelector{MatchLabels: prodLabels}}, "test", nil, false},
+		{"test invalid selector", ClusterSelector{LabelSelector: &metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "env", Operator: "Bogus"}},
+		}}, "test", prodLabels, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.testExpected, tt.testMock.Matches(tt.testNamespace, tt.testLabels))
+		})
+	}
+}
+
+func TestRouteCluster(t *testing.T) {
+	oldRules := RoutingRules
+	defer func() { RoutingRules = oldRules }()
+
+	RoutingRules = []RoutingRule{
+		{ClusterSelector: ClusterSelector{Namespaces: []string{"tenant-a"}}, ArgoNamespaces: []string{"argocd-tenant-a"}},
+		{ClusterSelector: ClusterSelector{LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"platform": "true"}}},
+			ArgoNamespaces: []string{"argocd-platform", "argocd-tenant-a"}},
+	}
+
+	platform := ArgoTarget{Namespace: "argocd-platform"}
+	tenant := ArgoTarget{Namespace: "argocd-tenant-a"}
+	assert.Equal(t, []ArgoTarget{{Namespace: ArgoNamespace}}, RouteCluster("test", nil))
+	assert.Equal(t, []ArgoTarget{tenant}, RouteCluster("tenant-a", nil))
+	assert.Equal(t, []ArgoTarget{platform, tenant}, RouteCluster("tenant-a", map[string]string{"platform": "true"}))
+	assert.Equal(t, []ArgoTarget{platform, tenant}, RouteCluster("test", map[string]string{"platform": "true"}))
+
+	RoutingRules = append(RoutingRules, RoutingRule{
+		ClusterSelector: ClusterSelector{Namespaces: []string{"tenant-a"}},
+		Hub:             "hub-a",
+		ArgoNamespaces:  []string{"argocd"},
+	})
+	assert.Equal(t, []ArgoTarget{tenant, {Hub: "hub-a", Namespace: "argocd"}}, RouteCluster("tenant-a", nil))
+}
+
+func TestClusterLabelsInUse(t *testing.T) {
+	oldRouting, oldProject, oldScope, oldRewrite := RoutingRules, ProjectRules, ScopeRules, ServerRewriteRules
+	defer func() {
+		RoutingRules, ProjectRules, ScopeRules, ServerRewriteRules = oldRouting, oldProject, oldScope, oldRewrite
+	}()
+
+	byNamespace := ClusterSelector{Namespaces: []string{"tenant-a"}}
+	byLabels := ClusterSelector{LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"platform": "true"}}}
+	tests := []struct {
+		testName string
+		setup    func()
+		expected bool
+	}{
+		{"test no rules", func() {}, false},
+		{"test namespace selectors", func() {
+			RoutingRules = []RoutingRule{{ClusterSelector: byNamespace}}
+			ServerRewriteRules = []ServerRewriteRule{{ClusterSelector: byNamespace}}
+		}, false},
+		{"test routing label selector", func() { RoutingRules = []RoutingRule{{ClusterSelector: byLabels}} }, true},
+		{"test project label selector", func() { ProjectRules = []ProjectRule{{ClusterSelector: byLabels}} }, true},
+		{"test project label", func() { ProjectRules = []ProjectRule{{ProjectLabel: "team"}} }, true},
+		{"test scope label selector", func() { ScopeRules = []ScopeRule{{ClusterSelector: byLabels}} }, true},
+		{"test server rewrite label selector", func() { ServerRewriteRules = []ServerRewriteRule{{ClusterSelector: byLabels}} }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			RoutingRules, ProjectRules, ScopeRules, ServerRewriteRules = nil, nil, nil, nil
+			tt.setup()
+			assert.Equal(t, tt.expected, ClusterLabelsInUse())
+		})
+	}
+}
+
+func TestValidateRoutingRules(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		testName          string
+		testMock          []RoutingRule
+		testExpectedError bool
+	}{
+		{"test no rules", nil, false},
+		{"test valid rule", []RoutingRule{{ArgoNamespaces: []string{"argocd"}}}, false},
+		{"test rule without targets", []RoutingRule{{ClusterSelector: ClusterSelector{Namespaces: []string{"test"}}}}, true},
+		{"test rule with known hub", []RoutingRule{{Hub: "hub-a", ArgoNamespaces: []string{"argocd"}}}, false},
+		{"test rule with unknown hub", []RoutingRule{{Hub: "hub-b", ArgoNamespaces: []string{"argocd"}}}, true},
+		{"test rule with invalid selector", []RoutingRule{{
+			ClusterSelector: ClusterSelector{LabelSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "env", Operator: "Bogus"}},
+			}},
+			ArgoNamespaces: []string{"argocd"},
+		}}, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateRoutingRules(tt.testMock, []ArgoHub{{Name: "hub-a", KubeConfigPath: "/dev/null"}})
+			if tt.testExpectedError {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
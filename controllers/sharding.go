@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ShardStrategyLeastLoaded assigns clusters to the least loaded shard. Loads are read
+	// from the cache, so shards are only eventually balanced.
+	ShardStrategyLeastLoaded = "least-loaded"
+	// ShardStrategyConsistentHash places clusters by rendezvous hashing of their name.
+	ShardStrategyConsistentHash = "consistent-hash"
+	// ShardStrategyWeighted spreads clusters by the weight read from a Cluster label.
+	ShardStrategyWeighted = "weighted"
+
+	// ShardAnnotation on a CAPI Cluster pins the cluster to a given shard.
+	ShardAnnotation = "capi-to-argocd/shard"
+
+	// shardWeightLabel holds the weight of a cluster on its ArgoSecret.
+	shardWeightLabel = "capi-to-argocd/shard-weight"
+	// shardCountLabel holds the shard count the shard of an ArgoSecret was assigned with.
+	shardCountLabel = "capi-to-argocd/shard-count"
+)
+
+var (
+	// ArgoShards is the number of Argo application controller shards. Zero disables sharding.
+	ArgoShards int
+
+	// ArgoShardStrategy selects how clusters are assigned to shards.
+	ArgoShardStrategy string
+
+	// ArgoShardWeightLabel is the Cluster label holding the weight of a cluster
+	// when using the weighted strategy.
+	ArgoShardWeightLabel string
+)
+
+// ValidateSharding validates the sharding configuration.
+func ValidateSharding() error {
+	if ArgoShards < 0 {
+		return fmt.Errorf("invalid shard count %d", ArgoShards)
+	}
+	switch ArgoShardStrategy {
+	case ShardStrategyLeastLoaded, ShardStrategyConsistentHash:
+	case ShardStrategyWeighted:
+		if ArgoShardWeightLabel == "" {
+			return fmt.Errorf("weighted strategy requires a weight label")
+		}
+	default:
+		return fmt.Errorf("unknown strategy %q", ArgoShardStrategy)
+	}
+	return nil
+}
+
+// ShardWeight returns the weight of a cluster, defaulting to 1.
+func ShardWeight(clusterLabels map[string]string) int {
+	if ArgoShardStrategy != ShardStrategyWeighted {
+		return 1
+	}
+	weight, err := strconv.Atoi(clusterLabels[ArgoShardWeightLabel])
+	if err != nil || weight < 1 {
+		return 1
+	}
+	return weight
+}
+
+// ShardLoads sums the weights of ArgoSecrets per shard.
+func ShardLoads(secrets []corev1.Secret) []int {
+	loads := make([]int, ArgoShards)
+	for _, s := range secrets {
+		shard, err := strconv.Atoi(string(s.Data["shard"]))
+		if err != nil || shard < 0 || shard >= ArgoShards {
+			continue
+		}
+		weight, err := strconv.Atoi(s.Labels[shardWeightLabel])
+		if err != nil || weight < 1 {
+			weight = 1
+		}
+		loads[shard] += weight
+	}
+	return loads
+}
+
+// AssignShard returns the shard of a cluster of given weight, given the loads of all
+// shards, its current one including it. Valid current assignments are kept, unless
+// moving the cluster to the least loaded shard strictly improves balance, so changing
+// the shard count only moves as many clusters as needed and clusters of equal weights
+// never swap shards back and forth. A negative current shard means the cluster is not
+// assigned yet.
+func AssignShard(name string, current, weight int, loads []int) int {
+	count := len(loads)
+	if ArgoShardStrategy == ShardStrategyConsistentHash {
+		return rendezvousShard(name, count)
+	}
+
+	least := 0
+	for i := range loads {
+		if loads[i] < loads[least] {
+			least = i
+		}
+	}
+	if current < 0 || current >= count {
+		return least
+	}
+	if loads[current]-weight > loads[least] {
+		return least
+	}
+	return current
+}
+
+// rendezvousShard picks the shard with the highest hash for the name, which only
+// moves clusters of added or removed shards when the count changes.
+func rendezvousShard(name string, count int) int {
+	best, bestScore := 0, uint64(0)
+	for i := 0; i < count; i++ {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(name + "/" + strconv.Itoa(i)))
+		if score := h.Sum64(); i == 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// ResolveShard returns the shard a Cluster is pinned to by annotation, if valid.
+func ResolveShard(clusterAnnotations map[string]string) *int {
+	shard, err := strconv.Atoi(clusterAnnotations[ShardAnnotation])
+	if err != nil || shard < 0 || shard >= ArgoShards {
+		return nil
+	}
+	return &shard
+}
+
+// AssignArgoShard sets the shard of an ArgoCluster based on the cluster secrets already
+// living in its Argo namespace, managed or not. Pinned clusters keep their shard, and
+// assigned ones keep theirs until the shard count changes. Secrets are read from the
+// cache, so clusters registered at once may be assigned the same shard.
+func AssignArgoShard(ctx context.Context, c client.Client, a *ArgoCluster, existing *corev1.Secret) error {
+	if ArgoShards == 0 || a.ClusterShard != nil {
+		return nil
+	}
+
+	current := -1
+	if existing != nil {
+		if shard, err := strconv.Atoi(string(existing.Data["shard"])); err == nil {
+			current = shard
+		}
+		if current >= 0 && current < ArgoShards && existing.Labels[shardCountLabel] == strconv.Itoa(ArgoShards) {
+			a.ClusterShard = &current
+			return nil
+		}
+	}
+
+	secretList := &corev1.SecretList{}
+	listOptions := []client.ListOption{
+		client.InNamespace(a.NamespacedName.Namespace),
+		client.MatchingLabels{"argocd.argoproj.io/secret-type": "cluster"},
+	}
+	if err := c.List(ctx, secretList, listOptions...); err != nil {
+		return err
+	}
+
+	shard := AssignShard(a.NamespacedName.Name, current, a.ClusterShardWeight, ShardLoads(secretList.Items))
+	a.ClusterShard = &shard
+	return nil
+}
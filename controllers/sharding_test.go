@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// mockShardSecret returns an ArgoSecret assigned to given shard with given weight.
+func mockShardSecret(name, shard, weight string) corev1.Secret {
+	return corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{shardWeightLabel: weight}},
+		Data:       map[string][]byte{"shard": []byte(shard)},
+	}
+}
+
+func TestShardLoads(t *testing.T) {
+	oldShards := ArgoShards
+	defer func() { ArgoShards = oldShards }()
+	ArgoShards = 3
+
+	secrets := []corev1.Secret{
+		mockShardSecret("a", "0", "1"),
+		mockShardSecret("b", "0", "4"),
+		mockShardSecret("c", "2", ""),
+		mockShardSecret("d", "5", "1"),
+		mockShardSecret("e", "", "1"),
+		mockShardSecret("self", "1", "1"),
+	}
+	assert.Equal(t, []int{5, 1, 1}, ShardLoads(secrets))
+}
+
+func TestAssignShard(t *testing.T) {
+	oldStrategy := ArgoShardStrategy
+	defer func() { ArgoShardStrategy = oldStrategy }()
+	ArgoShardStrategy = ShardStrategyLeastLoaded
+
+	tests := []struct {
+		testName          string
+		testCurrent       int
+		testWeight        int
+		testLoads         []int
+		testExpectedShard int
+	}{
+		{"test new cluster goes to least loaded shard", -1, 1, []int{2, 1, 1}, 1},
+		{"test balanced cluster keeps its shard", 0, 1, []int{2, 1, 2}, 0},
+		{"test cluster keeps shard when moving does not help", 2, 1, []int{1, 1, 2}, 2},
+		{"test cluster moves to an added shard", 0, 1, []int{4, 2, 0}, 2},
+		{"test cluster on a removed shard is reassigned", 4, 1, []int{2, 1}, 1},
+		// Moving would only swap the loads of both shards, and move the cluster back next time.
+		{"test equal weights on two shards keep their shard", 0, 1, []int{2, 1}, 0},
+		{"test equal heavy weights on two shards keep their shard", 0, 2, []int{4, 2}, 0},
+		{"test heavy cluster moves to an empty shard", 0, 2, []int{4, 0}, 1},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			assert.Equal(t, tt.testExpectedShard, AssignShard("cluster", tt.testCurrent, tt.testWeight, tt.testLoads))
+		})
+	}
+}
+
+func TestAssignArgoShard(t *testing.T) {
+	oldShards, oldStrategy := ArgoShards, ArgoShardStrategy
+	defer func() { ArgoShards, ArgoShardStrategy = oldShards, oldStrategy }()
+	ArgoShards, ArgoShardStrategy = 2, ShardStrategyLeastLoaded
+
+	// Cluster secrets not managed by the operator count towards the loads.
+	secrets := map[types.NamespacedName]*corev1.Secret{}
+	for _, name := range []string{"manual-a", "manual-b"} {
+		s := mockShardSecret(name, "0", "")
+		s.Namespace, s.Labels["argocd.argoproj.io/secret-type"] = ArgoNamespace, "cluster"
+		secrets[types.NamespacedName{Name: name, Namespace: ArgoNamespace}] = &s
+	}
+	c := &mockClient{secrets: secrets}
+
+	tests := []struct {
+		testName      string
+		existing      *corev1.Secret
+		expectedShard int
+	}{
+		{"test new cluster", nil, 1},
+		{"test assigned cluster is kept", &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{shardCountLabel: "2"}},
+			Data:       map[string][]byte{"shard": []byte("0")},
+		}, 0},
+		{"test shard count changed", &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{shardCountLabel: "1"}},
+			Data:       map[string][]byte{"shard": []byte("0")},
+		}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			a := MockArgoCluster(true)
+			a.NamespacedName.Namespace = ArgoNamespace
+			a.ClusterShardWeight = 1
+			assert.Nil(t, AssignArgoShard(context.Background(), c, a, tt.existing))
+			assert.Equal(t, tt.expectedShard, *a.ClusterShard)
+		})
+	}
+}
+
+func TestAssignShardConsistentHash(t *testing.T) {
+	oldStrategy := ArgoShardStrategy
+	defer func() { ArgoShardStrategy = oldStrategy }()
+	ArgoShardStrategy = ShardStrategyConsistentHash
+
+	moved := 0
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+		before := AssignShard(name, -1, 1, make([]int, 3))
+		after := AssignShard(name, before, 1, make([]int, 4))
+		assert.Equal(t, before, AssignShard(name, -1, 1, make([]int, 3)))
+		if before != after {
+			// Growing the shard count only moves clusters to the added shard.
+			assert.Equal(t, 3, after)
+			moved++
+		}
+	}
+	assert.Less(t, moved, 10)
+}
+
+func TestShardWeight(t *testing.T) {
+	oldStrategy, oldLabel := ArgoShardStrategy, ArgoShardWeightLabel
+	defer func() { ArgoShardStrategy, ArgoShardWeightLabel = oldStrategy, oldLabel }()
+	ArgoShardWeightLabel = "size"
+
+	ArgoShardStrategy = ShardStrategyLeastLoaded
+	assert.Equal(t, 1, ShardWeight(map[string]string{"size": "5"}))
+
+	ArgoShardStrategy = ShardStrategyWeighted
+	assert.Equal(t, 5, ShardWeight(map[string]string{"size": "5"}))
+	assert.Equal(t, 1, ShardWeight(map[string]string{"size": "large"}))
+	assert.Equal(t, 1, ShardWeight(nil))
+}
+
+func TestResolveShard(t *testing.T) {
+	oldShards := ArgoShards
+	defer func() { ArgoShards = oldShards }()
+	ArgoShards = 3
+
+	assert.Nil(t, ResolveShard(nil))
+	assert.Nil(t, ResolveShard(map[string]string{ShardAnnotation: "3"}))
+	assert.Nil(t, ResolveShard(map[string]string{ShardAnnotation: "first"}))
+	assert.Equal(t, 2, *ResolveShard(map[string]string{ShardAnnotation: "2"}))
+}
+
+func TestValidateSharding(t *testing.T) {
+	oldShards, oldStrategy, oldLabel := ArgoShards, ArgoShardStrategy, ArgoShardWeightLabel
+	defer func() { ArgoShards, ArgoShardStrategy, ArgoShardWeightLabel = oldShards, oldStrategy, oldLabel }()
+
+	ArgoShards, ArgoShardStrategy, ArgoShardWeightLabel = 3, ShardStrategyLeastLoaded, ""
+	assert.Nil(t, ValidateSharding())
+
+	ArgoShardStrategy = ShardStrategyWeighted
+	assert.NotNil(t, ValidateSharding())
+	ArgoShardWeightLabel = "size"
+	assert.Nil(t, ValidateSharding())
+
+	ArgoShardStrategy = "random"
+	assert.NotNil(t, ValidateSharding())
+
+	ArgoShards, ArgoShardStrategy = -1, ShardStrategyLeastLoaded
+	assert.NotNil(t, ValidateSharding())
+}
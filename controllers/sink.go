@@ -0,0 +1,345 @@
+package controllers
+
+import (
+	"context"
+	goErr "errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// SinkArgo registers clusters as ArgoSecrets.
+	SinkArgo = "argo"
+	// SinkFlux publishes clusters as Flux kubeconfig secrets and bootstraps.
+	SinkFlux = "flux"
+	// SinkSveltos registers clusters as Sveltos SveltosClusters.
+	SinkSveltos = "sveltos"
+	// SinkFleet registers clusters as Rancher Fleet Clusters.
+	SinkFleet = "fleet"
+	// SinkKarmada registers clusters as Karmada Clusters.
+	SinkKarmada = "karmada"
+)
+
+var (
+	// Sinks register discovered clusters into multi-cluster tools, Argo by default.
+	Sinks = []Sink{ArgoSecretSink{}}
+
+	// SinkConfigs are the configured sinks. An empty list only enables the Argo sink.
+	SinkConfigs []SinkConfig
+
+	// ErrNotSinkOwned is returned when a sink object already exists without being managed by a sink.
+	ErrNotSinkOwned = goErr.New("object exists and is not managed by the operator")
+)
+
+// Sink registers clusters into a multi-cluster tool.
+type Sink interface {
+	// Name identifies the sink in configuration and on the labels of its objects.
+	Name() string
+	// Reconcile registers a cluster, removing objects it no longer needs when GC is enabled.
+	Reconcile(ctx context.Context, r *Capi2Argo, log logr.Logger, c *CapiCluster, a *ArgoCluster) error
+	// GarbageCollect deletes all objects registered for the given kubeconfig secret.
+	GarbageCollect(ctx context.Context, r *Capi2Argo, log logr.Logger, source client.ObjectKey) error
+}
+
+// SinkConfig enables a sink.
+type SinkConfig struct {
+	Name string `json:"name"`
+	// Namespace overrides the namespace the sink writes its objects to, when supported.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// SetupSinks validates the sink configs and registers them. The Flux sink is also
+// enabled when FluxSink is configured.
+func SetupSinks(configs []SinkConfig) error {
+	if len(configs) == 0 {
+		configs = []SinkConfig{{Name: SinkArgo}}
+	}
+	if FluxSink != nil && !containsSink(configs, SinkFlux) {
+		configs = append(configs, SinkConfig{Name: SinkFlux})
+	}
+
+	sinks := make([]Sink, 0, len(configs))
+	names := map[string]bool{}
+	for i, config := range configs {
+		if names[config.Name] {
+			return fmt.Errorf("sink %d: duplicate name %s", i, config.Name)
+		}
+		names[config.Name] = true
+
+		switch config.Name {
+		case SinkArgo:
+			sinks = append(sinks, ArgoSecretSink{})
+		case SinkFlux:
+			if FluxSink == nil {
+				FluxSink = &FluxSinkConfig{}
+			}
+			sinks = append(sinks, FluxSecretSink{})
+		case SinkSveltos:
+			sinks = append(sinks, SveltosClusterSink{Namespace: config.Namespace})
+		case SinkFleet:
+			sinks = append(sinks, NewFleetClusterSink(config.Namespace))
+		case SinkKarmada:
+			sinks = append(sinks, NewKarmadaClusterSink(config.Namespace))
+		default:
+			return fmt.Errorf("sink %d: unknown name %q", i, config.Name)
+		}
+	}
+	Sinks = sinks
+	return nil
+}
+
+// containsSink reports whether given sink is configured.
+func containsSink(configs []SinkConfig, name string) bool {
+	for _, config := range configs {
+		if config.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SinkOwnedLabel returns the label marking the objects managed by a sink, other than ArgoSecrets.
+func SinkOwnedLabel(sink string) string {
+	return "capi-to-argocd/" + sink + "-owned"
+}
+
+// IsSinkOwned reports whether an object is managed by any sink.
+func IsSinkOwned(labels map[string]string) bool {
+	for key, value := range labels {
+		if value != "true" || !strings.HasPrefix(key, "capi-to-argocd/") {
+			continue
+		}
+		if key == "capi-to-argocd/owned" || strings.HasSuffix(key, "-owned") {
+			return true
+		}
+	}
+	return false
+}
+
+// SinkLabels returns the labels of the objects a sink manages for an ArgoCluster.
+func SinkLabels(sink string, a *ArgoCluster) map[string]string {
+	labels := map[string]string{SinkOwnedLabel(sink): "true"}
+	for key, value := range a.ClusterLabels {
+		labels[key] = value
+	}
+	return labels
+}
+
+// ArgoSecretSink registers clusters as ArgoSecrets into every Argo target they are routed to.
+type ArgoSecretSink struct{}
+
+// Name implements Sink.
+func (ArgoSecretSink) Name() string { return SinkArgo }
+
+// Reconcile implements Sink.
+func (ArgoSecretSink) Reconcile(ctx context.Context, r *Capi2Argo, log logr.Logger, c *CapiCluster, a *ArgoCluster) error {
+	targets := RouteCluster(c.Namespace, c.Labels)
+	for _, target := range targets {
+		hubClient, err := r.HubClient(ctx, target.Hub)
+		if err != nil {
+			log.Error(err, "Failed to get Argo hub client", "target", target.String())
+			return err
+		}
+		targetCluster := *a
+		targetCluster.NamespacedName.Namespace = target.Namespace
+		argoSecret, err := r.ReconcileArgoSecret(ctx, hubClient, &targetCluster)
+		if err != nil {
+			return err
+		}
+		if ArgoBootstrap != nil && argoSecret != nil {
+			if err := r.BootstrapArgoCluster(ctx, hubClient, log.WithValues("target", target.String()), &targetCluster, argoSecret); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Remove ArgoSecrets from Argo targets the cluster is no longer routed to.
+	if EnableGarbageCollection {
+		return r.GarbageCollect(ctx, log, ArgoClusterSource(a), targets)
+	}
+	return nil
+}
+
+// GarbageCollect implements Sink.
+func (ArgoSecretSink) GarbageCollect(ctx context.Context, r *Capi2Argo, log logr.Logger, source client.ObjectKey) error {
+	return r.GarbageCollect(ctx, log, source, nil)
+}
+
+// GarbageCollectSinks deletes the objects of every sink registered for the given kubeconfig secret.
+func (r *Capi2Argo) GarbageCollectSinks(ctx context.Context, log logr.Logger, source client.ObjectKey) error {
+	var errs []error
+	for _, sink := range Sinks {
+		if err := sink.GarbageCollect(ctx, r, log.WithValues("sink", sink.Name()), source); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return goErr.Join(errs...)
+}
+
+// ArgoClusterSource returns the kubeconfig secret an ArgoCluster was generated from.
+func ArgoClusterSource(a *ArgoCluster) client.ObjectKey {
+	return client.ObjectKey{
+		Name:      a.ClusterLabels["capi-to-argocd/cluster-secret-name"],
+		Namespace: a.ClusterLabels["capi-to-argocd/cluster-namespace"],
+	}
+}
+
+// reconcileOwnedSecret creates or updates a secret managed by given sink. Existing
+// secrets not managed by that sink, including ArgoSecrets and secrets of other sinks,
+// are left alone.
+func (r *Capi2Argo) reconcileOwnedSecret(ctx context.Context, log logr.Logger, sink string, desired *corev1.Secret) error {
+	log = log.WithValues("secret", client.ObjectKeyFromObject(desired))
+	var existing corev1.Secret
+	err := r.Get(ctx, client.ObjectKeyFromObject(desired), &existing)
+	if errors.IsNotFound(err) {
+		if err := r.Create(ctx, desired); err != nil {
+			log.Error(err, "Failed to create sink secret")
+			return err
+		}
+		log.Info("Created new sink secret")
+		return nil
+	}
+	if err != nil {
+		log.Error(err, "Failed to fetch sink secret")
+		return err
+	}
+	if existing.Labels[SinkOwnedLabel(sink)] != "true" {
+		log.Info("Not managed by Controller, skipping..")
+		return ErrNotSinkOwned
+	}
+
+	changed := SyncArgoSecretLabels(&existing, desired)
+	if !equality.Semantic.DeepEqual(existing.Data, desired.Data) {
+		existing.Data = desired.Data
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	if err := r.Update(ctx, &existing); err != nil {
+		log.Error(err, "Failed to update sink secret")
+		return err
+	}
+	log.Info("Updated successfully of sink secret")
+	return nil
+}
+
+// reconcileOwnedObject creates or updates an object managed by given sink. Spec fields
+// that are not desired are left as is, keeping values defaulted by the tool. Existing
+// objects not managed by that sink are left alone.
+func (r *Capi2Argo) reconcileOwnedObject(ctx context.Context, log logr.Logger, sink string, desired *unstructured.Unstructured) error {
+	log = log.WithValues("object", client.ObjectKeyFromObject(desired), "kind", desired.GetKind())
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(desired.GroupVersionKind())
+	err := r.Get(ctx, client.ObjectKeyFromObject(desired), existing)
+	if errors.IsNotFound(err) {
+		if err := r.Create(ctx, desired); err != nil {
+			log.Error(err, "Failed to create sink object")
+			return err
+		}
+		log.Info("Created new sink object")
+		return nil
+	}
+	if err != nil {
+		log.Error(err, "Failed to fetch sink object")
+		return err
+	}
+	if existing.GetLabels()[SinkOwnedLabel(sink)] != "true" {
+		log.Info("Not managed by Controller, skipping..")
+		return ErrNotSinkOwned
+	}
+
+	spec, _, _ := unstructured.NestedMap(existing.Object, "spec")
+	if spec == nil {
+		spec = map[string]interface{}{}
+	}
+	changed := false
+	desiredSpec, _, _ := unstructured.NestedMap(desired.Object, "spec")
+	for key, value := range desiredSpec {
+		if !equality.Semantic.DeepEqual(spec[key], value) {
+			spec[key] = value
+			changed = true
+		}
+	}
+	labels := existing.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	for key, value := range desired.GetLabels() {
+		if labels[key] != value {
+			labels[key] = value
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	existing.SetLabels(labels)
+	if err := unstructured.SetNestedMap(existing.Object, spec, "spec"); err != nil {
+		return err
+	}
+	if err := r.Update(ctx, existing); err != nil {
+		log.Error(err, "Failed to update sink object")
+		return err
+	}
+	log.Info("Updated successfully of sink object")
+	return nil
+}
+
+// garbageCollectSink deletes the secrets and objects of given kinds a sink manages for a
+// kubeconfig secret, unless keep reports they are still desired. A nil keep deletes all of them.
+func (r *Capi2Argo) garbageCollectSink(ctx context.Context, log logr.Logger, sink string, source client.ObjectKey, kinds []schema.GroupVersionKind, keep func(client.Object) bool) error {
+	listOption := client.MatchingLabels{
+		SinkOwnedLabel(sink):                 "true",
+		"capi-to-argocd/cluster-secret-name": source.Name,
+		"capi-to-argocd/cluster-namespace":   source.Namespace,
+	}
+
+	var objects []client.Object
+	secretList := &corev1.SecretList{}
+	if err := r.List(ctx, secretList, listOption); err != nil {
+		log.Error(err, "Failed to list sink secrets")
+		return err
+	}
+	for i := range secretList.Items {
+		objects = append(objects, &secretList.Items[i])
+	}
+	for _, gvk := range kinds {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		err := r.List(ctx, list, listOption)
+		if meta.IsNoMatchError(err) {
+			continue
+		}
+		if err != nil {
+			log.Error(err, "Failed to list sink objects", "kind", gvk.Kind)
+			return err
+		}
+		for i := range list.Items {
+			objects = append(objects, &list.Items[i])
+		}
+	}
+
+	var errs []error
+	for _, obj := range objects {
+		if keep != nil && keep(obj) {
+			continue
+		}
+		if err := r.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			log.Error(err, "Failed to delete sink object", "object", client.ObjectKeyFromObject(obj))
+			errs = append(errs, err)
+			continue
+		}
+		log.Info("Deleted successfully of sink object", "object", client.ObjectKeyFromObject(obj))
+	}
+	return goErr.Join(errs...)
+}
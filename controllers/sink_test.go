@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestSetupSinks(t *testing.T) {
+	defer func(sinks []Sink, flux *FluxSinkConfig) { Sinks, FluxSink = sinks, flux }(Sinks, FluxSink)
+
+	tests := []struct {
+		testName  string
+		configs   []SinkConfig
+		flux      *FluxSinkConfig
+		expected  []Sink
+		expectErr bool
+	}{
+		{"test default argo sink", nil, nil, []Sink{ArgoSecretSink{}}, false},
+		{"test flux config enables flux sink", nil, &FluxSinkConfig{}, []Sink{ArgoSecretSink{}, FluxSecretSink{}}, false},
+		{"test defaulted namespaces", []SinkConfig{{Name: SinkFleet}, {Name: SinkKarmada}, {Name: SinkSveltos}}, nil, []Sink{
+			FleetClusterSink{Namespace: "fleet-default"},
+			KarmadaClusterSink{SecretNamespace: "karmada-cluster"},
+			SveltosClusterSink{},
+		}, false},
+		{"test configured namespace", []SinkConfig{{Name: SinkFleet, Namespace: "fleet-prod"}}, nil, []Sink{FleetClusterSink{Namespace: "fleet-prod"}}, false},
+		{"test unknown sink", []SinkConfig{{Name: "ocm"}}, nil, nil, true},
+		{"test duplicate sink", []SinkConfig{{Name: SinkArgo}, {Name: SinkArgo}}, nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			Sinks, FluxSink = nil, tt.flux
+			err := SetupSinks(tt.configs)
+			if tt.expectErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, Sinks)
+		})
+	}
+}
+
+func TestSetupSinksDefaultsFluxConfig(t *testing.T) {
+	defer func(sinks []Sink, flux *FluxSinkConfig) { Sinks, FluxSink = sinks, flux }(Sinks, FluxSink)
+
+	FluxSink = nil
+	assert.Nil(t, SetupSinks([]SinkConfig{{Name: SinkFlux}}))
+	assert.NotNil(t, FluxSink)
+}
+
+func TestIsSinkOwned(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		testName string
+		labels   map[string]string
+		expected bool
+	}{
+		{"test ArgoSecret", map[string]string{"capi-to-argocd/owned": "true"}, true},
+		{"test sink secret", map[string]string{SinkOwnedLabel(SinkFleet): "true"}, true},
+		{"test foreign owned label", map[string]string{"example.com/flux-owned": "true"}, false},
+		{"test unowned", map[string]string{"capi-to-argocd/owned": "false"}, false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.expected, IsSinkOwned(tt.labels))
+		})
+	}
+}
+
+func TestSinkLabels(t *testing.T) {
+	t.Parallel()
+	a := MockArgoCluster(true)
+	labels := SinkLabels(SinkSveltos, a)
+	assert.Equal(t, "true", labels["capi-to-argocd/sveltos-owned"])
+	assert.Equal(t, "test-kubeconfig", labels["capi-to-argocd/cluster-secret-name"])
+	assert.Equal(t, "test", ArgoClusterSource(a).Namespace)
+	assert.NotContains(t, labels, "capi-to-argocd/owned")
+}
+
+func TestNewSinkObject(t *testing.T) {
+	t.Parallel()
+	a := MockArgoCluster(true)
+	obj := newSinkObject(SinkFleet, FleetClusterGVK, "cluster-test", "fleet-default", a, map[string]interface{}{"kubeConfigSecret": "cluster-test-kubeconfig"})
+	assert.Equal(t, FleetClusterGVK, obj.GroupVersionKind())
+	assert.Equal(t, "fleet-default", obj.GetNamespace())
+	assert.Equal(t, "true", obj.GetLabels()[SinkOwnedLabel(SinkFleet)])
+	assert.True(t, keepNamespace("fleet-default")(obj))
+	assert.False(t, keepNamespace("fleet-prod")(obj))
+}
+
+func TestReconcileOwnedSecret(t *testing.T) {
+	tests := []struct {
+		testName     string
+		labels       map[string]string
+		expectedErr  error
+		expectedData string
+	}{
+		{"test sink owned", map[string]string{SinkOwnedLabel(SinkFleet): "true"}, nil, "desired"},
+		{"test not sink owned", map[string]string{"app": "fleet"}, ErrNotSinkOwned, "existing"},
+		{"test ArgoSecret", map[string]string{"capi-to-argocd/owned": "true"}, ErrNotSinkOwned, "existing"},
+		{"test owned by other sink", map[string]string{SinkOwnedLabel(SinkFlux): "true"}, ErrNotSinkOwned, "existing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			key := types.NamespacedName{Name: "cluster-test-kubeconfig", Namespace: "fleet-default"}
+			existing := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace, Labels: tt.labels},
+				Data:       map[string][]byte{"value": []byte("existing")},
+			}
+			c := &mockClient{secrets: map[types.NamespacedName]*corev1.Secret{key: existing}}
+			r := &Capi2Argo{Client: c, Log: logr.Discard()}
+
+			desired := existing.DeepCopy()
+			desired.Labels = map[string]string{SinkOwnedLabel(SinkFleet): "true"}
+			desired.Data = map[string][]byte{"value": []byte("desired")}
+			err := r.reconcileOwnedSecret(context.Background(), logr.Discard(), SinkFleet, desired)
+			assert.Equal(t, tt.expectedErr, err)
+			assert.Equal(t, tt.expectedData, string(c.secrets[key].Data["value"]))
+		})
+	}
+}
+
+func TestReconcileOwnedObjectNotSinkOwned(t *testing.T) {
+	t.Parallel()
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(FleetClusterGVK)
+	existing.SetName("cluster-test")
+	existing.SetNamespace("fleet-default")
+	r := &Capi2Argo{Client: &mockClient{objects: []*unstructured.Unstructured{existing}}, Log: logr.Discard()}
+
+	desired := newSinkObject(SinkFleet, FleetClusterGVK, "cluster-test", "fleet-default", MockArgoCluster(true), map[string]interface{}{"kubeConfigSecret": "cluster-test-kubeconfig"})
+	assert.Equal(t, ErrNotSinkOwned, r.reconcileOwnedObject(context.Background(), logr.Discard(), SinkFleet, desired))
+}
+
+func TestKarmadaSinkInArgoNamespace(t *testing.T) {
+	a := MockArgoCluster(true)
+	a.ClusterConfig.BearerToken = "token"
+	argoSecret, err := a.ConvertToSecret()
+	assert.Nil(t, err)
+	key := client.ObjectKeyFromObject(argoSecret)
+	c := &mockClient{secrets: map[types.NamespacedName]*corev1.Secret{key: argoSecret.DeepCopy()}}
+	r := &Capi2Argo{Client: c, Log: logr.Discard()}
+
+	// Karmada secrets are named after the ArgoSecret, which must be left alone.
+	sink := NewKarmadaClusterSink(key.Namespace)
+	assert.Equal(t, ErrNotSinkOwned, sink.Reconcile(context.Background(), r, logr.Discard(), nil, a))
+	assert.Equal(t, argoSecret.Data, c.secrets[key].Data)
+	assert.Equal(t, argoSecret.Labels, c.secrets[key].Labels)
+}
+
+func TestSveltosSecretName(t *testing.T) {
+	assert.Empty(t, SourceAdaptersForName("test"+sveltosSecretSuffix))
+}
@@ -0,0 +1,295 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// SourceLabel on an ArgoSecret holds the name of the source adapter it was generated by.
+const SourceLabel = "capi-to-argocd/source"
+
+var (
+	// SourceAdapters discover the kubeconfig secrets clusters are registered from,
+	// in order of precedence. The CAPI adapter always comes first, followed by
+	// enabled built-in adapters and generic ones.
+	SourceAdapters = []SourceAdapter{CapiSourceAdapter{}}
+
+	// GenericSourceAdapters are the configured adapters added after the built-in ones.
+	GenericSourceAdapters []*GenericSourceAdapter
+)
+
+// SourceAdapter describes how kubeconfig secrets of a cluster provider are
+// discovered and turned into clusters.
+type SourceAdapter interface {
+	// Name identifies the adapter on generated ArgoSecrets.
+	Name() string
+	// ParseSecretName returns the cluster a secret name belongs to, if it may be a
+	// kubeconfig secret of the adapter. It also works for deleted secrets.
+	ParseSecretName(name string) (string, bool)
+	// Validate checks that the secret is a kubeconfig secret of the adapter.
+	Validate(s *corev1.Secret) error
+	// ClusterName returns the name of the cluster of a valid kubeconfig secret.
+	ClusterName(s *corev1.Secret) string
+	// ClusterSecrets returns the names of all kubeconfig secrets of a cluster, in order
+	// of preference. An empty list means the cluster only has a single secret.
+	ClusterSecrets(cluster string, clusterAnnotations map[string]string) []string
+	// KubeConfig returns the raw kubeconfig held by a valid kubeconfig secret.
+	KubeConfig(s *corev1.Secret) []byte
+	// FetchMetadata describes the cluster from the object owning it. It returns
+	// ErrClusterNotReady while the cluster must not be registered yet.
+	FetchMetadata(ctx context.Context, r client.Reader, namespace, cluster string) (ClusterMetadata, error)
+}
+
+// ErrClusterNotReady is returned by source adapters for clusters that are not ready to be registered.
+var ErrClusterNotReady = errors.New("cluster is not ready")
+
+// ClusterMetadata describes a cluster beyond its kubeconfig.
+type ClusterMetadata struct {
+	Labels      map[string]string
+	Annotations map[string]string
+	// ArgoLabels are added to the ArgoSecrets of the cluster.
+	ArgoLabels map[string]string
+	// ArgoAnnotations are added to the ArgoSecrets of the cluster.
+	ArgoAnnotations map[string]string
+}
+
+// CapiSourceAdapter sources CAPI generated <cluster>-kubeconfig and
+// <cluster>-user-kubeconfig secrets, enriched by the CAPI Cluster object.
+type CapiSourceAdapter struct{}
+
+// Name implements SourceAdapter.
+func (CapiSourceAdapter) Name() string { return "capi" }
+
+// ParseSecretName implements SourceAdapter.
+func (CapiSourceAdapter) ParseSecretName(name string) (string, bool) {
+	if !ValidateCapiNaming(types.NamespacedName{Name: name}) {
+		return "", false
+	}
+	cluster, _ := ParseCapiSecretName(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	return cluster, true
+}
+
+// Validate implements SourceAdapter.
+func (CapiSourceAdapter) Validate(s *corev1.Secret) error {
+	return ValidateCapiSecret(s)
+}
+
+// ClusterName implements SourceAdapter.
+func (CapiSourceAdapter) ClusterName(s *corev1.Secret) string {
+	cluster, _ := ParseCapiSecretName(s)
+	return cluster
+}
+
+// ClusterSecrets implements SourceAdapter, preferring the selected kubeconfig variant.
+func (CapiSourceAdapter) ClusterSecrets(cluster string, clusterAnnotations map[string]string) []string {
+	selected := ResolveKubeConfigVariant(clusterAnnotations)
+	other := KubeConfigVariantUser
+	if selected == KubeConfigVariantUser {
+		other = KubeConfigVariantAdmin
+	}
+	return []string{KubeConfigSecretName(cluster, selected), KubeConfigSecretName(cluster, other)}
+}
+
+// KubeConfig implements SourceAdapter.
+func (CapiSourceAdapter) KubeConfig(s *corev1.Secret) []byte {
+	return s.Data["value"]
+}
+
+// FetchMetadata implements SourceAdapter, mapping the ClusterClass topology of the
+// CAPI Cluster to Argo labels and annotations according to TopologyRules, and marking
+// the cluster while under maintenance.
+func (CapiSourceAdapter) FetchMetadata(ctx context.Context, r client.Reader, namespace, cluster string) (ClusterMetadata, error) {
+	owner, err := fetchOwner(ctx, r, CapiClusterGVK, types.NamespacedName{Name: cluster, Namespace: namespace})
+	if owner == nil || err != nil {
+		return ClusterMetadata{}, err
+	}
+	metadata := ClusterMetadata{Labels: owner.GetLabels(), Annotations: owner.GetAnnotations()}
+	metadata.ArgoLabels, metadata.ArgoAnnotations = TopologyMetadata(owner, TopologyRules)
+	if Maintenance != nil {
+		reason, err := ClusterMaintenance(ctx, r, owner, Maintenance.GetPhases())
+		if err != nil {
+			return ClusterMetadata{}, err
+		}
+		if reason != "" {
+			labels, annotations := Maintenance.MaintenanceMetadata(reason)
+			for key, value := range labels {
+				metadata.ArgoLabels[key] = value
+			}
+			for key, value := range annotations {
+				metadata.ArgoAnnotations[key] = value
+			}
+		}
+	}
+	return metadata, nil
+}
+
+// GenericSourceAdapter sources kubeconfig secrets described by configuration, such as
+// vcluster, Kamaji TenantControlPlane, k0smotron or Crossplane connection secrets.
+type GenericSourceAdapter struct {
+	AdapterName string `json:"name"`
+	// SecretType restricts the adapter to secrets of a given type, when set.
+	SecretType corev1.SecretType `json:"secretType,omitempty"`
+	// NamePattern matches secret names, its first capture group being the cluster name.
+	NamePattern string `json:"namePattern"`
+	// Key holds the kubeconfig in the secret.
+	Key string `json:"key"`
+	// Owner is the kind of the object named after the cluster whose labels and
+	// annotations describe the cluster, when set.
+	Owner *schema.GroupVersionKind `json:"owner,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Name implements SourceAdapter.
+func (g *GenericSourceAdapter) Name() string { return g.AdapterName }
+
+// ParseSecretName implements SourceAdapter.
+func (g *GenericSourceAdapter) ParseSecretName(name string) (string, bool) {
+	m := g.pattern.FindStringSubmatch(name)
+	if m == nil || m[1] == "" {
+		return "", false
+	}
+	return m[1], true
+}
+
+// Validate implements SourceAdapter.
+func (g *GenericSourceAdapter) Validate(s *corev1.Secret) error {
+	if g.SecretType != "" && s.Type != g.SecretType {
+		return errors.New("wrong secret type")
+	}
+	if _, ok := s.Data[g.Key]; !ok {
+		return errors.New("wrong secret key")
+	}
+	return nil
+}
+
+// ClusterName implements SourceAdapter.
+func (g *GenericSourceAdapter) ClusterName(s *corev1.Secret) string {
+	cluster, _ := g.ParseSecretName(s.Name)
+	return cluster
+}
+
+// ClusterSecrets implements SourceAdapter.
+func (g *GenericSourceAdapter) ClusterSecrets(string, map[string]string) []string {
+	return nil
+}
+
+// KubeConfig implements SourceAdapter.
+func (g *GenericSourceAdapter) KubeConfig(s *corev1.Secret) []byte {
+	return s.Data[g.Key]
+}
+
+// FetchMetadata implements SourceAdapter.
+func (g *GenericSourceAdapter) FetchMetadata(ctx context.Context, r client.Reader, namespace, cluster string) (ClusterMetadata, error) {
+	if g.Owner == nil {
+		return ClusterMetadata{}, nil
+	}
+	return FetchOwnerMetadata(ctx, r, *g.Owner, types.NamespacedName{Name: cluster, Namespace: namespace})
+}
+
+// Compile validates the adapter configuration and prepares its name pattern.
+func (g *GenericSourceAdapter) Compile() error {
+	if g.AdapterName == "" || g.NamePattern == "" || g.Key == "" {
+		return errors.New("name, namePattern and key must be set")
+	}
+	pattern, err := regexp.Compile(g.NamePattern)
+	if err != nil {
+		return err
+	}
+	if pattern.NumSubexp() < 1 {
+		return errors.New("namePattern must capture the cluster name")
+	}
+	if g.Owner != nil && (g.Owner.Version == "" || g.Owner.Kind == "") {
+		return errors.New("owner must set version and kind")
+	}
+	g.pattern = pattern
+	return nil
+}
+
+// SetupSourceAdapters validates the generic adapters and registers them after the
+// built-in ones.
+func SetupSourceAdapters(generic []*GenericSourceAdapter) error {
+	adapters := []SourceAdapter{CapiSourceAdapter{}}
+	if EnableHiveSource {
+		adapters = append(adapters, HiveSourceAdapter{})
+	}
+	names := map[string]bool{CapiSourceAdapter{}.Name(): true, HiveSourceAdapter{}.Name(): true}
+	for i, g := range generic {
+		if err := g.Compile(); err != nil {
+			return fmt.Errorf("source adapter %d: %w", i, err)
+		}
+		if names[g.Name()] {
+			return fmt.Errorf("source adapter %d: duplicate name %s", i, g.Name())
+		}
+		names[g.Name()] = true
+		adapters = append(adapters, g)
+	}
+	SourceAdapters = adapters
+	return nil
+}
+
+// SourceAdaptersForName returns the adapters a secret name may belong to.
+func SourceAdaptersForName(name string) []SourceAdapter {
+	var adapters []SourceAdapter
+	for _, adapter := range SourceAdapters {
+		if _, ok := adapter.ParseSecretName(name); ok {
+			adapters = append(adapters, adapter)
+		}
+	}
+	return adapters
+}
+
+// SourceAdapterForSecret returns the first adapter validating the secret, or the
+// validation error of the first candidate adapter when none does.
+func SourceAdapterForSecret(s *corev1.Secret, adapters []SourceAdapter) (SourceAdapter, error) {
+	var firstErr error
+	for _, adapter := range adapters {
+		err := adapter.Validate(s)
+		if err == nil {
+			return adapter, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = errors.New("no source adapter")
+	}
+	return nil, firstErr
+}
+
+// FetchOwnerMetadata returns labels and annotations of the object owning a cluster.
+// Missing objects or CRDs are not treated as errors, so plain kubeconfig secrets
+// keep working with empty metadata.
+func FetchOwnerMetadata(ctx context.Context, r client.Reader, gvk schema.GroupVersionKind, key types.NamespacedName) (ClusterMetadata, error) {
+	owner, err := fetchOwner(ctx, r, gvk, key)
+	if owner == nil || err != nil {
+		return ClusterMetadata{}, err
+	}
+	return ClusterMetadata{Labels: owner.GetLabels(), Annotations: owner.GetAnnotations()}, nil
+}
+
+// fetchOwner returns the object owning a cluster, or nil when the object or its CRD is missing.
+func fetchOwner(ctx context.Context, r client.Reader, gvk schema.GroupVersionKind, key types.NamespacedName) (*unstructured.Unstructured, error) {
+	owner := &unstructured.Unstructured{}
+	owner.SetGroupVersionKind(gvk)
+	err := r.Get(ctx, key, owner)
+	if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return owner, nil
+}
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// mockVClusterAdapter returns a generic adapter sourcing vcluster kubeconfig secrets.
+func mockVClusterAdapter() *GenericSourceAdapter {
+	return &GenericSourceAdapter{AdapterName: "vcluster", NamePattern: `^vc-(.+)$`, Key: "config"}
+}
+
+func TestGenericSourceAdapter(t *testing.T) {
+	t.Parallel()
+	g := mockVClusterAdapter()
+	assert.Nil(t, g.Compile())
+
+	cluster, ok := g.ParseSecretName("vc-tenant")
+	assert.True(t, ok)
+	assert.Equal(t, "tenant", cluster)
+	_, ok = g.ParseSecretName("tenant-kubeconfig")
+	assert.False(t, ok)
+
+	s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "vc-tenant"}, Data: map[string][]byte{"config": []byte("kubeconfig")}}
+	assert.Nil(t, g.Validate(s))
+	assert.Equal(t, "tenant", g.ClusterName(s))
+	assert.Equal(t, []byte("kubeconfig"), g.KubeConfig(s))
+
+	g.SecretType = CapiClusterSecretType
+	assert.EqualError(t, g.Validate(s), "wrong secret type")
+}
+
+func TestGenericSourceAdapterCompile(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		testName          string
+		testMock          *GenericSourceAdapter
+		testExpectedError bool
+	}{
+		{"test valid adapter", mockVClusterAdapter(), false},
+		{"test adapter with owner", &GenericSourceAdapter{AdapterName: "kamaji", NamePattern: `^(.+)-admin-kubeconfig$`, Key: "admin.conf",
+			Owner: &schema.GroupVersionKind{Group: "kamaji.clastix.io", Version: "v1alpha1", Kind: "TenantControlPlane"}}, false},
+		{"test adapter without capture group", &GenericSourceAdapter{AdapterName: "vcluster", NamePattern: `^vc-.+$`, Key: "config"}, true},
+		{"test adapter with invalid pattern", &GenericSourceAdapter{AdapterName: "vcluster", NamePattern: `(`, Key: "config"}, true},
+		{"test adapter without key", &GenericSourceAdapter{AdapterName: "vcluster", NamePattern: `^vc-(.+)$`}, true},
+		{"test adapter with partial owner", &GenericSourceAdapter{AdapterName: "vcluster", NamePattern: `^vc-(.+)$`, Key: "config",
+			Owner: &schema.GroupVersionKind{Kind: "VCluster"}}, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			err := tt.testMock.Compile()
+			if tt.testExpectedError {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestSourceAdapterSelection(t *testing.T) {
+	oldAdapters := SourceAdapters
+	defer func() { SourceAdapters = oldAdapters }()
+
+	assert.NotNil(t, SetupSourceAdapters([]*GenericSourceAdapter{{AdapterName: "capi", NamePattern: `^(.+)$`, Key: "value"}}))
+	assert.Nil(t, SetupSourceAdapters([]*GenericSourceAdapter{
+		mockVClusterAdapter(),
+		{AdapterName: "k0smotron", NamePattern: `^(.+)-kubeconfig$`, Key: "value", SecretType: "k0smotron.io/secret"},
+	}))
+
+	assert.Len(t, SourceAdaptersForName("vc-tenant"), 1)
+	assert.Len(t, SourceAdaptersForName("tenant-kubeconfig"), 2)
+	assert.Len(t, SourceAdaptersForName("tenant"), 0)
+
+	s := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "tenant-kubeconfig"},
+		Type:       "k0smotron.io/secret",
+		Data:       map[string][]byte{"value": []byte("kubeconfig")},
+	}
+	adapter, err := SourceAdapterForSecret(s, SourceAdaptersForName(s.Name))
+	assert.Nil(t, err)
+	assert.Equal(t, "k0smotron", adapter.Name())
+
+	// The CAPI validation error wins when no adapter matches.
+	s.Type = "tester/tester"
+	_, err = SourceAdapterForSecret(s, SourceAdaptersForName(s.Name))
+	assert.EqualError(t, err, "wrong secret type")
+}
+
+func TestCapiSourceAdapterClusterSecrets(t *testing.T) {
+	oldVariant := KubeConfigVariant
+	defer func() { KubeConfigVariant = oldVariant }()
+	KubeConfigVariant = KubeConfigVariantAdmin
+
+	adapter := CapiSourceAdapter{}
+	assert.Equal(t, []string{"test-kubeconfig", "test-user-kubeconfig"}, adapter.ClusterSecrets("test", nil))
+	assert.Equal(t, []string{"test-user-kubeconfig", "test-kubeconfig"},
+		adapter.ClusterSecrets("test", map[string]string{KubeConfigVariantAnnotation: KubeConfigVariantUser}))
+
+	cluster, ok := adapter.ParseSecretName("test-user-kubeconfig")
+	assert.True(t, ok)
+	assert.Equal(t, "test", cluster)
+}
+
+func TestFetchOwnerMetadata(t *testing.T) {
+	t.Parallel()
+	metadata, err := FetchOwnerMetadata(context.Background(), &mockClient{}, CapiClusterGVK, client.ObjectKey{Name: "test", Namespace: "test"})
+	assert.Nil(t, err)
+	assert.Equal(t, ClusterMetadata{}, metadata)
+}
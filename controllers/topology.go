@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// TopologyFieldClass selects spec.topology.class of a CAPI Cluster.
+	TopologyFieldClass = "class"
+	// TopologyFieldVersion selects spec.topology.version of a CAPI Cluster.
+	TopologyFieldVersion = "version"
+)
+
+var (
+	// TopologyRules map ClusterClass topology fields and variables to ArgoSecret labels and annotations.
+	TopologyRules []TopologyRule
+
+	// invalidLabelValueChars matches characters not allowed in label values.
+	invalidLabelValueChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+)
+
+// TopologyRule maps a topology field or variable of a CAPI Cluster to an ArgoSecret
+// label, annotation, or both.
+type TopologyRule struct {
+	// Field is class or version. Exactly one of Field and Variable must be set.
+	Field string `json:"field,omitempty"`
+	// Variable names a topology variable. Non string values are JSON encoded.
+	Variable   string `json:"variable,omitempty"`
+	Label      string `json:"label,omitempty"`
+	Annotation string `json:"annotation,omitempty"`
+}
+
+// ValidateTopologyRules validates that every rule selects a single value and a valid target.
+func ValidateTopologyRules(rules []TopologyRule) error {
+	for i, rule := range rules {
+		if (rule.Field == "") == (rule.Variable == "") {
+			return fmt.Errorf("rule %d: exactly one of field and variable must be set", i)
+		}
+		if rule.Field != "" && rule.Field != TopologyFieldClass && rule.Field != TopologyFieldVersion {
+			return fmt.Errorf("rule %d: unknown field %q", i, rule.Field)
+		}
+		if rule.Label == "" && rule.Annotation == "" {
+			return fmt.Errorf("rule %d: label or annotation must be set", i)
+		}
+		for _, key := range []string{rule.Label, rule.Annotation} {
+			if key == "" {
+				continue
+			}
+			if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+				return fmt.Errorf("rule %d: invalid key %q: %s", i, key, strings.Join(errs, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// SanitizeLabelValue turns a value into a valid label value, replacing invalid characters
+// with dashes and truncating it to 63 characters.
+func SanitizeLabelValue(v string) string {
+	v = invalidLabelValueChars.ReplaceAllString(v, "-")
+	if len(v) > validation.LabelValueMaxLength {
+		v = v[:validation.LabelValueMaxLength]
+	}
+	return strings.TrimFunc(v, func(r rune) bool {
+		return r == '-' || r == '_' || r == '.'
+	})
+}
+
+// topologyValue returns the value a rule selects on a CAPI Cluster, if any.
+func topologyValue(c *unstructured.Unstructured, rule TopologyRule) (string, bool) {
+	if rule.Field != "" {
+		v, _, _ := unstructured.NestedString(c.Object, "spec", "topology", rule.Field)
+		return v, v != ""
+	}
+	variables, _, _ := unstructured.NestedSlice(c.Object, "spec", "topology", "variables")
+	for _, item := range variables {
+		variable, ok := item.(map[string]interface{})
+		if !ok || variable["name"] != rule.Variable {
+			continue
+		}
+		switch v := variable["value"].(type) {
+		case nil:
+			return "", false
+		case string:
+			return v, v != ""
+		default:
+			raw, err := json.Marshal(v)
+			if err != nil {
+				return "", false
+			}
+			return string(raw), true
+		}
+	}
+	return "", false
+}
+
+// TopologyMetadata returns the ArgoSecret labels and annotations mapped from the topology
+// of a CAPI Cluster. Label values are sanitized, so they may differ from annotation values.
+func TopologyMetadata(c *unstructured.Unstructured, rules []TopologyRule) (map[string]string, map[string]string) {
+	labels, annotations := map[string]string{}, map[string]string{}
+	for _, rule := range rules {
+		v, ok := topologyValue(c, rule)
+		if !ok {
+			continue
+		}
+		if rule.Label != "" {
+			if sanitized := SanitizeLabelValue(v); sanitized != "" {
+				labels[rule.Label] = sanitized
+			}
+		}
+		if rule.Annotation != "" {
+			annotations[rule.Annotation] = v
+		}
+	}
+	return labels, annotations
+}
+
+// ManagedArgoLabels returns the ArgoSecret labels set by configuration, which are
+// removed once no longer desired.
+func ManagedArgoLabels() []string {
+	var keys []string
+	for _, rule := range TopologyRules {
+		if rule.Label != "" {
+			keys = append(keys, rule.Label)
+		}
+	}
+	if label := Maintenance.GetLabel(); label != "" {
+		keys = append(keys, label)
+	}
+	return keys
+}
+
+// ManagedArgoAnnotations returns the ArgoSecret annotations set by configuration, which
+// are removed once no longer desired.
+func ManagedArgoAnnotations() []string {
+	var keys []string
+	for _, rule := range TopologyRules {
+		if rule.Annotation != "" {
+			keys = append(keys, rule.Annotation)
+		}
+	}
+	if ClusterFactsDiscovery != nil {
+		keys = append(keys, ZonesAnnotation, RegionsAnnotation)
+	}
+	if HealthCheck != nil {
+		keys = append(keys, HealthAnnotation, HealthConditionAnnotation)
+	}
+	if CredentialRotation != nil {
+		keys = append(keys, RotatedAtAnnotation, RejectedConfigAnnotation, RejectedAtAnnotation)
+	}
+	if Maintenance != nil && Maintenance.Annotation != "" {
+		keys = append(keys, Maintenance.Annotation)
+	}
+	if len(ApprovalRules) > 0 {
+		keys = append(keys, ApprovalStateAnnotation, ApprovedByAnnotation, ApprovalExpiresAnnotation)
+	}
+	return keys
+}
+
+// CapiClusterRequests maps a CAPI Cluster to its preferred existing kubeconfig secret, so
+// topology changes such as upgrades are reflected on its ArgoSecrets.
+func (r *Capi2Argo) CapiClusterRequests(ctx context.Context, obj client.Object) []reconcile.Request {
+	adapter := CapiSourceAdapter{}
+	for _, name := range adapter.ClusterSecrets(obj.GetName(), obj.GetAnnotations()) {
+		key := types.NamespacedName{Name: name, Namespace: obj.GetNamespace()}
+		var s corev1.Secret
+		err := r.Get(ctx, key, &s)
+		if client.IgnoreNotFound(err) != nil {
+			r.Log.Error(err, "Failed to fetch kubeconfig secret of CAPI Cluster", "cluster", client.ObjectKeyFromObject(obj))
+			return nil
+		}
+		if err == nil {
+			return []reconcile.Request{{NamespacedName: key}}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestValidateTopologyRules(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		testName          string
+		testMock          []TopologyRule
+		testExpectedError bool
+	}{
+		{"test empty rules", nil, false},
+		{"test field rule", []TopologyRule{{Field: "class", Label: "cluster.x-k8s.io/class"}}, false},
+		{"test variable rule", []TopologyRule{{Variable: "region", Label: "region", Annotation: "example.com/region"}}, false},
+		{"test unknown field", []TopologyRule{{Field: "workers", Label: "workers"}}, true},
+		{"test field and variable", []TopologyRule{{Field: "class", Variable: "region", Label: "region"}}, true},
+		{"test missing selection", []TopologyRule{{Label: "region"}}, true},
+		{"test missing target", []TopologyRule{{Field: "version"}}, true},
+		{"test invalid label", []TopologyRule{{Field: "version", Label: "not a label"}}, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateTopologyRules(tt.testMock)
+			if !tt.testExpectedError {
+				assert.Nil(t, err)
+			} else {
+				assert.NotNil(t, err)
+			}
+		})
+	}
+}
+
+func TestSanitizeLabelValue(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		testName string
+		testMock string
+		expected string
+	}{
+		{"test valid value", "v1.29.2", "v1.29.2"},
+		{"test build metadata", "v1.29.2+rke2r1", "v1.29.2-rke2r1"},
+		{"test json value", `{"size":"large"}`, "size-large"},
+		{"test long value", strings.Repeat("a", 70), strings.Repeat("a", 63)},
+		{"test invalid value", "+++", ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.expected, SanitizeLabelValue(tt.testMock))
+		})
+	}
+}
+
+func TestTopologyMetadata(t *testing.T) {
+	t.Parallel()
+	c := MockCapiTopologyCluster("test")
+	variables, _, _ := unstructured.NestedSlice(c.Object, "spec", "topology", "variables")
+	variables = append(variables, map[string]interface{}{"name": "nodes", "value": map[string]interface{}{"min": int64(1)}})
+	assert.Nil(t, unstructured.SetNestedSlice(c.Object, variables, "spec", "topology", "variables"))
+
+	labels, annotations := TopologyMetadata(c, []TopologyRule{
+		{Field: "class", Label: "cluster.x-k8s.io/class"},
+		{Field: "version", Label: "kubernetes-version", Annotation: "example.com/version"},
+		{Variable: "region", Annotation: "example.com/region"},
+		{Variable: "nodes", Annotation: "example.com/nodes"},
+		{Variable: "missing", Label: "missing"},
+	})
+	assert.Equal(t, map[string]string{
+		"cluster.x-k8s.io/class": "aws-default",
+		"kubernetes-version":     "v1.29.2",
+	}, labels)
+	assert.Equal(t, map[string]string{
+		"example.com/version": "v1.29.2",
+		"example.com/region":  "eu-west-1",
+		"example.com/nodes":   `{"min":1}`,
+	}, annotations)
+}
+
+func TestCapiSourceAdapterFetchMetadata(t *testing.T) {
+	oldRules := TopologyRules
+	defer func() { TopologyRules = oldRules }()
+	TopologyRules = []TopologyRule{{Field: "version", Label: "kubernetes-version"}}
+
+	r := &mockClient{objects: []*unstructured.Unstructured{MockCapiTopologyCluster("test")}}
+	metadata, err := CapiSourceAdapter{}.FetchMetadata(context.Background(), r, "test", "test")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"kubernetes-version": "v1.29.2"}, metadata.ArgoLabels)
+	assert.Empty(t, metadata.ArgoAnnotations)
+}
+
+func TestSyncArgoSecretAnnotations(t *testing.T) {
+	oldRules := TopologyRules
+	defer func() { TopologyRules = oldRules }()
+	TopologyRules = []TopologyRule{{Field: "version", Label: "kubernetes-version", Annotation: "example.com/version"}}
+
+	desired := MockArgoSecret()
+	desired.Annotations = map[string]string{"example.com/version": "v1.29.2"}
+	existing := MockArgoSecret()
+	existing.Annotations = map[string]string{BootstrappedAnnotation: "true"}
+	assert.True(t, SyncArgoSecretAnnotations(existing, desired))
+	assert.False(t, SyncArgoSecretAnnotations(existing, desired))
+	assert.Equal(t, "v1.29.2", existing.Annotations["example.com/version"])
+
+	desired.Annotations = nil
+	assert.True(t, SyncArgoSecretAnnotations(existing, desired))
+	assert.Equal(t, map[string]string{BootstrappedAnnotation: "true"}, existing.Annotations)
+
+	existing.Labels["kubernetes-version"] = "v1.29.2"
+	assert.True(t, SyncArgoSecretLabels(existing, desired))
+	assert.NotContains(t, existing.Labels, "kubernetes-version")
+}
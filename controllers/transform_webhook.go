@@ -0,0 +1,389 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	goErr "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// TransformPolicyFail holds back clusters when the transformation webhook fails.
+	TransformPolicyFail = "fail"
+	// TransformPolicyIgnore registers clusters untransformed when the transformation webhook fails.
+	TransformPolicyIgnore = "ignore"
+
+	// operatorLabelPrefix prefixes ArgoSecret labels and annotations owned by the
+	// operator, which the transformation webhook may not change.
+	operatorLabelPrefix = "capi-to-argocd/"
+
+	defaultTransformTimeout    = 10 * time.Second
+	defaultTransformRetryAfter = 5 * time.Minute
+	maxTransformResponse       = 1 << 20
+)
+
+var (
+	// TransformWebhook mutates or rejects ArgoClusters before they are registered, when set.
+	TransformWebhook *TransformWebhookConfig
+
+	// ErrClusterRejected is returned for clusters rejected by the transformation webhook.
+	// Rejections hold back changes only, clusters registered already being kept.
+	ErrClusterRejected = goErr.New("cluster rejected by transformation webhook")
+)
+
+// TransformWebhookConfig configures the HTTP(S) callout transforming ArgoClusters.
+type TransformWebhookConfig struct {
+	// URL the TransformRequest is POSTed to.
+	URL string `json:"url"`
+	// Timeout of the callout, defaulting to 10s.
+	Timeout string `json:"timeout,omitempty"`
+	// FailurePolicy is fail or ignore, defaulting to fail.
+	FailurePolicy string `json:"failurePolicy,omitempty"`
+	// RetryAfter is how long rejected clusters wait for another callout, defaulting to 5m.
+	RetryAfter string `json:"retryAfter,omitempty"`
+	// CAFile holds the CAs trusted to serve the webhook, on top of the system ones.
+	CAFile string `json:"caFile,omitempty"`
+	// CertFile and KeyFile hold the client certificate presented to the webhook, for mTLS.
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	// AllowedServers are regular expressions matching the whole https URL the webhook may
+	// point clusters to. Credentials are sent to that server, so the server is kept as
+	// proposed unless allowed.
+	AllowedServers []string `json:"allowedServers,omitempty"`
+
+	mu sync.Mutex
+	// client is reused across callouts until the TLS files it was built from change.
+	client     *http.Client
+	tlsModTime []time.Time
+}
+
+// TransformSource describes the cluster an ArgoCluster was built from.
+type TransformSource struct {
+	Adapter     string            `json:"adapter"`
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// TransformCluster is the representation of an ArgoCluster exchanged with the webhook.
+// Credentials are never sent.
+type TransformCluster struct {
+	Name        string            `json:"name"`
+	Server      string            `json:"server"`
+	Project     string            `json:"project,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// TransformRequest is sent to the transformation webhook.
+type TransformRequest struct {
+	Source  TransformSource  `json:"source"`
+	Cluster TransformCluster `json:"cluster"`
+}
+
+// TransformResponse is expected from the transformation webhook. Allowed responses
+// without a cluster keep the proposed one.
+type TransformResponse struct {
+	Allowed bool              `json:"allowed"`
+	Reason  string            `json:"reason,omitempty"`
+	Cluster *TransformCluster `json:"cluster,omitempty"`
+}
+
+// GetTimeout returns the timeout of the callout.
+func (w *TransformWebhookConfig) GetTimeout() time.Duration {
+	if w == nil {
+		return defaultTransformTimeout
+	}
+	if d, err := time.ParseDuration(w.Timeout); err == nil && w.Timeout != "" {
+		return d
+	}
+	return defaultTransformTimeout
+}
+
+// GetRetryAfter returns how long rejected clusters wait for another callout.
+func (w *TransformWebhookConfig) GetRetryAfter() time.Duration {
+	if w == nil {
+		return defaultTransformRetryAfter
+	}
+	if d, err := time.ParseDuration(w.RetryAfter); err == nil && w.RetryAfter != "" {
+		return d
+	}
+	return defaultTransformRetryAfter
+}
+
+// GetFailurePolicy returns the policy applied when the callout fails.
+func (w *TransformWebhookConfig) GetFailurePolicy() string {
+	if w == nil || w.FailurePolicy == "" {
+		return TransformPolicyFail
+	}
+	return w.FailurePolicy
+}
+
+// ValidateTransformWebhook validates the URL, timeout, failure policy and TLS files.
+func ValidateTransformWebhook(w *TransformWebhookConfig) error {
+	if w == nil {
+		return nil
+	}
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid url %q", w.URL)
+	}
+	for _, v := range []string{w.Timeout, w.RetryAfter} {
+		if v == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+			return fmt.Errorf("invalid duration %q", v)
+		}
+	}
+	switch w.FailurePolicy {
+	case "", TransformPolicyFail, TransformPolicyIgnore:
+	default:
+		return fmt.Errorf("unknown failurePolicy %q", w.FailurePolicy)
+	}
+	if (w.CertFile == "") != (w.KeyFile == "") {
+		return goErr.New("certFile and keyFile must be set together")
+	}
+	for _, pattern := range w.AllowedServers {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid allowedServers pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// ServerAllowed reports whether the webhook may point clusters to server, being an https
+// URL without user info matching one of AllowedServers.
+func (w *TransformWebhookConfig) ServerAllowed(server string) bool {
+	if w == nil {
+		return false
+	}
+	u, err := url.Parse(server)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil {
+		return false
+	}
+	for _, pattern := range w.AllowedServers {
+		if re, err := regexp.Compile("^(?:" + pattern + ")$"); err == nil && re.MatchString(server) {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsFilesModTime returns the modification times of the configured TLS files.
+func (w *TransformWebhookConfig) tlsFilesModTime() ([]time.Time, error) {
+	var modTime []time.Time
+	for _, file := range []string{w.CAFile, w.CertFile, w.KeyFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTime = append(modTime, info.ModTime())
+	}
+	return modTime, nil
+}
+
+// httpClient returns the client for the webhook. It is built once and rebuilt when the
+// TLS files change, so rotated certificates are picked up.
+func (w *TransformWebhookConfig) httpClient() (*http.Client, error) {
+	modTime, err := w.tlsFilesModTime()
+	if err != nil {
+		return nil, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.client != nil && equalTimes(w.tlsModTime, modTime) {
+		return w.client, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if w.CAFile != "" {
+		ca, err := os.ReadFile(w.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", w.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if w.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(w.CertFile, w.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if w.client != nil {
+		w.client.CloseIdleConnections()
+	}
+	w.client = &http.Client{
+		Timeout:   w.GetTimeout(),
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+	}
+	w.tlsModTime = modTime
+	return w.client, nil
+}
+
+// equalTimes reports whether two lists hold the same times.
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Call sends a TransformRequest to the webhook and returns its response.
+func (w *TransformWebhookConfig) Call(ctx context.Context, req TransformRequest) (*TransformResponse, error) {
+	c, err := w.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, w.GetTimeout())
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxTransformResponse))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(raw)))
+	}
+	var out TransformResponse
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	if out.Allowed && out.Cluster != nil && (out.Cluster.Name == "" || out.Cluster.Server == "") {
+		return nil, goErr.New("invalid response: cluster requires name and server")
+	}
+	return &out, nil
+}
+
+// NewTransformCluster returns the representation of an ArgoCluster sent to the webhook.
+func NewTransformCluster(a *ArgoCluster) TransformCluster {
+	return TransformCluster{
+		Name:        a.ClusterName,
+		Server:      a.ClusterServer,
+		Project:     a.ClusterProject,
+		Labels:      a.ClusterLabels,
+		Annotations: a.ClusterAnnotations,
+	}
+}
+
+// Apply sets the mutable fields of an ArgoCluster from the webhook representation.
+// Labels and annotations owned by the operator are kept as proposed. The server is
+// expected to be checked against AllowedServers beforehand.
+func (t *TransformCluster) Apply(a *ArgoCluster) {
+	a.ClusterName, a.ClusterServer, a.ClusterProject = t.Name, t.Server, t.Project
+	a.ClusterLabels = keepOperatorKeys(t.Labels, a.ClusterLabels)
+	a.ClusterAnnotations = keepOperatorKeys(t.Annotations, a.ClusterAnnotations)
+}
+
+// keepOperatorKeys returns the transformed metadata with the keys owned by the operator
+// reset to the proposed ones.
+func keepOperatorKeys(transformed, proposed map[string]string) map[string]string {
+	out := map[string]string{}
+	for key, value := range transformed {
+		if !isOperatorKey(key) {
+			out[key] = value
+		}
+	}
+	for key, value := range proposed {
+		if isOperatorKey(key) {
+			out[key] = value
+		}
+	}
+	return out
+}
+
+// isOperatorKey reports whether a label or annotation is owned by the operator, including
+// the common labels Argo and the operator select ArgoSecrets by.
+func isOperatorKey(key string) bool {
+	if strings.HasPrefix(key, operatorLabelPrefix) {
+		return true
+	}
+	_, ok := GetArgoCommonLabels()[key]
+	return ok
+}
+
+// TransformArgoCluster lets the transformation webhook mutate or reject an ArgoCluster.
+// Failed callouts hold back the cluster unless the failure policy is ignore. Rejected
+// clusters are held back as well, keeping the ArgoSecrets registered before.
+func (r *Capi2Argo) TransformArgoCluster(ctx context.Context, log logr.Logger, capiCluster *CapiCluster, target client.Object, source string, a *ArgoCluster) error {
+	req := TransformRequest{
+		Source: TransformSource{
+			Adapter:     source,
+			Name:        capiCluster.Name,
+			Namespace:   capiCluster.Namespace,
+			Labels:      capiCluster.Labels,
+			Annotations: capiCluster.Annotations,
+		},
+		Cluster: NewTransformCluster(a),
+	}
+	resp, err := TransformWebhook.Call(ctx, req)
+	if err != nil {
+		r.recordEvent(target, corev1.EventTypeWarning, "TransformFailed", err.Error())
+		if TransformWebhook.GetFailurePolicy() == TransformPolicyIgnore {
+			log.Info("Transformation webhook failed, registering cluster untransformed", "message", err.Error())
+			return nil
+		}
+		log.Error(err, "Failed to transform ArgoCluster")
+		return err
+	}
+	if !resp.Allowed {
+		log.Info("Cluster rejected by transformation webhook", "reason", resp.Reason)
+		r.recordEvent(target, corev1.EventTypeWarning, "TransformRejected", resp.Reason)
+		return ErrClusterRejected
+	}
+	if resp.Cluster != nil {
+		if resp.Cluster.Server != a.ClusterServer && !TransformWebhook.ServerAllowed(resp.Cluster.Server) {
+			log.Info("Ignoring server not allowed for the transformation webhook", "server", resp.Cluster.Server)
+			r.recordEvent(target, corev1.EventTypeWarning, "TransformServerIgnored", fmt.Sprintf("Ignoring server %s, not allowed for the transformation webhook", resp.Cluster.Server))
+			resp.Cluster.Server = a.ClusterServer
+		}
+		resp.Cluster.Apply(a)
+	}
+	return nil
+}
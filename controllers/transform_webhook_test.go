@@ -0,0 +1,378 @@
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	b64 "encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// mockTransformServer answers with a transformation prefixing the cluster name, adding a
+// team label and annotation and overriding operator and Argo ones, or rejects clusters of the denied
+// namespace.
+func mockTransformServer() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var in TransformRequest
+		if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if in.Source.Namespace == "denied" {
+			_ = json.NewEncoder(w).Encode(TransformResponse{Reason: "namespace is not onboarded"})
+			return
+		}
+		out := in.Cluster
+		out.Name = "org-" + out.Name
+		out.Project = in.Source.Labels["team"]
+		out.Labels = map[string]string{"team": in.Source.Labels["team"], "capi-to-argocd/cluster-namespace": "other", "argocd.argoproj.io/secret-type": "repository"}
+		out.Annotations = map[string]string{"team": in.Source.Labels["team"], ApprovalStateAnnotation: ApprovalStateApproved}
+		_ = json.NewEncoder(w).Encode(TransformResponse{Allowed: true, Cluster: &out})
+	})
+}
+
+// mockClientCertificate writes a self-signed client certificate and its key to dir.
+func mockClientCertificate(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "capi2argo"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(raw)
+	assert.Nil(t, err)
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw}), 0o600))
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}), 0o600))
+	return certFile, keyFile, cert
+}
+
+// mockTransformRequest returns a request for a cluster of given namespace.
+func mockTransformRequest(namespace string) TransformRequest {
+	return TransformRequest{
+		Source: TransformSource{Adapter: "capi", Name: "test", Namespace: namespace, Labels: map[string]string{"team": "payments"}},
+		Cluster: TransformCluster{
+			Name:   "test",
+			Server: "https://test.example.com",
+			Labels: map[string]string{"capi-to-argocd/cluster-namespace": namespace},
+		},
+	}
+}
+
+func TestTransformWebhookCall(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(mockTransformServer())
+	t.Cleanup(server.Close)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	t.Cleanup(failing.Close)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	t.Cleanup(slow.Close)
+	invalid := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"allowed":true,"cluster":{"name":"test"}}`))
+	}))
+	t.Cleanup(invalid.Close)
+
+	tests := []struct {
+		testName          string
+		testMock          *TransformWebhookConfig
+		namespace         string
+		allowed           bool
+		testExpectedError bool
+	}{
+		{"test transformed", &TransformWebhookConfig{URL: server.URL}, "test", true, false},
+		{"test rejected", &TransformWebhookConfig{URL: server.URL}, "denied", false, false},
+		{"test failing webhook", &TransformWebhookConfig{URL: failing.URL}, "test", false, true},
+		{"test timeout", &TransformWebhookConfig{URL: slow.URL, Timeout: "50ms"}, "test", false, true},
+		{"test invalid cluster", &TransformWebhookConfig{URL: invalid.URL}, "test", false, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			resp, err := tt.testMock.Call(context.Background(), mockTransformRequest(tt.namespace))
+			if tt.testExpectedError {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.allowed, resp.Allowed)
+		})
+	}
+}
+
+func TestTransformWebhookCallReusesConnections(t *testing.T) {
+	t.Parallel()
+	var opened atomic.Int32
+	server := httptest.NewUnstartedServer(mockTransformServer())
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			opened.Add(1)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+
+	w := &TransformWebhookConfig{URL: server.URL}
+	for i := 0; i < 3; i++ {
+		_, err := w.Call(context.Background(), mockTransformRequest("test"))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, int32(1), opened.Load())
+}
+
+func TestTransformWebhookClientRebuiltOnRotation(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	certFile, keyFile, _ := mockClientCertificate(t, dir)
+	w := &TransformWebhookConfig{CertFile: certFile, KeyFile: keyFile}
+
+	c1, err := w.httpClient()
+	assert.Nil(t, err)
+	c2, err := w.httpClient()
+	assert.Nil(t, err)
+	assert.Same(t, c1, c2)
+
+	rotated := time.Now().Add(time.Hour)
+	assert.Nil(t, os.Chtimes(certFile, rotated, rotated))
+	c3, err := w.httpClient()
+	assert.Nil(t, err)
+	assert.NotSame(t, c1, c3)
+}
+
+func TestTransformWebhookNilDefaults(t *testing.T) {
+	t.Parallel()
+	var w *TransformWebhookConfig
+	assert.Equal(t, defaultTransformTimeout, w.GetTimeout())
+	assert.Equal(t, TransformPolicyFail, w.GetFailurePolicy())
+}
+
+func TestTransformWebhookMutualTLS(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	certFile, keyFile, clientCert := mockClientCertificate(t, dir)
+
+	server := httptest.NewUnstartedServer(mockTransformServer())
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	caFile := filepath.Join(dir, "ca.crt")
+	assert.Nil(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600))
+
+	resp, err := (&TransformWebhookConfig{URL: server.URL, CAFile: caFile, CertFile: certFile, KeyFile: keyFile}).Call(context.Background(), mockTransformRequest("test"))
+	assert.Nil(t, err)
+	assert.True(t, resp.Allowed)
+
+	// The client certificate is required.
+	_, err = (&TransformWebhookConfig{URL: server.URL, CAFile: caFile}).Call(context.Background(), mockTransformRequest("test"))
+	assert.NotNil(t, err)
+	// The server certificate must be trusted.
+	_, err = (&TransformWebhookConfig{URL: server.URL, CertFile: certFile, KeyFile: keyFile}).Call(context.Background(), mockTransformRequest("test"))
+	assert.NotNil(t, err)
+}
+
+func TestTransformArgoCluster(t *testing.T) {
+	old := TransformWebhook
+	defer func() { TransformWebhook = old }()
+
+	server := httptest.NewServer(mockTransformServer())
+	defer server.Close()
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	r := &Capi2Argo{Log: logr.Discard()}
+	capiSecret := &corev1.Secret{}
+
+	tests := []struct {
+		testName      string
+		testMock      *TransformWebhookConfig
+		namespace     string
+		expectedErr   error
+		expectedName  string
+		expectedError bool
+	}{
+		{"test transformed", &TransformWebhookConfig{URL: server.URL}, "test", nil, "org-test", false},
+		{"test rejected", &TransformWebhookConfig{URL: server.URL}, "denied", ErrClusterRejected, "test", true},
+		{"test failure policy fail", &TransformWebhookConfig{URL: unreachable.URL}, "test", nil, "test", true},
+		{"test failure policy ignore", &TransformWebhookConfig{URL: unreachable.URL, FailurePolicy: TransformPolicyIgnore}, "test", nil, "test", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			TransformWebhook = tt.testMock
+			capiCluster := NewCapiCluster("test", tt.namespace)
+			capiCluster.Labels = map[string]string{"team": "payments"}
+			a := &ArgoCluster{
+				ClusterName:        "test",
+				ClusterServer:      "https://test.example.com",
+				ClusterLabels:      map[string]string{"capi-to-argocd/cluster-namespace": tt.namespace},
+				ClusterAnnotations: map[string]string{HealthAnnotation: HealthReasonHealthy},
+			}
+
+			err := r.TransformArgoCluster(context.Background(), logr.Discard(), capiCluster, capiSecret, "capi", a)
+			assert.Equal(t, tt.expectedError, err != nil)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+			}
+			assert.Equal(t, tt.expectedName, a.ClusterName)
+			if tt.expectedName == "org-test" {
+				assert.Equal(t, "payments", a.ClusterProject)
+				assert.Equal(t, map[string]string{"team": "payments", "capi-to-argocd/cluster-namespace": tt.namespace}, a.ClusterLabels)
+				assert.Equal(t, map[string]string{"team": "payments", HealthAnnotation: HealthReasonHealthy}, a.ClusterAnnotations)
+			}
+		})
+	}
+}
+
+func TestKeepOperatorKeys(t *testing.T) {
+	t.Parallel()
+	transformed := map[string]string{
+		"team":                           "payments",
+		"argocd.argoproj.io/secret-type": "repository",
+		"capi-to-argocd/owned":           "false",
+	}
+	proposed := map[string]string{"argocd.argoproj.io/secret-type": "cluster", "capi-to-argocd/cluster-namespace": "test"}
+	assert.Equal(t, map[string]string{
+		"team":                             "payments",
+		"argocd.argoproj.io/secret-type":   "cluster",
+		"capi-to-argocd/cluster-namespace": "test",
+	}, keepOperatorKeys(transformed, proposed))
+}
+
+func TestValidateTransformWebhook(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		testName          string
+		testMock          *TransformWebhookConfig
+		testExpectedError bool
+	}{
+		{"test disabled", nil, false},
+		{"test defaults", &TransformWebhookConfig{URL: "https://transform.example.com/argo"}, false},
+		{"test mtls", &TransformWebhookConfig{URL: "https://transform.example.com", Timeout: "2s", FailurePolicy: TransformPolicyIgnore,
+			CAFile: "/tls/ca.crt", CertFile: "/tls/tls.crt", KeyFile: "/tls/tls.key"}, false},
+		{"test missing url", &TransformWebhookConfig{}, true},
+		{"test invalid scheme", &TransformWebhookConfig{URL: "ftp://transform.example.com"}, true},
+		{"test invalid timeout", &TransformWebhookConfig{URL: "https://transform.example.com", Timeout: "soon"}, true},
+		{"test invalid retry after", &TransformWebhookConfig{URL: "https://transform.example.com", RetryAfter: "-1m"}, true},
+		{"test unknown failure policy", &TransformWebhookConfig{URL: "https://transform.example.com", FailurePolicy: "retry"}, true},
+		{"test missing key file", &TransformWebhookConfig{URL: "https://transform.example.com", CertFile: "/tls/tls.crt"}, true},
+		{"test allowed servers", &TransformWebhookConfig{URL: "https://transform.example.com", AllowedServers: []string{`https://.*\.example\.com`}}, false},
+		{"test invalid allowed servers", &TransformWebhookConfig{URL: "https://transform.example.com", AllowedServers: []string{"("}}, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateTransformWebhook(tt.testMock)
+			if !tt.testExpectedError {
+				assert.Nil(t, err)
+			} else {
+				assert.NotNil(t, err)
+			}
+		})
+	}
+}
+
+func TestRegisterClusterTransformsBeforeProbe(t *testing.T) {
+	oldWebhook, oldHealthCheck, oldSinks := TransformWebhook, HealthCheck, Sinks
+	defer func() { TransformWebhook, HealthCheck, Sinks = oldWebhook, oldHealthCheck, oldSinks }()
+
+	health := mockHealthCheckServer()
+	defer health.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: health.Certificate().Raw})
+	unreachable := mockHealthCheckServer()
+	unreachable.Close()
+
+	// The webhook points clusters to an endpoint reachable from Argo.
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var in TransformRequest
+		if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		out := in.Cluster
+		out.Server = health.URL
+		_ = json.NewEncoder(w).Encode(TransformResponse{Allowed: true, Cluster: &out})
+	}))
+	defer webhook.Close()
+	HealthCheck = &HealthCheckConfig{Policy: HealthPolicyHoldBack}
+	Sinks = nil
+
+	capiCluster := NewCapiCluster("test", "test")
+	capiCluster.KubeConfig = KubeConfig{
+		Clusters: []Cluster{{Name: "test", Cluster: ClusterInfo{Server: unreachable.URL, CaData: b64.StdEncoding.EncodeToString(ca)}}},
+		Users:    []User{{Name: "test", User: UserInfo{Token: "tester"}}},
+	}
+	r := &Capi2Argo{Log: logr.Discard()}
+	source := client.ObjectKey{Name: "test-kubeconfig", Namespace: "test"}
+
+	// Servers not allowed are ignored, so the cluster is probed where it was proposed.
+	TransformWebhook = &TransformWebhookConfig{URL: webhook.URL}
+	assert.NotNil(t, r.registerCluster(context.Background(), logr.Discard(), capiCluster, source, &corev1.Secret{}, "capi", ClusterMetadata{}))
+
+	TransformWebhook = &TransformWebhookConfig{URL: webhook.URL, AllowedServers: []string{regexp.QuoteMeta(health.URL)}}
+	assert.Nil(t, r.registerCluster(context.Background(), logr.Discard(), capiCluster, source, &corev1.Secret{}, "capi", ClusterMetadata{}))
+}
+
+func TestTransformWebhookServerAllowed(t *testing.T) {
+	t.Parallel()
+	w := &TransformWebhookConfig{AllowedServers: []string{`https://[a-z0-9-]+\.clusters\.example\.com(:6443)?`}}
+	tests := []struct {
+		testName string
+		server   string
+		expected bool
+	}{
+		{"test allowed", "https://test.clusters.example.com:6443", true},
+		{"test other domain", "https://test.clusters.example.com.attacker.io", false},
+		{"test plain http", "http://test.clusters.example.com", false},
+		{"test user info", "https://user@test.clusters.example.com", false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.testName, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.expected, w.ServerAllowed(tt.server))
+		})
+	}
+	assert.False(t, (&TransformWebhookConfig{}).ServerAllowed("https://test.clusters.example.com"))
+	assert.False(t, (*TransformWebhookConfig)(nil).ServerAllowed("https://test.clusters.example.com"))
+}
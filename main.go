@@ -70,6 +70,11 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if err := controllers.ValidateConfig(); err != nil {
+		setupLog.Error(err, "invalid configuration")
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
@@ -97,14 +102,43 @@ func main() {
 	}
 
 	if err = (&controllers.Capi2Argo{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("capi2argo"),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("capi2argo"),
+		Scheme:   mgr.GetScheme(),
+		Hubs:     controllers.NewArgoHubClients(mgr.GetClient(), mgr.GetScheme(), controllers.ArgoHubs),
+		Recorder: mgr.GetEventRecorderFor("capi2argo"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Capi2Argo")
 		os.Exit(1)
 	}
 
+	if controllers.EnableGardenerSource {
+		if err = (&controllers.GardenerShoots{
+			Capi2Argo: &controllers.Capi2Argo{
+				Client:   mgr.GetClient(),
+				Log:      ctrl.Log.WithName("gardener"),
+				Scheme:   mgr.GetScheme(),
+				Hubs:     controllers.NewArgoHubClients(mgr.GetClient(), mgr.GetScheme(), controllers.ArgoHubs),
+				Recorder: mgr.GetEventRecorderFor("gardener"),
+			},
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "GardenerShoots")
+			os.Exit(1)
+		}
+	}
+
+	if controllers.PluginGenerator != nil {
+		if err := mgr.Add(&controllers.PluginGeneratorServer{
+			Client: mgr.GetClient(),
+			Log:    ctrl.Log.WithName("plugin-generator"),
+			Config: controllers.PluginGenerator,
+			Hubs:   controllers.NewArgoHubClients(mgr.GetClient(), mgr.GetScheme(), controllers.ArgoHubs),
+		}); err != nil {
+			setupLog.Error(err, "unable to add ApplicationSet plugin generator")
+			os.Exit(1)
+		}
+	}
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")